@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	accessLogOutput io.Writer = os.Stdout
+	accessLogLock             = new(sync.Mutex)
+)
+
+type responseRecorder struct {
+	http.ResponseWriter
+
+	bytes  int64
+	status int
+}
+
+func (a *responseRecorder) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes the connection on for WebSocket upgrades
+func (a *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(a.ResponseWriter).Hijack()
+	if err == nil {
+		a.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap gives http.ResponseController access to the connection
+func (a *responseRecorder) Unwrap() http.ResponseWriter { return a.ResponseWriter }
+
+func (a *responseRecorder) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+
+	n, err := a.ResponseWriter.Write(p)
+	a.bytes += int64(n)
+	return n, err
+}
+
+func (a *responseRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func accessLogHandler(next http.Handler) http.Handler {
+	if cfg.AccessLog == "none" {
+		return next
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		var (
+			start = time.Now()
+			w     = &responseRecorder{ResponseWriter: res}
+		)
+
+		next.ServeHTTP(w, r)
+
+		if w.status == 0 {
+			// Handler did not write anything, net/http will send a 200
+			w.status = http.StatusOK
+		}
+
+		if err := writeAccessLog(r, w, start); err != nil {
+			log.WithError(err).Error("Unable to write access log")
+		}
+	})
+}
+
+func writeAccessLog(r *http.Request, w *responseRecorder, start time.Time) error {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	var line []byte
+
+	switch cfg.AccessLog {
+	case "common", "combined":
+		size := "-"
+		if w.bytes > 0 {
+			size = strconv.FormatInt(w.bytes, 10)
+		}
+
+		line = []byte(fmt.Sprintf("%s - - [%s] %q %d %s",
+			host, start.Format(accessLogTimeFormat),
+			fmt.Sprintf("%s %s %s", r.Method, redactToken(r.RequestURI), r.Proto),
+			w.status, size))
+
+		if cfg.AccessLog == "combined" {
+			line = append(line, []byte(fmt.Sprintf(" %q %q", redactToken(r.Referer()), r.UserAgent()))...)
+		}
+
+	case "json":
+		if line, err = json.Marshal(map[string]interface{}{
+			"bytes":       w.bytes,
+			"duration":    time.Since(start).Seconds(),
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"remote_addr": host,
+			"status":      w.status,
+			"time":        start.Format(time.RFC3339),
+			"user_agent":  r.UserAgent(),
+		}); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown access log format %q", cfg.AccessLog)
+	}
+
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+
+	_, err = accessLogOutput.Write(append(line, '\n'))
+	return err
+}
+
+// redactToken replaces the API token passed as parameter in the URI to
+// keep it out of the access log
+func redactToken(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || !u.Query().Has("token") {
+		return uri
+	}
+
+	q := u.Query()
+	q.Set("token", "redacted")
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
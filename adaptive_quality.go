@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	adaptiveQualityInterval = 10 * time.Second
+	// adaptiveQualityRecover is the fraction of the thresholds the load
+	// has to stay below to step the quality back up
+	adaptiveQualityRecover = 0.7
+	// adaptiveQualityRecoverChecks is the number of consecutive checks
+	// below the recover threshold before stepping back up to not restart
+	// ffmpeg over and over when the load is close to the thresholds
+	adaptiveQualityRecoverChecks = 6
+	adaptiveQualityStep          = 3
+)
+
+var (
+	// adaptiveBandwidth is the parsed --adaptive-bandwidth
+	adaptiveBandwidth int64
+	// qualityPenalty is added to the configured quality while under load
+	qualityPenalty int64
+	// sentBytes counts the frame bytes written to all MJPEG clients
+	sentBytes int64
+)
+
+// effectiveQuality returns the quality to pass to ffmpeg, the caller
+// must hold the cfgLock
+func effectiveQuality() int {
+	q := cfg.Quality + int(atomic.LoadInt64(&qualityPenalty))
+	return max(min(q, 31), cfg.Quality)
+}
+
+// runAdaptiveQuality steps the encoder quality down while the client
+// count or the output bandwidth exceed their thresholds and back up
+// when the load dropped until the context is cancelled
+func runAdaptiveQuality(ctx context.Context) {
+	t := time.NewTicker(adaptiveQualityInterval)
+	defer t.Stop()
+
+	var (
+		lastBytes = atomic.LoadInt64(&sentBytes)
+		lastCheck = time.Now()
+		relaxed   int
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			bytes := atomic.LoadInt64(&sentBytes)
+			bandwidth := float64(bytes-lastBytes) / now.Sub(lastCheck).Seconds()
+			lastBytes, lastCheck = bytes, now
+
+			clients := frameBroadcaster.Clients()
+			penalty := atomic.LoadInt64(&qualityPenalty)
+
+			cfgLock.RLock()
+			worst := int64(cfg.AdaptiveMaxQuality - cfg.Quality)
+			cfgLock.RUnlock()
+
+			next := penalty
+			switch {
+			case adaptiveQualityExceeded(clients, bandwidth, 1):
+				relaxed = 0
+				next = min(penalty+adaptiveQualityStep, max(worst, 0))
+
+			case penalty > 0 && !adaptiveQualityExceeded(clients, bandwidth, adaptiveQualityRecover):
+				if relaxed++; relaxed >= adaptiveQualityRecoverChecks {
+					relaxed = 0
+					next = max(penalty-adaptiveQualityStep, 0)
+				}
+
+			default:
+				relaxed = 0
+			}
+
+			if next == penalty {
+				continue
+			}
+
+			atomic.StoreInt64(&qualityPenalty, next)
+			log.WithFields(log.Fields{
+				"bandwidth": int64(bandwidth),
+				"clients":   clients,
+				"penalty":   next,
+			}).Info("Adapting encoder quality to load")
+			restartCapture()
+		}
+	}
+}
+
+// adaptiveQualityExceeded reports whether the load exceeds the given
+// fraction of any configured threshold
+func adaptiveQualityExceeded(clients int, bandwidth float64, fraction float64) bool {
+	if cfg.AdaptiveClients > 0 && float64(clients) > float64(cfg.AdaptiveClients)*fraction {
+		return true
+	}
+
+	return adaptiveBandwidth > 0 && bandwidth > float64(adaptiveBandwidth)*fraction
+}
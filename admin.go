@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+type statusResponse struct {
+	Camera         string         `json:"camera"`
+	Capturing      bool           `json:"capturing"`
+	Clients        int            `json:"clients"`
+	Exposure       *exposureStats `json:"exposure,omitempty"`
+	FFMpegRestarts int64          `json:"ffmpeg_restarts"`
+	FrameMemory    int64          `json:"frame_memory"`
+	Frames         int64          `json:"frames"`
+	LastFrame      time.Time      `json:"last_frame"`
+	Motion         bool           `json:"motion"`
+	Privacy        bool           `json:"privacy"`
+	Processors     []string       `json:"processors"`
+	Quality        int            `json:"quality"`
+	Recording      bool           `json:"recording"`
+	Version        string         `json:"version"`
+}
+
+func registerAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("GET /stats", handleStats)
+
+	if cfg.EnableMetrics {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+
+	if cfg.EnablePprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		log.Debug("pprof endpoints registered")
+	}
+}
+
+// currentStatus collects the state of the capture
+func currentStatus() statusResponse {
+	recording, _ := recordControl.Active()
+
+	cfgLock.RLock()
+	quality := effectiveQuality()
+	cfgLock.RUnlock()
+
+	return statusResponse{
+		Camera:         cfg.Device,
+		Capturing:      isCapturing(),
+		Clients:        frameBroadcaster.Clients(),
+		Exposure:       getExposure(),
+		FFMpegRestarts: atomic.LoadInt64(&captureRestarts),
+		FrameMemory:    broadcast.Memory(),
+		Frames:         atomic.LoadInt64(&capturedFrames),
+		LastFrame:      lastFrameTime(),
+		Motion:         motionDetection.Active(),
+		Privacy:        isPrivacyEnabled(),
+		Processors:     frameBroadcaster.Processors.Names(),
+		Quality:        quality,
+		Recording:      recording,
+		Version:        version,
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(currentStatus()); err != nil {
+		log.WithError(err).Error("Unable to encode status")
+	}
+}
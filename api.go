@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type recordStatusResponse struct {
+	Recording bool     `json:"recording"`
+	Sources   []string `json:"sources"`
+}
+
+func registerAPIHandlers(mux *http.ServeMux) {
+	handle := func(pattern string, h http.Handler) { mux.Handle(pattern, apiAuth(h)) }
+
+	for _, rt := range apiRoutes() {
+		handle(rt.Pattern, rt.Handler)
+	}
+
+	mux.HandleFunc("GET "+apiPrefix+"openapi.json", handleOpenAPI)
+	mux.Handle(apiPrefix, handleAPIFallback(mux))
+
+	mux.Handle("GET /admin", loginRequired(http.HandlerFunc(handleAdmin)))
+	mux.HandleFunc("POST /login", handleLogin)
+
+	if len(enabledRecordingKinds()) > 0 {
+		mux.Handle("GET /recordings", loginRequired(http.HandlerFunc(handleRecordingsPage)))
+	}
+}
+
+// apiRoutes returns the API endpoints enabled by the configuration
+func apiRoutes() []apiRoute {
+	routes := []apiRoute{
+		{Pattern: "GET /api/v1/capabilities", Handler: handleCapabilities, Summary: "Get supported formats, sizes and frame rates",
+			Response: cameraCapabilities{}},
+		{Pattern: "GET /api/v1/capture", Handler: handleCaptureGet, Summary: "Get capture settings",
+			Response: captureSettings{}},
+		{Pattern: "PATCH /api/v1/capture", Handler: handleCapturePatch, Summary: "Change capture settings",
+			Request: captureSettings{}, Response: captureSettings{}},
+		{Pattern: "POST /api/v1/capture/restart", Handler: handleCaptureRestart, Summary: "Restart the capture",
+			Status: http.StatusNoContent},
+		{Pattern: "GET /api/v1/controls", Handler: handleControlsGet, Summary: "List camera controls",
+			Response: []cameraControl{}},
+		{Pattern: "PATCH /api/v1/controls", Handler: handleControlsPatch, Summary: "Set camera controls",
+			Request: map[string]int32{}, Response: []cameraControl{}},
+		{Pattern: "GET /api/v1/events", Handler: handleEvents, Summary: "Stream events as server-sent events or WebSocket messages",
+			Query: []string{"types"}, Response: apiEvents{}},
+		{Pattern: "GET /api/v1/focus", Handler: handleFocusGet, Summary: "Get focus state",
+			Response: focusState{}},
+		{Pattern: "PUT /api/v1/focus", Handler: handleFocusSet, Summary: "Set focus position or auto focus",
+			Request: focusChange{}, Response: focusState{}},
+		{Pattern: "POST /api/v1/focus/sweep", Handler: handleFocusSweep, Summary: "Sweep focus for the sharpest position",
+			Request: focusSweepRequest{}, Response: focusSweepResult{}},
+		{Pattern: "GET /api/v1/privacy", Handler: handlePrivacyGet, Summary: "Get privacy mode",
+			Response: privacyResponse{}},
+		{Pattern: "POST /api/v1/privacy", Handler: handlePrivacySet, Summary: "Set privacy mode (on, off, toggle)",
+			Query: []string{"state"}, Request: apiText("on"), Response: privacyResponse{}},
+		{Pattern: "GET /api/v1/ptz", Handler: handlePTZGet, Summary: "Get pan, tilt and zoom axes",
+			Response: map[string]ptzAxis{}},
+		{Pattern: "POST /api/v1/ptz", Handler: handlePTZMove, Summary: "Move pan, tilt and zoom axes",
+			Request: ptzRequest{}, Response: map[string]ptzAxis{}},
+		{Pattern: "GET /api/v1/status", Handler: handleStatus, Summary: "Get stream status",
+			Response: statusResponse{}},
+		{Pattern: "POST /api/v1/whitebalance/lock", Handler: handleWhiteBalanceLock, Summary: "Lock white balance on a reference region",
+			Request: wbLockRequest{}, Response: wbLockResponse{}},
+	}
+
+	if cfg.Config != "" {
+		routes = append(routes, apiRoute{Pattern: "POST /api/v1/reload", Handler: handleReload, Summary: "Reload the config file",
+			Response: configReloadResult{}})
+	}
+
+	if cfg.PresetDir != "" {
+		routes = append(routes,
+			apiRoute{Pattern: "GET /api/v1/presets", Handler: handlePresetList, Summary: "List control presets",
+				Response: []string{}},
+			apiRoute{Pattern: "GET /api/v1/presets/{name}", Handler: handlePresetGet, Summary: "Get a control preset",
+				Response: map[string]int32{}},
+			apiRoute{Pattern: "PUT /api/v1/presets/{name}", Handler: handlePresetSave, Summary: "Save a control preset",
+				Request: presetSaveRequest{}, Response: map[string]int32{}},
+			apiRoute{Pattern: "DELETE /api/v1/presets/{name}", Handler: handlePresetDelete, Summary: "Delete a control preset",
+				Status: http.StatusNoContent},
+			apiRoute{Pattern: "POST /api/v1/presets/{name}/apply", Handler: handlePresetApply, Summary: "Apply a control preset",
+				Response: []cameraControl{}},
+		)
+	}
+
+	if cfg.Profiles != "" {
+		routes = append(routes,
+			apiRoute{Pattern: "GET /api/v1/profile", Handler: handleProfileGet, Summary: "Get control profiles",
+				Response: profileResponse{}},
+			apiRoute{Pattern: "PUT /api/v1/profile", Handler: handleProfileSet, Summary: "Apply a control profile",
+				Request: profileRequest{}, Response: profileResponse{}},
+		)
+	}
+
+	if cfg.RecordDir != "" {
+		routes = append(routes,
+			apiRoute{Pattern: "GET /api/v1/record", Handler: handleRecordStatus, Summary: "Get recording state",
+				Response: recordStatusResponse{}},
+			apiRoute{Pattern: "POST /api/v1/record/start", Handler: handleRecordStart, Summary: "Start recording",
+				Query: []string{"duration"}, Response: recordStatusResponse{}},
+			apiRoute{Pattern: "POST /api/v1/record/stop", Handler: handleRecordStop, Summary: "Stop recording",
+				Response: recordStatusResponse{}},
+			apiRoute{Pattern: "GET /api/v1/recordings/timeline", Handler: handleRecordingsTimeline, Summary: "Get recording and motion timeline",
+				Query: []string{"from", "to"}, Response: timelineResponse{}},
+		)
+	}
+
+	if cfg.Motion {
+		routes = append(routes,
+			apiRoute{Pattern: "GET /api/v1/motion/zones", Handler: handleMotionZonesGet, Summary: "Get motion zones",
+				Response: motionZoneConfig{}},
+			apiRoute{Pattern: "PUT /api/v1/motion/zones", Handler: handleMotionZonesPut, Summary: "Replace motion zones",
+				Request: motionZoneConfig{}, Response: motionZoneConfig{}},
+		)
+	}
+
+	if len(enabledRecordingKinds()) > 0 {
+		routes = append(routes,
+			apiRoute{Pattern: "GET /api/v1/recordings", Handler: handleRecordingsList, Summary: "List recordings",
+				Query: []string{"kind", "limit"}, Response: recordingsResponse{}},
+			apiRoute{Pattern: "GET /api/v1/recordings/{kind}/{name...}", Handler: handleRecordingFile, Summary: "Download a recording",
+				Response: apiFile{}},
+		)
+	}
+
+	if cfg.TelegramToken != "" {
+		routes = append(routes, apiRoute{Pattern: "POST /api/v1/notify/telegram", Handler: handleTelegramNotify, Summary: "Send a snapshot to Telegram",
+			Request: telegramNotifyRequest{}, Status: http.StatusNoContent})
+	}
+
+	if cfg.SnapshotDir != "" {
+		routes = append(routes, apiRoute{Pattern: "POST /api/v1/snapshot", Handler: handleSnapshotSave, Summary: "Save a snapshot",
+			Query: []string{"label"}, Response: snapshotResponse{}, Status: http.StatusCreated})
+	}
+
+	return routes
+}
+
+// apiAuth requires the configured API token as bearer token, login
+// cookie or token parameter if one is configured, without token changes are only
+// accepted on a separate admin listener
+func apiAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiToken := cfgValue(&cfg.APIToken)
+		if apiToken == "" {
+			if cfg.AdminListen == "" && !isReadOnlyMethod(r.Method) {
+				writeAPIError(w, http.StatusForbidden, "Changes require --api-token or --admin-listen")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !validAPIToken(requestAPIToken(r), apiToken) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cam2mjpeg"`)
+			writeAPIError(w, http.StatusUnauthorized, "Invalid or missing API token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestAPIToken returns the API token passed with the request
+func requestAPIToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	if c, err := r.Cookie(loginCookie); err == nil {
+		return c.Value
+	}
+	return r.URL.Query().Get("token")
+}
+
+func validAPIToken(token, apiToken string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1
+}
+
+// isReadOnlyMethod tells whether requests using the method do not
+// change any state
+func isReadOnlyMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// handleRecordStart starts recording, optionally limited by the
+// duration parameter (e.g. ?duration=10m)
+func handleRecordStart(w http.ResponseWriter, r *http.Request) {
+	var until time.Time
+
+	if v := r.FormValue("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid duration")
+			return
+		}
+		until = time.Now().Add(d)
+	}
+
+	recordControl.Request(recordSourceAPI, until)
+	log.WithField("until", until).Info("Recording requested through API")
+
+	handleRecordStatus(w, r)
+}
+
+func handleRecordStatus(w http.ResponseWriter, r *http.Request) {
+	active, sources := recordControl.Active()
+	writeAPIResponse(w, http.StatusOK, recordStatusResponse{
+		Recording: active,
+		Sources:   sources,
+	})
+}
+
+func handleRecordStop(w http.ResponseWriter, r *http.Request) {
+	recordControl.Release(recordSourceAPI)
+	log.Info("Recording stop requested through API")
+
+	handleRecordStatus(w, r)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIResponse(w, status, apiError{Error: msg})
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.WithError(err).Error("Unable to encode API response")
+	}
+}
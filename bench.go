@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/Luzifer/cam2mjpeg/pkg/client"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const benchSampleInterval = 500 * time.Millisecond
+
+// captureTestPattern replaces the capture device by the ffmpeg test
+// pattern source
+var captureTestPattern bool
+
+type benchClient struct {
+	Bytes  int64
+	Drops  int64
+	Err    error
+	Frames int64
+}
+
+// runBenchCommand connects the configured number of MJPEG clients to
+// the stream and reports the achieved frame rate, drops and memory
+func runBenchCommand() error {
+	if cfg.BenchClients < 1 || cfg.BenchDuration <= 0 {
+		return errors.New("Bench needs at least one client and a positive duration")
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), cfg.BenchDuration)
+	defer stop()
+
+	target := cfg.BenchURL
+	if target == "" {
+		var err error
+		if target, err = startBenchSource(ctx); err != nil {
+			return err
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"clients":  cfg.BenchClients,
+		"duration": cfg.BenchDuration,
+		"url":      target,
+	}).Info("Starting benchmark")
+
+	var (
+		clients  = make([]benchClient, cfg.BenchClients)
+		heapPeak uint64
+		wg       sync.WaitGroup
+	)
+
+	for i := range clients {
+		wg.Add(1)
+		go func(c *benchClient) {
+			defer wg.Done()
+			c.Err = runBenchClient(ctx, target, c)
+		}(&clients[i])
+	}
+
+	go func() {
+		t := time.NewTicker(benchSampleInterval)
+		defer t.Stop()
+
+		var ms runtime.MemStats
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				runtime.ReadMemStats(&ms)
+				atomic.StoreUint64(&heapPeak, max(atomic.LoadUint64(&heapPeak), ms.HeapInuse))
+			}
+		}
+	}()
+
+	start := time.Now()
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	var (
+		bytes, drops, frames, failed int64
+		minFPS, maxFPS               float64
+	)
+	for i, c := range clients {
+		if c.Err != nil {
+			failed++
+			log.WithError(c.Err).WithField("client", i).Debug("Bench client failed")
+		}
+
+		fps := float64(c.Frames) / elapsed
+		if i == 0 || fps < minFPS {
+			minFPS = fps
+		}
+		maxFPS = max(maxFPS, fps)
+
+		bytes += c.Bytes
+		drops += c.Drops
+		frames += c.Frames
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Clients:\t%d (%d failed)\n", len(clients), failed)
+	fmt.Fprintf(tw, "Duration:\t%.1fs\n", elapsed)
+	fmt.Fprintf(tw, "Frames:\t%d\n", frames)
+	fmt.Fprintf(tw, "FPS per client:\tmin %.1f / avg %.1f / max %.1f\n", minFPS, float64(frames)/elapsed/float64(len(clients)), maxFPS)
+	if cfg.BenchURL == "" {
+		fmt.Fprintf(tw, "Drops:\t%d\n", drops)
+	} else {
+		// Sequence headers are only sent with --latency-headers
+		fmt.Fprintf(tw, "Drops:\t%d (requires --latency-headers on the instance)\n", drops)
+	}
+	fmt.Fprintf(tw, "Throughput:\t%s/s\n", formatByteSize(int64(float64(bytes)/elapsed)))
+	fmt.Fprintf(tw, "Heap in use (peak):\t%s\n", formatByteSize(int64(atomic.LoadUint64(&heapPeak))))
+	if cfg.BenchURL == "" {
+		fmt.Fprintf(tw, "Frame memory (peak):\t%s\n", formatByteSize(broadcast.PeakMemory()))
+	}
+
+	return tw.Flush()
+}
+
+// startBenchSource serves the test pattern capture on a local port
+// until the context is cancelled and returns the stream URL
+func startBenchSource(ctx context.Context) (string, error) {
+	// There is no device to check, sequence headers allow to count drops
+	captureTestPattern = true
+	cfg.LatencyHeaders = true
+	cfg.SkipPreflight = true
+	appContext = ctx
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to listen")
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mjpeg", newMJPEGHandler(frameBroadcaster.Hub, "/mjpeg", mjpegHooks))
+	srv := &http.Server{Handler: mux}
+
+	go srv.Serve(l)
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	go frameBroadcaster.Run(ctx)
+	go runCaptureLoop(ctx)
+
+	return "http://" + l.Addr().String() + "/mjpeg", nil
+}
+
+// runBenchClient reads the MJPEG stream until the context is cancelled
+// counting the frames and the ones skipped according to their sequence
+func runBenchClient(ctx context.Context, target string, c *benchClient) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return errors.Wrap(err, "Invalid bench URL")
+	}
+
+	var (
+		cl      = &client.Client{BaseURL: u.Scheme + "://" + u.Host, StreamPath: u.RequestURI()}
+		lastSeq uint64
+	)
+
+	err = cl.Stream(ctx, func(f client.Frame) error {
+		c.Bytes += int64(len(f.Data))
+		c.Frames++
+
+		if f.Seq > 0 {
+			if lastSeq > 0 && f.Seq > lastSeq+1 {
+				c.Drops += int64(f.Seq - lastSeq - 1)
+			}
+			lastSeq = f.Seq
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
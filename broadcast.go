@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	log "github.com/sirupsen/logrus"
+)
+
+// broadcastQueueSize is the number of frames the capture loop may get
+// ahead of the broadcaster
+const broadcastQueueSize = 2
+
+type (
+	frame      = broadcast.Frame
+	subscriber = broadcast.Subscriber
+
+	broadcastFrame struct {
+		ctx  context.Context
+		data *frame
+	}
+
+	// broadcaster distributes the captured frames through its hub
+	// applying the frame processors, the replay buffer and the idle
+	// frame rate on the way
+	broadcaster struct {
+		*broadcast.Hub
+
+		// Processors are run on every frame before it is distributed
+		Processors broadcast.Chain
+
+		// grabs counts the callers of NextFrame waiting for a frame
+		grabs atomic.Int32
+		queue chan broadcastFrame
+		ring  *frameRing
+
+		// lastIdleFrame is the last frame sent to throttled subscribers
+		lastIdleFrame time.Time
+	}
+)
+
+var (
+	frameBroadcaster = newBroadcaster()
+
+	// maxFrameMemory is the parsed --max-frame-memory
+	maxFrameMemory int64
+)
+
+func newBroadcaster() *broadcaster {
+	hub := broadcast.NewHub()
+	hub.OnChange = trackSubscriber
+
+	b := &broadcaster{
+		Hub:   hub,
+		queue: make(chan broadcastFrame, broadcastQueueSize),
+	}
+	b.Processors.Register("privacy", broadcast.FrameProcessorFunc(processPrivacy))
+
+	return b
+}
+
+// trackSubscriber counts the clients and wakes up the capture loop
+// waiting for demand
+func trackSubscriber(s *subscriber, added bool) {
+	if !s.Internal {
+		delta := int64(-1)
+		if added {
+			delta = 1
+		}
+		telemetry.Clients.Add(context.Background(), delta)
+	}
+	signalDemand()
+
+	if added {
+		log.WithField("id", s.ID).Debug("registered new requester")
+	} else {
+		log.WithField("id", s.ID).Debug("removed requester")
+	}
+}
+
+// Broadcast hands the frame to the broadcaster, blocking until it was
+// accepted or the context is cancelled. The reference to the frame is
+// passed to the broadcaster.
+func (b *broadcaster) Broadcast(ctx context.Context, jpg *frame) {
+	select {
+	case b.queue <- broadcastFrame{ctx: ctx, data: jpg}:
+	case <-ctx.Done():
+	}
+}
+
+// Run distributes the captured frames to all subscribers in the order
+// they were captured until the context is cancelled
+func (b *broadcaster) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f := <-b.queue:
+			b.send(f.ctx, f.data)
+			f.data.Release()
+		}
+	}
+}
+
+// Clients returns the number of clients including the ones of the
+// paced streams
+func (b *broadcaster) Clients() int { return b.ClientCount() + pacedClientCount() }
+
+// Demand reports whether clients are connected or frames are waiting
+// to be grabbed, internal subscribers do not keep an on-demand capture
+// running
+func (b *broadcaster) Demand() bool { return b.Clients() > 0 || b.grabs.Load() > 0 }
+
+// NextFrame waits for the next frame, it is counted as demand until the
+// frame arrived
+func (b *broadcaster) NextFrame(ctx context.Context) (*frame, error) {
+	b.grabs.Add(1)
+	defer b.grabs.Add(-1)
+
+	return b.Hub.NextFrame(ctx)
+}
+
+// Subscribe registers a new client subscriber which must be passed to
+// Unsubscribe when no longer interested in frames
+func (b *broadcaster) Subscribe(id string) *subscriber { return b.Hub.Subscribe(id, false, false) }
+
+// SubscribeInternal registers a subscriber not being counted as client
+func (b *broadcaster) SubscribeInternal(id string) *subscriber {
+	return b.Hub.Subscribe(id, true, false)
+}
+
+// SubscribeUnthrottled registers an internal subscriber receiving all
+// frames regardless of the idle frame rate
+func (b *broadcaster) SubscribeUnthrottled(id string) *subscriber {
+	return b.Hub.Subscribe(id, true, true)
+}
+
+// send passes the frame through the processors and pushes the result
+// to all subscribers, each of them receiving its own reference
+func (b *broadcaster) send(ctx context.Context, jpg *frame) {
+	ctx, span := tracer.Start(ctx, "broadcast")
+	defer span.End()
+	defer observeDuration(ctx, telemetry.BroadcastDuration, time.Now())
+
+	jpg, err := b.Processors.Process(ctx, jpg)
+	if err != nil {
+		log.WithError(err).Error("Unable to process frame, dropping frame")
+		return
+	}
+	if jpg == nil {
+		// Dropped by a processor
+		return
+	}
+	defer jpg.Release()
+
+	jpg.BroadcastAt = time.Now()
+	observeFrameLatency(ctx, "broadcast", jpg.Time, jpg.BroadcastAt)
+
+	if b.ring != nil && !isPrivacyEnabled() {
+		b.ring.Add(jpg)
+	}
+	defer b.enforceFrameMemory()
+
+	throttled := b.idleThrottled(time.Now())
+
+	n := b.Send(jpg, func(s *subscriber) bool { return throttled && !s.Unthrottled })
+	if n == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"camera":     cfg.Device,
+		"requesters": n,
+		"size":       len(jpg.Data),
+	}).Debug("sent frame")
+}
+
+// enforceFrameMemory drops the oldest queued and buffered frames until
+// the frame memory fits into the budget. The latest frame of every
+// queue and the replay buffer is kept to not stall the clients.
+func (b *broadcaster) enforceFrameMemory() {
+	if maxFrameMemory <= 0 {
+		return
+	}
+
+	var drops int
+	for broadcast.Memory() > maxFrameMemory {
+		if n := b.DropOldest(); n > 0 {
+			drops += n
+			continue
+		}
+
+		if b.ring == nil || !b.ring.DropOldest() {
+			// Remaining frames are being written to clients
+			break
+		}
+		drops++
+	}
+
+	if drops > 0 {
+		log.WithFields(log.Fields{
+			"dropped":      drops,
+			"frame_memory": broadcast.Memory(),
+		}).Debug("Frame memory budget exceeded, dropped oldest frames")
+	}
+}
+
+// idleThrottled reports whether the frame must be withheld from
+// throttled subscribers as no motion is active and the last frame sent
+// to them is more recent than the idle frame interval
+func (b *broadcaster) idleThrottled(now time.Time) bool {
+	if cfg.IdleFPS <= 0 || !cfg.Motion || motionDetection.Active() {
+		return false
+	}
+
+	if now.Sub(b.lastIdleFrame) < time.Duration(float64(time.Second)/cfg.IdleFPS) {
+		return true
+	}
+
+	b.lastIdleFrame = now
+	return false
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// cameraProcessEnv carries the camera name to the camera process
+	cameraProcessEnv   = "CAM2MJPEG_CAMERA_PROCESS"
+	cameraRestartDelay = 5 * time.Second
+	// cameraStopTimeout covers the graceful shutdown of the process
+	cameraStopTimeout = shutdownTimeout + ffmpegStopTimeout
+)
+
+var (
+	cameraName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	cameraProcesses     = map[string]*cameraProcess{}
+	cameraProcessesLock sync.Mutex
+	// cameraWorkers tracks the camera processes to wait for on shutdown
+	cameraWorkers sync.WaitGroup
+)
+
+// cameraConfig is a camera of the config file: either a local device
+// served by a child process or a remote stream shown on the dashboard
+type cameraConfig struct {
+	Device string `toml:"device" yaml:"device"`
+	Listen string `toml:"listen" yaml:"listen"`
+	Name   string `toml:"name" yaml:"name"`
+	// Options are passed to the child process as flags, none of the
+	// options of this process are inherited
+	Options map[string]interface{} `toml:"options" yaml:"options"`
+	Stream  string                 `toml:"stream" yaml:"stream"`
+	Title   string                 `toml:"title" yaml:"title"`
+}
+
+type cameraProcess struct {
+	cancel context.CancelFunc
+	conf   cameraConfig
+	done   chan struct{}
+	proxy  *httputil.ReverseProxy
+}
+
+func (c cameraConfig) validate() error {
+	switch {
+	case !cameraName.MatchString(c.Name):
+		return errors.New("Name must consist of letters, digits, '-' and '_'")
+
+	case (c.Device == "") == (c.Stream == ""):
+		return errors.New("Either device or stream is required")
+
+	case c.Stream != "":
+		if c.Listen != "" || len(c.Options) > 0 {
+			return errors.New("Listen and options require a device")
+		}
+		if u, err := url.Parse(c.Stream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("Stream needs a HTTP(S) URL")
+		}
+		return nil
+
+	case c.Listen == "":
+		return errors.New("Device requires a listen address")
+	}
+
+	if err := validateListenAddress(c.Listen); err != nil {
+		return err
+	}
+
+	known := configFileKeys()
+	for key, value := range c.Options {
+		switch {
+		case key == "input", key == "listen":
+			return errors.Errorf("Option %q is set by the camera", key)
+		case !known[key]:
+			return errors.Errorf("Unknown option %q", key)
+		}
+
+		if _, err := configFileValue(value); err != nil {
+			return errors.Wrapf(err, "Invalid value for %q", key)
+		}
+	}
+
+	return nil
+}
+
+// args returns the commandline options of the child process
+func (c cameraConfig) args() []string {
+	args := []string{"--input=" + c.Device, "--listen=" + c.Listen}
+
+	keys := make([]string, 0, len(c.Options))
+	for key := range c.Options {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		// Validated when loading the config
+		v, _ := configFileValue(c.Options[key])
+		args = append(args, "--"+key+"="+v)
+	}
+
+	return args
+}
+
+// dashboardTile returns the tile showing the camera, local cameras are
+// shown through the proxy to their process
+func (c cameraConfig) dashboardTile() dashboardTile {
+	tile := dashboardTile{Stream: c.Stream, Title: c.Title}
+	if c.Device != "" {
+		tile.Stream = "cameras/" + c.Name + "/mjpeg"
+	}
+	if tile.Title == "" {
+		tile.Title = c.Name
+	}
+	return tile
+}
+
+// applyCameras starts a process for every new or changed local camera
+// and stops the processes of cameras no longer configured
+func applyCameras(cameras []cameraConfig) {
+	cameraProcessesLock.Lock()
+	defer cameraProcessesLock.Unlock()
+
+	wanted := map[string]cameraConfig{}
+	for _, c := range cameras {
+		if c.Device != "" {
+			wanted[c.Name] = c
+		}
+	}
+
+	stopped := map[string]*cameraProcess{}
+	for name, p := range cameraProcesses {
+		if c, ok := wanted[name]; !ok || !reflect.DeepEqual(c, p.conf) {
+			p.cancel()
+			stopped[name] = p
+			delete(cameraProcesses, name)
+		}
+	}
+
+	for name, c := range wanted {
+		if _, ok := cameraProcesses[name]; ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(appContext)
+		p := &cameraProcess{cancel: cancel, conf: c, done: make(chan struct{}), proxy: cameraProxy(c)}
+		cameraProcesses[name] = p
+
+		cameraWorkers.Add(1)
+		go func(prev *cameraProcess) {
+			defer cameraWorkers.Done()
+			defer close(p.done)
+
+			if prev != nil {
+				// The changed camera might use the same listener
+				<-prev.done
+			}
+			runCamera(ctx, c)
+		}(stopped[name])
+	}
+}
+
+// runCamera keeps the camera process running until the context is
+// cancelled
+func runCamera(ctx context.Context, c cameraConfig) {
+	logger := log.WithFields(log.Fields{"camera": c.Name, "device": c.Device})
+
+	for {
+		logger.Info("Starting camera process")
+		err := execCamera(ctx, c)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.WithError(err).WithField("wait", cameraRestartDelay).Error("Camera process exited, restarting")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cameraRestartDelay):
+		}
+	}
+}
+
+func execCamera(ctx context.Context, c cameraConfig) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "Unable to find executable")
+	}
+
+	cmd := exec.CommandContext(ctx, exe, c.args()...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = cameraStopTimeout
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+
+	// Options given through the environment (including the config
+	// file) are not inherited
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "CAM2MJPEG_") {
+			cmd.Env = append(cmd.Env, e)
+		}
+	}
+	cmd.Env = append(cmd.Env, cameraProcessEnv+"="+c.Name)
+
+	return errors.Wrap(cmd.Run(), "Camera process failed")
+}
+
+// isCameraProcess reports whether this process serves a camera of the
+// config file of its parent
+func isCameraProcess() bool { return os.Getenv(cameraProcessEnv) != "" }
+
+// cameraProxy forwards the requests below /cameras/<name>/ to the
+// listener of the camera process
+func cameraProxy(c cameraConfig) *httputil.ReverseProxy {
+	network, addr := "tcp", c.Listen
+	if path, ok := strings.CutPrefix(c.Listen, unixSocketPrefix); ok {
+		network, addr = "unix", path
+	}
+	prefix := "/cameras/" + c.Name
+
+	return &httputil.ReverseProxy{
+		// Pass on the frames as they arrive
+		FlushInterval: -1,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = c.Name
+			r.Out.URL.Path = strings.TrimPrefix(r.In.URL.Path, prefix)
+			r.Out.URL.RawPath = ""
+			r.SetXForwarded()
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func handleCameraProxy(w http.ResponseWriter, r *http.Request) {
+	cameraProcessesLock.Lock()
+	p := cameraProcesses[r.PathValue("name")]
+	cameraProcessesLock.Unlock()
+
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
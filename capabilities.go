@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type (
+	cameraCapabilities struct {
+		BusInfo string         `json:"bus_info"`
+		Card    string         `json:"card"`
+		Driver  string         `json:"driver"`
+		Formats []cameraFormat `json:"formats"`
+	}
+
+	// cameraFormat is a pixel format supported by the camera, devices
+	// not having discrete frame sizes report a stepwise range instead
+	cameraFormat struct {
+		Compressed  bool                `json:"compressed"`
+		Description string              `json:"description"`
+		FourCC      string              `json:"fourcc"`
+		Sizes       []cameraFrameSize   `json:"sizes,omitempty"`
+		Stepwise    *cameraStepwiseSize `json:"stepwise,omitempty"`
+	}
+
+	cameraFrameSize struct {
+		Height    uint32                `json:"height"`
+		Intervals []cameraFrameInterval `json:"intervals,omitempty"`
+		Width     uint32                `json:"width"`
+	}
+
+	cameraFrameInterval struct {
+		Denominator uint32  `json:"denominator"`
+		FPS         float64 `json:"fps"`
+		Numerator   uint32  `json:"numerator"`
+	}
+
+	cameraStepwiseSize struct {
+		MaxHeight  uint32 `json:"max_height"`
+		MaxWidth   uint32 `json:"max_width"`
+		MinHeight  uint32 `json:"min_height"`
+		MinWidth   uint32 `json:"min_width"`
+		StepHeight uint32 `json:"step_height"`
+		StepWidth  uint32 `json:"step_width"`
+	}
+)
+
+func newCameraFrameInterval(num, denom uint32) cameraFrameInterval {
+	i := cameraFrameInterval{Denominator: denom, Numerator: num}
+	if num > 0 {
+		i.FPS = float64(denom) / float64(num)
+	}
+	return i
+}
+
+// getCapabilities opens the capture device and queries its formats
+func getCapabilities() (cameraCapabilities, error) {
+	dev, err := openV4L2Device(cfg.Device)
+	if err != nil {
+		return cameraCapabilities{}, err
+	}
+	defer dev.Close()
+
+	info, err := dev.Info()
+	if err != nil {
+		return cameraCapabilities{}, err
+	}
+
+	formats, err := dev.Formats()
+	if err != nil {
+		return cameraCapabilities{}, err
+	}
+
+	return cameraCapabilities{
+		BusInfo: info.BusInfo,
+		Card:    info.Card,
+		Driver:  info.Driver,
+		Formats: formats,
+	}, nil
+}
+
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	caps, err := getCapabilities()
+	if err != nil {
+		log.WithError(err).Error("Unable to query camera capabilities")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to query camera capabilities")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, caps)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/capture"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const ffmpegStopTimeout = 5 * time.Second
+
+var (
+	captureCancel     context.CancelCauseFunc
+	captureCancelLock = new(sync.Mutex)
+
+	// maxFrameSize is the hard cap for the frame buffer, parsed from
+	// the max-frame-size option on startup
+	maxFrameSize int
+
+	captureRestarts  int64
+	captureRunning   int32
+	captureStartedAt = time.Now().UnixNano()
+	capturedFrames   int64
+	lastFrameAt      int64
+
+	demandChanged = make(chan struct{}, 1)
+
+	errCaptureIdle    = errors.New("no viewers connected")
+	errCaptureRestart = errors.New("restart requested")
+)
+
+// isCapturing tells whether ffmpeg is currently supposed to run
+func isCapturing() bool { return atomic.LoadInt32(&captureRunning) == 1 }
+
+// captureStartTime returns the time ffmpeg was (re-)started last
+func captureStartTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&captureStartedAt))
+}
+
+// lastFrameTime returns the time the last valid frame was extracted
+// or the zero time if no frame was extracted yet
+func lastFrameTime() time.Time {
+	if t := atomic.LoadInt64(&lastFrameAt); t > 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// markFrame records a valid frame being extracted and stamps it with
+// its capture time and sequence number
+func markFrame(f *frame) {
+	f.Time = time.Now()
+	f.Seq = uint64(atomic.AddInt64(&capturedFrames, 1))
+	atomic.StoreInt64(&lastFrameAt, f.Time.UnixNano())
+}
+
+// restartCapture stops the currently running ffmpeg process which
+// causes the capture loop to spawn a new one
+func restartCapture() {
+	captureCancelLock.Lock()
+	defer captureCancelLock.Unlock()
+
+	if captureCancel != nil {
+		captureCancel(errCaptureRestart)
+	}
+}
+
+// signalDemand wakes up the capture loop waiting for viewers
+func signalDemand() {
+	select {
+	case demandChanged <- struct{}{}:
+	default:
+	}
+}
+
+func hasDemand() bool { return frameBroadcaster.Demand() }
+
+// waitForDemand blocks until at least one viewer is connected and
+// returns false if the context was cancelled before
+func waitForDemand(ctx context.Context) bool {
+	for !hasDemand() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-demandChanged:
+		}
+	}
+	return true
+}
+
+// stopWhenIdle cancels the capture as soon as no viewers were connected
+// for the configured idle timeout
+func stopWhenIdle(ctx context.Context, cancel context.CancelCauseFunc) {
+	var (
+		idleSince time.Time
+		t         = time.NewTicker(time.Second)
+	)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if hasDemand() {
+			idleSince = time.Time{}
+			continue
+		}
+
+		if idleSince.IsZero() {
+			idleSince = time.Now()
+		}
+
+		if time.Since(idleSince) >= cfg.IdleTimeout {
+			cancel(errCaptureIdle)
+			return
+		}
+	}
+}
+
+// runCaptureLoop keeps ffmpeg running, restarting it with an
+// exponential backoff whenever it exits or fails until the parent
+// context is cancelled
+func runCaptureLoop(parent context.Context) {
+	var (
+		backoff = cfg.RestartBackoffMin
+		// failures counts the failed captures since frames were
+		// produced the last time
+		failures int
+	)
+
+	for parent.Err() == nil {
+		if cfg.OnDemand && !waitForDemand(parent) {
+			return
+		}
+
+		ctx, cancel := context.WithCancelCause(parent)
+
+		captureCancelLock.Lock()
+		captureCancel = cancel
+		captureCancelLock.Unlock()
+
+		if cfg.OnDemand {
+			go stopWhenIdle(ctx, cancel)
+		}
+
+		start := time.Now()
+		if failures > 0 {
+			go awaitCaptureRecovery(ctx, start, failures)
+		}
+
+		atomic.StoreInt64(&captureStartedAt, start.UnixNano())
+		atomic.StoreInt32(&captureRunning, 1)
+		err := runCapture(ctx)
+		atomic.StoreInt32(&captureRunning, 0)
+		cause := context.Cause(ctx)
+		cancel(nil)
+
+		if lastFrameTime().After(start) {
+			failures = 0
+		}
+
+		if parent.Err() != nil {
+			log.WithField("camera", cfg.Device).Debug("Capture stopped")
+			return
+		}
+
+		if cause == errCaptureIdle {
+			log.WithField("camera", cfg.Device).Info("No viewers connected, ffmpeg stopped")
+			backoff = cfg.RestartBackoffMin
+			continue
+		}
+
+		atomic.AddInt64(&captureRestarts, 1)
+		telemetry.Restarts.Add(context.Background(), 1)
+
+		logger := log.WithFields(log.Fields{
+			"camera":   cfg.Device,
+			"restarts": atomic.LoadInt64(&captureRestarts),
+		})
+
+		if cause == errCaptureRestart {
+			// Restart was requested, no need to wait
+			logger.Warn("Restarting ffmpeg")
+			backoff = cfg.RestartBackoffMin
+			go notifyLifecycle(lifecycleWebhookPayload{Event: lifecycleCaptureRestart})
+			continue
+		}
+
+		if time.Since(start) > cfg.RestartBackoffMax {
+			// Capture ran long enough to consider it healthy
+			backoff = cfg.RestartBackoffMin
+		}
+
+		wait := jitter(backoff)
+		withPreflightHint(logger, err).WithError(err).WithField("wait", wait).Error("Capture failed, restarting ffmpeg")
+
+		failures++
+		payload := lifecycleWebhookPayload{
+			Event:    lifecycleCaptureFailed,
+			Failures: failures,
+			RetryIn:  wait.String(),
+		}
+		if err != nil {
+			payload.Error = err.Error()
+		}
+		go notifyLifecycle(payload)
+
+		select {
+		case <-parent.Done():
+		case <-time.After(wait):
+		}
+
+		if backoff *= 2; backoff > cfg.RestartBackoffMax {
+			backoff = cfg.RestartBackoffMax
+		}
+	}
+}
+
+// jitter randomizes the given duration by +/- 20%
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d - d/5 + time.Duration(rand.Int63n(int64(d)*2/5+1))
+}
+
+// captureArgs builds the ffmpeg arguments to capture the device and
+// write the MJPEG frames to stdout
+func captureArgs(extra ...string) []string {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	o := capture.Options{
+		Device:    cfg.Device,
+		FrameRate: cfg.FrameRate,
+		Height:    cfg.Height,
+		Quality:   effectiveQuality(),
+		Width:     cfg.Width,
+	}
+	if captureTestPattern {
+		o.Input = capture.TestPatternInput(cfg.Width, cfg.Height, cfg.FrameRate)
+	}
+
+	var out []string
+	if cfg.FFMpegThreads > 0 {
+		out = append(out, "-threads", strconv.Itoa(cfg.FFMpegThreads))
+	}
+	if cfg.Frigate {
+		// Duplicate or drop frames to keep the rate constant
+		out = append(out, "-fps_mode", "cfr")
+	}
+	if f := captureFilter(fileSections.Masks, fileSections.Overlays); f != "" {
+		out = append(out, "-vf", f)
+	}
+
+	return capture.Args(o, append(out, extra...)...)
+}
+
+func runCapture(ctx context.Context) error {
+	if !cfg.SkipPreflight {
+		if err := preflightCheck(cfg.Device); err != nil {
+			return err
+		}
+	}
+
+	if cfg.ControlState != "" {
+		restoreControlStateIfReplaced()
+	}
+
+	cmd := ffmpegCommand(ctx, captureArgs()...)
+
+	// Give ffmpeg the chance to exit cleanly before killing it
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = ffmpegStopTimeout
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdout pipe")
+	}
+
+	stderr := ffmpegLogWriter("capture")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+	defer func() {
+		if ctx.Err() == nil {
+			// ffmpeg failed on its own, make sure it is gone
+			cmd.Process.Kill()
+		}
+		cmd.Wait()
+	}()
+
+	log.WithField("camera", cfg.Device).Debug("ffmpeg spawned")
+
+	var dupes duplicateFilter
+
+	return capture.Splitter{
+		MaxFrameSize: maxFrameSize,
+		OnFrame: func(img *frame) {
+			fctx, span := tracer.Start(ctx, "capture.frame")
+			span.SetAttributes(attribute.Int("frame.size", len(img.Data)), attribute.Bool("frame.valid", true))
+			telemetry.Frames.Add(fctx, 1, metric.WithAttributes(attribute.Bool("valid", true)))
+			span.End()
+
+			telemetry.FrameSize.Record(fctx, int64(len(img.Data)))
+
+			markFrame(img)
+			if cfg.SkipDuplicateFrames && dupes.Duplicate(img) {
+				img.Release()
+				return
+			}
+			frameBroadcaster.Broadcast(fctx, img)
+		},
+		OnSkip: func(n int, reason error) {
+			switch reason {
+			case capture.ErrOutsideFrame:
+				log.WithFields(log.Fields{
+					"camera":  cfg.Device,
+					"dropped": n,
+				}).Warn("Found data outside JPEG frame, skipping")
+
+			case capture.ErrFrameTooLarge:
+				log.WithFields(log.Fields{
+					"camera":  cfg.Device,
+					"dropped": n,
+					"max":     maxFrameSize,
+				}).Warn("Frame exceeds maximum frame size, skipping")
+
+			default:
+				_, span := tracer.Start(ctx, "capture.frame")
+				span.SetAttributes(attribute.Int("frame.size", 0), attribute.Bool("frame.valid", false))
+				telemetry.Frames.Add(ctx, 1, metric.WithAttributes(attribute.Bool("valid", false)))
+				span.End()
+
+				log.WithError(reason).WithField("camera", cfg.Device).Warn("Found invalid JPEG, skipping")
+			}
+		},
+	}.Run(out)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// captureSettings are the capture options changeable at runtime, nil
+// fields in a change request keep their current value
+type captureSettings struct {
+	FrameRate *int `json:"fps,omitempty"`
+	Height    *int `json:"height,omitempty"`
+	Quality   *int `json:"quality,omitempty"`
+	Width     *int `json:"width,omitempty"`
+}
+
+func currentCaptureSettings() captureSettings {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	fps, height, quality, width := cfg.FrameRate, cfg.Height, cfg.Quality, cfg.Width
+	return captureSettings{FrameRate: &fps, Height: &height, Quality: &quality, Width: &width}
+}
+
+// validate checks the settings against general bounds and (if the
+// device reports discrete sizes for the capture format) against the
+// supported frame sizes
+func (c captureSettings) validate() error {
+	if err := validateCaptureOptions(*c.FrameRate, *c.Width, *c.Height, *c.Quality); err != nil {
+		return err
+	}
+
+	caps, err := getCapabilities()
+	if err != nil {
+		// Device not inspectable (not on Linux, ...), let ffmpeg decide
+		log.WithError(err).Debug("Unable to validate capture settings against capabilities")
+		return nil
+	}
+
+	for _, f := range caps.Formats {
+		if f.FourCC != "YUYV" || len(f.Sizes) == 0 {
+			continue
+		}
+
+		for _, s := range f.Sizes {
+			if int(s.Width) == *c.Width && int(s.Height) == *c.Height {
+				return nil
+			}
+		}
+		return errors.Errorf("Camera does not support %dx%d", *c.Width, *c.Height)
+	}
+
+	return nil
+}
+
+func handleCaptureGet(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, http.StatusOK, currentCaptureSettings())
+}
+
+// handleCapturePatch applies the given capture settings and restarts
+// ffmpeg while keeping clients connected
+func handleCapturePatch(w http.ResponseWriter, r *http.Request) {
+	var change captureSettings
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Unable to parse capture settings")
+		return
+	}
+
+	next := currentCaptureSettings()
+	for _, f := range []struct{ from, to **int }{
+		{&change.FrameRate, &next.FrameRate},
+		{&change.Height, &next.Height},
+		{&change.Quality, &next.Quality},
+		{&change.Width, &next.Width},
+	} {
+		if *f.from != nil {
+			*f.to = *f.from
+		}
+	}
+
+	if err := next.validate(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	cfgLock.Lock()
+	cfg.FrameRate, cfg.Height, cfg.Quality, cfg.Width = *next.FrameRate, *next.Height, *next.Quality, *next.Width
+	cfgLock.Unlock()
+
+	log.WithFields(log.Fields{
+		"fps":     *next.FrameRate,
+		"height":  *next.Height,
+		"quality": *next.Quality,
+		"width":   *next.Width,
+	}).Info("Capture settings changed through API")
+	restartCapture()
+
+	writeAPIResponse(w, http.StatusOK, next)
+}
+
+func handleCaptureRestart(w http.ResponseWriter, r *http.Request) {
+	log.Info("Capture restart requested through API")
+	restartCapture()
+
+	w.WriteHeader(http.StatusNoContent)
+}
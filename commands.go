@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/capture"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCommand         = "serve"
+	snapshotCommandTimeout = 30 * time.Second
+)
+
+type cliCommand struct {
+	Description string
+	Run         func() error
+}
+
+var (
+	// command is the subcommand given as first argument
+	command = defaultCommand
+
+	commands = map[string]cliCommand{
+		"bench":    {"Connect --bench-clients MJPEG clients to --bench-url (or a test pattern) and report fps, drops and memory", runBenchCommand},
+		"devices":  {"List video devices with their formats and whether they are busy", runDevicesCommand},
+		"probe":    {"Check ffmpeg and the device, list its formats and controls", runProbeCommand},
+		"serve":    {"Capture and serve the MJPEG stream (default)", serve},
+		"snapshot": {"Capture a single frame and write it to the --output file", runSnapshotCommand},
+		"version":  {"Print the version", runVersionCommand},
+	}
+)
+
+// extractCommand removes the subcommand from the arguments before they
+// are parsed, it needs to be the first argument
+func extractCommand() {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		return
+	}
+
+	command = os.Args[1]
+	os.Args = append(os.Args[:1], os.Args[2:]...)
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func runVersionCommand() error {
+	fmt.Printf("cam2mjpeg %s\n", version)
+	return nil
+}
+
+var shellSafeArg = regexp.MustCompile(`^[a-zA-Z0-9_./:=,+%@-]+$`)
+
+// runDryRun validates the device and prints the ffmpeg commands which
+// would be spawned instead of starting anything
+func runDryRun() error {
+	if !cfg.SkipPreflight {
+		if err := preflightCheck(cfg.Device); err != nil {
+			return err
+		}
+	}
+
+	capture := ffmpegCommand(context.Background(), captureArgs()...).Args
+	fmt.Println(shellCommand(capture[0], capture[1:]))
+
+	if cfg.RecordDir != "" {
+		record := ffmpegCommand(context.Background(), recordArgs()...).Args
+		fmt.Printf("# Recording, fed with the frames of the capture on stdin\n%s\n", shellCommand(record[0], record[1:]))
+	}
+
+	return nil
+}
+
+// shellCommand formats the command for copying it into a shell
+func shellCommand(name string, args []string) string {
+	parts := []string{name}
+	for _, a := range args {
+		if !shellSafeArg.MatchString(a) {
+			a = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+		parts = append(parts, a)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// runDevicesCommand lists the video device nodes with their driver,
+// card name, pixel formats and whether they are in use
+func runDevicesCommand() error {
+	nodes, err := filepath.Glob("/dev/video*")
+	if err != nil {
+		return errors.Wrap(err, "Unable to list devices")
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if len(nodes[i]) != len(nodes[j]) {
+			// Sort video10 after video9
+			return len(nodes[i]) < len(nodes[j])
+		}
+		return nodes[i] < nodes[j]
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tDRIVER\tCARD\tBUS\tCAPTURE\tBUSY\tFORMATS")
+	for _, node := range nodes {
+		fmt.Fprintln(tw, describeDevice(node))
+	}
+
+	return tw.Flush()
+}
+
+// describeDevice returns the tab separated device listing line for the
+// given device node
+func describeDevice(node string) string {
+	dev, err := openV4L2Device(node)
+	if err != nil {
+		return strings.Join([]string{node, "-", "-", "-", "-", "-", errors.Cause(err).Error()}, "\t")
+	}
+	defer dev.Close()
+
+	info, err := dev.Info()
+	if err != nil {
+		return strings.Join([]string{node, "-", "-", "-", "-", "-", "no video4linux2 device"}, "\t")
+	}
+
+	var (
+		busy    = "-"
+		capture = "no"
+		formats = "-"
+	)
+
+	if info.CanCapture {
+		capture = "yes"
+
+		if b, err := dev.IsBusy(); err == nil {
+			busy = map[bool]string{false: "no", true: "yes"}[b]
+		}
+
+		if f, err := dev.Formats(); err == nil && len(f) > 0 {
+			var names []string
+			for _, format := range f {
+				names = append(names, format.FourCC)
+			}
+			formats = strings.Join(names, ",")
+		}
+	}
+
+	return strings.Join([]string{node, info.Driver, info.Card, info.BusInfo, capture, busy, formats}, "\t")
+}
+
+// runProbeCommand runs the preflight checks and prints what the device
+// supports
+func runProbeCommand() error {
+	if err := preflightCheck(cfg.Device); err != nil {
+		return err
+	}
+
+	caps, err := getCapabilities()
+	if err != nil {
+		return err
+	}
+
+	controls, err := listControls()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Device:\t%s\nCard:\t%s\nDriver:\t%s\nBus:\t%s\n\nFormats:\n", cfg.Device, caps.Card, caps.Driver, caps.BusInfo)
+	for _, f := range caps.Formats {
+		var sizes []string
+		for _, s := range f.Sizes {
+			sizes = append(sizes, fmt.Sprintf("%dx%d", s.Width, s.Height))
+		}
+		if f.Stepwise != nil {
+			sizes = append(sizes, fmt.Sprintf("%dx%d-%dx%d", f.Stepwise.MinWidth, f.Stepwise.MinHeight, f.Stepwise.MaxWidth, f.Stepwise.MaxHeight))
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", f.FourCC, f.Description, strings.Join(sizes, " "))
+	}
+
+	fmt.Fprintln(tw, "\nControls:")
+	for _, c := range controls {
+		fmt.Fprintf(tw, "  %s\t%d\t(%s %d-%d, default %d)\n", c.Key, c.Value, c.Type, c.Min, c.Max, c.Default)
+	}
+
+	return tw.Flush()
+}
+
+// runSnapshotCommand captures a single frame using ffmpeg without
+// starting the HTTP server and writes it to the output file
+func runSnapshotCommand() error {
+	if !cfg.SkipPreflight {
+		if err := preflightCheck(cfg.Device); err != nil {
+			return err
+		}
+	}
+
+	frame, err := captureSingleFrame()
+	if err != nil {
+		return err
+	}
+
+	if cfg.Output == "" || cfg.Output == "-" {
+		_, err = os.Stdout.Write(frame)
+		return errors.Wrap(err, "Unable to write frame")
+	}
+
+	tmp := filepath.Join(filepath.Dir(cfg.Output), "."+filepath.Base(cfg.Output)+".tmp")
+	if err = os.WriteFile(tmp, frame, 0o644); err != nil {
+		return errors.Wrap(err, "Unable to write snapshot")
+	}
+
+	if err = os.Rename(tmp, cfg.Output); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "Unable to replace snapshot")
+	}
+
+	logger := log.WithFields(log.Fields{
+		"path": cfg.Output,
+		"size": len(frame),
+	})
+	if gray, err := decodeGrayFrame(frame, focusAnalysisWidth); err == nil {
+		// Allows to compare the focus between runs
+		logger = logger.WithField("sharpness", fmt.Sprintf("%.1f", sharpness(gray)))
+	}
+	logger.Info("Snapshot written")
+
+	return nil
+}
+
+// captureSingleFrame spawns ffmpeg and stops it after the first
+// complete frame was read
+func captureSingleFrame() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotCommandTimeout)
+	defer cancel()
+
+	stderr := ffmpegLogWriter("snapshot")
+	defer stderr.Close()
+
+	cmd := ffmpegCommand(ctx, captureArgs("-frames:v", "1")...)
+	cmd.Stderr = stderr
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = ffmpegStopTimeout
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create stdout pipe")
+	}
+
+	if err = cmd.Start(); err != nil {
+		return nil, errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+	defer func() {
+		cancel()
+		cmd.Wait()
+	}()
+
+	var (
+		buf   []byte
+		chunk = make([]byte, capture.InitialBufferSize)
+	)
+
+	for len(buf) < maxFrameSize {
+		n, err := out.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+
+		if l, ferr := capture.FrameLength(buf); ferr == nil {
+			return buf[:l], nil
+		} else if ferr != capture.ErrIncomplete {
+			return nil, errors.Wrap(ferr, "Unable to read frame")
+		}
+
+		if err != nil {
+			return nil, errors.Wrap(err, "ffmpeg exited before writing a frame")
+		}
+	}
+
+	return nil, errors.New("Frame exceeds maximum frame size")
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	rconfig "github.com/Luzifer/rconfig/v2"
+)
+
+// cfgLock guards the options which can be changed by reloading
+// the configuration while the process is running
+var cfgLock = new(sync.RWMutex)
+
+var (
+	// reloadableOptions lists the options applied when reloading the
+	// configuration, others require a restart of the process
+	reloadableOptions = map[string]bool{
+		"api-token":             true,
+		"client-webhook":        true,
+		"frame-hook-webhook":    true,
+		"height":                true,
+		"lifecycle-webhook":     true,
+		"log-level":             true,
+		"motion-webhook":        true,
+		"motion-webhook-attach": true,
+		"public-url":            true,
+		"quality":               true,
+		"rate":                  true,
+		"width":                 true,
+	}
+
+	// captureOptions need a restart of ffmpeg to be applied
+	captureOptions = map[string]bool{
+		"height":  true,
+		"quality": true,
+		"rate":    true,
+		"width":   true,
+	}
+)
+
+type configReloadResult struct {
+	Applied          []string `json:"applied"`
+	CaptureRestarted bool     `json:"capture_restarted"`
+	RestartRequired  []string `json:"restart_required"`
+}
+
+// configSections are the parts of the config file which cannot be
+// expressed as flags
+type configSections struct {
+	Cameras  []cameraConfig  `toml:"cameras" yaml:"cameras"`
+	Masks    []maskConfig    `toml:"masks" yaml:"masks"`
+	Overlays []overlayConfig `toml:"overlays" yaml:"overlays"`
+	Webhooks []webhookConfig `toml:"webhooks" yaml:"webhooks"`
+}
+
+// webhookConfig sends the events of the given types to the URL
+type webhookConfig struct {
+	Events []string `toml:"events" yaml:"events"`
+	URL    string   `toml:"url" yaml:"url"`
+}
+
+// configSectionNames are the keys of the sections in the config file
+var configSectionNames = []string{"cameras", "masks", "overlays", "webhooks"}
+
+// webhookEventOptions maps the event types of the webhooks section to
+// the options collecting their URLs
+var webhookEventOptions = map[string]string{
+	"client":     "client-webhook",
+	"frame-hook": "frame-hook-webhook",
+	"lifecycle":  "lifecycle-webhook",
+	"motion":     "motion-webhook",
+}
+
+// fileSections holds the sections of the config file, guarded by
+// cfgLock
+var fileSections configSections
+
+// loadConfigFile reads the YAML or TOML (by .toml extension) file at the
+// given path (keys are the long flag names) and presets its values as
+// defaults for the option parser. Flags given on the commandline still
+// take precedence. The structured sections are returned.
+func loadConfigFile(filename string) (configSections, error) {
+	var sections configSections
+
+	if filename == "" {
+		rconfig.SetVariableDefaults(nil)
+		return sections, nil
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return sections, errors.Wrap(err, "Unable to read config file")
+	}
+
+	values := map[string]interface{}{}
+	if strings.EqualFold(filepath.Ext(filename), ".toml") {
+		err = decodeTOMLConfig(data, values, &sections)
+	} else {
+		err = decodeYAMLConfig(data, values, &sections)
+	}
+	if err != nil {
+		return sections, errors.Wrap(err, "Unable to parse config file")
+	}
+
+	if err = sections.validate(); err != nil {
+		return sections, err
+	}
+
+	known := configFileKeys()
+	defaults := map[string]string{}
+	for key, value := range values {
+		if !known[key] {
+			log.WithField("key", key).Warn("Unknown option in config file")
+			continue
+		}
+
+		if defaults[key], err = configFileValue(value); err != nil {
+			return sections, errors.Wrapf(err, "Invalid value for %q", key)
+		}
+	}
+
+	// Webhooks are added to the URLs given in the options
+	for _, wh := range sections.Webhooks {
+		for _, evt := range wh.Events {
+			key := webhookEventOptions[evt]
+			if defaults[key] != "" {
+				defaults[key] += ","
+			}
+			defaults[key] += wh.URL
+		}
+	}
+
+	rconfig.SetVariableDefaults(defaults)
+	return sections, nil
+}
+
+// decodeTOMLConfig decodes the options into values and the sections
+// into their typed representation
+func decodeTOMLConfig(data []byte, values map[string]interface{}, sections *configSections) error {
+	if _, err := toml.Decode(string(data), &values); err != nil {
+		return err
+	}
+
+	md, err := toml.Decode(string(data), sections)
+	if err != nil {
+		return err
+	}
+	for _, key := range md.Undecoded() {
+		if slices.Contains(configSectionNames, key[0]) {
+			return errors.Errorf("Unknown key %q", key.String())
+		}
+	}
+
+	for _, name := range configSectionNames {
+		delete(values, name)
+	}
+	return nil
+}
+
+// decodeYAMLConfig decodes the options into values and the sections
+// into their typed representation
+func decodeYAMLConfig(data []byte, values map[string]interface{}, sections *configSections) error {
+	var doc struct {
+		Sections configSections         `yaml:",inline"`
+		Values   map[string]interface{} `yaml:",inline"`
+	}
+	if err := yaml.UnmarshalStrict(data, &doc); err != nil {
+		return err
+	}
+
+	*sections = doc.Sections
+	maps.Copy(values, doc.Values)
+	return nil
+}
+
+func (s configSections) validate() error {
+	names := map[string]bool{}
+	for _, c := range s.Cameras {
+		if err := c.validate(); err != nil {
+			return errors.Wrapf(err, "Invalid camera %q", c.Name)
+		}
+		if names[c.Name] {
+			return errors.Errorf("Duplicate camera %q", c.Name)
+		}
+		names[c.Name] = true
+	}
+
+	for i, m := range s.Masks {
+		if err := m.validate(); err != nil {
+			return errors.Wrapf(err, "Invalid mask %d", i+1)
+		}
+	}
+
+	for i, o := range s.Overlays {
+		if err := o.validate(); err != nil {
+			return errors.Wrapf(err, "Invalid overlay %d", i+1)
+		}
+	}
+
+	for _, wh := range s.Webhooks {
+		if u, err := url.Parse(wh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.Errorf("Webhook %q needs a HTTP(S) URL", wh.URL)
+		}
+		if len(wh.Events) == 0 {
+			return errors.Errorf("Webhook %q needs events", wh.URL)
+		}
+		for _, evt := range wh.Events {
+			if webhookEventOptions[evt] == "" {
+				return errors.Errorf("Unknown event %q for webhook %q", evt, wh.URL)
+			}
+		}
+	}
+
+	return nil
+}
+
+// configFileKeys collects the option names which can be set through
+// the config file
+func configFileKeys() map[string]bool {
+	keys := map[string]bool{}
+
+	t := reflect.TypeOf(cfg)
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("vardefault"); key != "" {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+// configFileValue converts a config file value into the string the option
+// parser expects, lists are given as comma separated values
+func configFileValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "", errors.New("Expected scalar or list")
+
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// validateCaptureOptions checks the capture settings are within the
+// ranges ffmpeg and the cameras accept
+func validateCaptureOptions(rate, width, height, quality int) error {
+	switch {
+	case rate < 1 || rate > 120:
+		return errors.Errorf("Frame rate must be within 1-120, got %d", rate)
+	case quality < 2 || quality > 31:
+		return errors.Errorf("Quality must be within 2-31 (lower is better), got %d", quality)
+	case width < 1 || height < 1 || width > 8192 || height > 8192:
+		return errors.Errorf("Resolution must be within 1x1-8192x8192, got %dx%d", width, height)
+	case width%2 != 0:
+		return errors.Errorf("Width must be even for YUYV capture, got %d", width)
+	}
+
+	return nil
+}
+
+// validateListenAddress checks the address is a valid host:port
+// combination or unix socket to listen on
+func validateListenAddress(addr string) error {
+	if path, ok := strings.CutPrefix(addr, unixSocketPrefix); ok {
+		if path == "" {
+			return errors.Errorf("Missing socket path in listen address %q", addr)
+		}
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid listen address %q (expected host:port or :port)", addr)
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return errors.Errorf("Invalid port %q in listen address %q", port, addr)
+	}
+
+	return nil
+}
+
+// validateOptionCombinations rejects options which have no effect or
+// contradict each other
+func validateOptionCombinations() error {
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"detector-command", cfg.DetectorCommand != ""},
+		{"detector-url", cfg.DetectorURL != ""},
+		{"motion-clip-dir", cfg.MotionClipDir != ""},
+		{"motion-zones", cfg.MotionZones != ""},
+	} {
+		if o.set && !cfg.Motion {
+			return errors.Errorf("Option --%s requires --motion", o.name)
+		}
+	}
+
+	switch {
+	case cfg.DetectorCommand != "" && cfg.DetectorURL != "":
+		return errors.New("Options --detector-command and --detector-url are exclusive")
+	case len(cfg.FrameHookWebhook) > 0 && cfg.FrameHook == "":
+		return errors.New("Option --frame-hook-webhook requires --frame-hook")
+	case cfg.FrameHookInterval < 0:
+		return errors.New("Frame hook interval must not be negative")
+	case cfg.ScriptTick < 0:
+		return errors.New("Script tick interval must not be negative")
+	case cfg.AudioDevice != "" && cfg.RecordDir == "":
+		return errors.New("Option --audio-device requires --record-dir")
+	case cfg.MQTTSnapshotInterval > 0 && cfg.MQTTBroker == "":
+		return errors.New("Option --mqtt-snapshot-interval requires --mqtt-broker")
+	case cfg.MQTTDiscoveryPrefix != "" && cfg.MQTTBroker == "":
+		return errors.New("Option --mqtt-discovery-prefix requires --mqtt-broker")
+	case cfg.MQTTSnapshotInterval < 0 || cfg.MQTTSnapshotWidth < 0:
+		return errors.New("MQTT snapshot interval and width must not be negative")
+	case (cfg.TelegramToken == "") != (cfg.TelegramChatID == ""):
+		return errors.New("Options --telegram-token and --telegram-chat-id are required together")
+	case len(recordSchedule) > 0 && cfg.RecordDir == "":
+		return errors.New("Option --record-schedule requires --record-dir")
+	case cfg.AdminListen != "" && slices.Contains(cfg.Listen, cfg.AdminListen):
+		return errors.New("Options --admin-listen and --listen must differ")
+	}
+
+	return nil
+}
+
+// reloadConfig re-reads the configuration and applies changed options
+// which can be changed at runtime, changed capture settings restart
+// ffmpeg while keeping clients connected
+func reloadConfig() (configReloadResult, error) {
+	cfgLock.Lock()
+	defer cfgLock.Unlock()
+
+	res := configReloadResult{Applied: []string{}, RestartRequired: []string{}}
+
+	nc := cfg
+	sections, err := loadConfigFile(cfg.Config)
+	if err != nil {
+		return res, err
+	}
+
+	if err = rconfig.ParseAndValidate(&nc); err != nil {
+		return res, errors.Wrap(err, "Unable to parse options")
+	}
+
+	level, err := log.ParseLevel(nc.LogLevel)
+	if err != nil {
+		return res, errors.Wrap(err, "Unable to parse log level")
+	}
+
+	if err = validateCaptureOptions(nc.FrameRate, nc.Width, nc.Height, nc.Quality); err != nil {
+		return res, err
+	}
+
+	if nc.Frigate {
+		// Overridden by applyFrigateMode on startup
+		nc.OnDemand, nc.IdleFPS = false, 0
+	}
+
+	var (
+		cur  = reflect.ValueOf(&cfg).Elem()
+		next = reflect.ValueOf(nc)
+	)
+
+	for i := 0; i < cur.NumField(); i++ {
+		key := cur.Type().Field(i).Tag.Get("vardefault")
+		if key == "" || reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+
+		if !reloadableOptions[key] {
+			res.RestartRequired = append(res.RestartRequired, key)
+			continue
+		}
+
+		cur.Field(i).Set(next.Field(i))
+		res.Applied = append(res.Applied, key)
+		res.CaptureRestarted = res.CaptureRestarted || captureOptions[key]
+	}
+
+	camerasChanged := !reflect.DeepEqual(sections.Cameras, fileSections.Cameras)
+	if camerasChanged {
+		res.Applied = append(res.Applied, "cameras")
+	}
+	for _, s := range []struct {
+		changed bool
+		key     string
+	}{
+		{!reflect.DeepEqual(sections.Masks, fileSections.Masks), "masks"},
+		{!reflect.DeepEqual(sections.Overlays, fileSections.Overlays), "overlays"},
+	} {
+		// Drawn by the capture filter
+		if s.changed {
+			res.Applied = append(res.Applied, s.key)
+			res.CaptureRestarted = true
+		}
+	}
+	fileSections = sections
+
+	log.SetLevel(level)
+
+	logger := log.WithFields(log.Fields{
+		"applied":         res.Applied,
+		"restart_capture": res.CaptureRestarted,
+	})
+	if len(res.RestartRequired) > 0 {
+		logger.WithField("restart_required", res.RestartRequired).Warn("Configuration reloaded, some options require a restart")
+	} else {
+		logger.Info("Configuration reloaded")
+	}
+
+	if res.CaptureRestarted {
+		restartCapture()
+	}
+	if camerasChanged {
+		applyCameras(sections.Cameras)
+	}
+
+	return res, nil
+}
+
+// cfgValue reads an option which might be changed by reloading the
+// configuration
+func cfgValue[T any](v *T) T {
+	cfgLock.RLock()
+	defer cfgLock.RUnlock()
+
+	return *v
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	res, err := reloadConfig()
+	if err != nil {
+		log.WithError(err).Error("Unable to reload configuration")
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, res)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// controlStateDevice identifies the device node the controls were
+	// last stored from or restored to, a new node means the device
+	// re-enumerated and was most likely reset
+	controlStateDevice     os.FileInfo
+	controlStateDeviceLock sync.Mutex
+	controlStateSaved      map[string]int32
+)
+
+// loadControlState reads the stored control values, a missing state
+// file is not an error
+func loadControlState() (map[string]int32, error) {
+	data, err := os.ReadFile(cfg.ControlState)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Unable to read control state")
+	}
+
+	var values map[string]int32
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse control state")
+	}
+
+	return values, nil
+}
+
+// restoreControlState applies the stored control values to the device
+func restoreControlState() error {
+	controlStateDeviceLock.Lock()
+	defer controlStateDeviceLock.Unlock()
+
+	return restoreControlStateLocked()
+}
+
+func restoreControlStateLocked() error {
+	info, err := os.Stat(cfg.Device)
+	if err != nil {
+		return errors.Wrap(err, "Unable to stat device")
+	}
+
+	values, err := loadControlState()
+	if err != nil || values == nil {
+		controlStateDevice = info
+		return err
+	}
+
+	if err = setControls(values); err != nil {
+		return err
+	}
+
+	controlStateDevice = info
+	controlStateSaved = values
+	log.WithField("controls", len(values)).Info("Camera control state restored")
+	return nil
+}
+
+// restoreControlStateIfReplaced restores the control values when the
+// device node changed since the state was last stored or restored
+func restoreControlStateIfReplaced() {
+	controlStateDeviceLock.Lock()
+	defer controlStateDeviceLock.Unlock()
+
+	info, err := os.Stat(cfg.Device)
+	if err != nil || (controlStateDevice != nil && os.SameFile(info, controlStateDevice)) {
+		return
+	}
+
+	log.WithField("camera", cfg.Device).Info("Device re-enumerated, restoring control state")
+	if err = restoreControlStateLocked(); err != nil {
+		log.WithError(err).Error("Unable to restore control state")
+	}
+}
+
+// saveControlState stores the current control values unless the device
+// was replaced and not yet restored
+func saveControlState() error {
+	controlStateDeviceLock.Lock()
+	defer controlStateDeviceLock.Unlock()
+
+	info, err := os.Stat(cfg.Device)
+	if err != nil {
+		return errors.Wrap(err, "Unable to stat device")
+	}
+
+	if controlStateDevice == nil || !os.SameFile(info, controlStateDevice) {
+		// Not restored yet, values are most likely defaults after a
+		// reset: keep the stored state
+		return nil
+	}
+
+	values, err := writableControlValues(true)
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(values, controlStateSaved) {
+		return nil
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode control state")
+	}
+
+	tmp := filepath.Join(filepath.Dir(cfg.ControlState), "."+filepath.Base(cfg.ControlState)+".tmp")
+	if err = os.WriteFile(tmp, data, 0o644); err != nil {
+		return errors.Wrap(err, "Unable to write control state")
+	}
+
+	if err = os.Rename(tmp, cfg.ControlState); err != nil {
+		return errors.Wrap(err, "Unable to replace control state")
+	}
+
+	controlStateDevice = info
+	controlStateSaved = values
+	log.WithField("controls", len(values)).Debug("Camera control state stored")
+	return nil
+}
+
+// runControlState periodically stores the control values until the
+// context is cancelled
+func runControlState(ctx context.Context) {
+	t := time.NewTicker(cfg.ControlStateInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := saveControlState(); err != nil {
+				log.WithError(err).Debug("Unable to store control state")
+			}
+			return
+		case <-t.C:
+			if err := saveControlState(); err != nil {
+				log.WithError(err).Debug("Unable to store control state")
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// cameraControl describes a V4L2 control of the camera, Key is the
+// control name in the format also used by v4l2-ctl
+type cameraControl struct {
+	Default int32            `json:"default"`
+	Flags   []string         `json:"flags,omitempty"`
+	ID      uint32           `json:"id"`
+	Key     string           `json:"key"`
+	Max     int32            `json:"max"`
+	Menu    map[int32]string `json:"menu,omitempty"`
+	Min     int32            `json:"min"`
+	Name    string           `json:"name"`
+	Step    int32            `json:"step"`
+	Type    string           `json:"type"`
+	Value   int32            `json:"value"`
+}
+
+// powerLineFrequencyKey is the anti-flicker menu control, its values
+// are 0 (disabled), 1 (50 Hz) and 2 (60 Hz)
+const powerLineFrequencyKey = "power_line_frequency"
+
+var controlKeyCleaner = regexp.MustCompile(`[^a-z0-9]+`)
+
+// controlKey converts a control name ("White Balance Temperature, Auto")
+// into its key ("white_balance_temperature_auto")
+func controlKey(name string) string {
+	return strings.Trim(controlKeyCleaner.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
+
+// listControls opens the capture device and lists its controls
+func listControls() ([]cameraControl, error) {
+	dev, err := openV4L2Device(cfg.Device)
+	if err != nil {
+		return nil, err
+	}
+	defer dev.Close()
+
+	return dev.Controls()
+}
+
+// setControls sets the given controls (by key) on the capture device
+func setControls(values map[string]int32) error {
+	dev, err := openV4L2Device(cfg.Device)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+
+	controls, err := dev.Controls()
+	if err != nil {
+		return err
+	}
+
+	byKey := map[string]cameraControl{}
+	for _, c := range controls {
+		byKey[c.Key] = c
+	}
+
+	for _, key := range controlApplyOrder(values, byKey) {
+		value := values[key]
+		c, ok := byKey[key]
+		if !ok {
+			return errors.Errorf("Unknown control %q", key)
+		}
+
+		if value < c.Min || value > c.Max {
+			return errors.Errorf("Value %d for control %q out of range %d-%d", value, key, c.Min, c.Max)
+		}
+
+		if err = dev.SetControl(c.ID, value); err != nil {
+			return errors.Wrapf(err, "Unable to set control %q", key)
+		}
+
+		log.WithFields(log.Fields{
+			"control": key,
+			"value":   value,
+		}).Info("Camera control changed")
+	}
+
+	return nil
+}
+
+// controlApplyOrder sorts the keys to set switches and menus (like the
+// automatic modes) before the values depending on them
+func controlApplyOrder(values map[string]int32, byKey map[string]cameraControl) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	rank := func(key string) int {
+		if t := byKey[key].Type; t == "int" || t == "" {
+			return 1
+		}
+		return 0
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if ri, rj := rank(keys[i]), rank(keys[j]); ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
+
+// setPowerLineFrequency configures the camera for the given mains
+// frequency (50 or 60 Hz) to prevent banding
+func setPowerLineFrequency(hz int) error {
+	value := int32(1)
+	if hz == 60 {
+		value = 2
+	}
+
+	return setControls(map[string]int32{powerLineFrequencyKey: value})
+}
+
+func handleControlsGet(w http.ResponseWriter, r *http.Request) {
+	controls, err := listControls()
+	if err != nil {
+		log.WithError(err).Error("Unable to list camera controls")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to list camera controls")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, controls)
+}
+
+// handleControlsPatch sets the controls given as JSON object of keys
+// and values and returns the updated control list
+func handleControlsPatch(w http.ResponseWriter, r *http.Request) {
+	var values map[string]int32
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Expecting JSON object of control keys and values")
+		return
+	}
+
+	if err := setControls(values); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	handleControlsGet(w, r)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+// duplicateFrameKeepalive is the longest time identical frames are
+// withheld to let new subscribers and snapshots receive a frame
+const duplicateFrameKeepalive = time.Second
+
+// duplicateFilter detects frames identical to the last sent one which
+// passthrough cameras deliver for static scenes
+type duplicateFilter struct {
+	hash uint64
+	sent time.Time
+	size int
+}
+
+// Duplicate reports whether the frame is identical to the last frame
+// which was not reported as duplicate
+func (d *duplicateFilter) Duplicate(f *frame) bool {
+	h := fnv.New64a()
+	h.Write(f.Data)
+	sum := h.Sum64()
+
+	if len(f.Data) == d.size && sum == d.hash && f.Time.Sub(d.sent) < duplicateFrameKeepalive {
+		return true
+	}
+
+	d.hash, d.sent, d.size = sum, f.Time, len(f.Data)
+	return false
+}
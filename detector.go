@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	detectorHoldoff = 2 * time.Second
+	detectorTimeout = 30 * time.Second
+)
+
+// detection is a single object found by the external detector, the
+// coordinates are given in pixels of the analyzed frame
+type detection struct {
+	Confidence float64 `json:"confidence"`
+	Label      string  `json:"label"`
+	XMax       int     `json:"x_max"`
+	XMin       int     `json:"x_min"`
+	YMax       int     `json:"y_max"`
+	YMin       int     `json:"y_min"`
+}
+
+// detectorResponse is the DeepStack / CodeProject.AI style response also
+// expected on stdout of the detector command
+type detectorResponse struct {
+	Predictions []detection `json:"predictions"`
+	Success     *bool       `json:"success"`
+}
+
+func detectorConfigured() bool {
+	return cfg.DetectorURL != "" || cfg.DetectorCommand != ""
+}
+
+// detectObjects passes the frame to the configured detector and returns
+// the objects found
+func detectObjects(img []byte) ([]detection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), detectorTimeout)
+	defer cancel()
+
+	var (
+		body []byte
+		err  error
+	)
+
+	if cfg.DetectorCommand != "" {
+		body, err = detectExec(ctx, img)
+	} else {
+		body, err = detectHTTP(ctx, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var resp detectorResponse
+	if err = json.Unmarshal(body, &resp); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse detector response")
+	}
+
+	if resp.Success != nil && !*resp.Success {
+		return nil, errors.New("Detector reported failure")
+	}
+
+	return resp.Predictions, nil
+}
+
+// detectExec passes the JPEG on stdin of the detector command executed
+// through the shell and reads the response from its stdout
+func detectExec(ctx context.Context, img []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cfg.DetectorCommand)
+	cmd.Stdin = bytes.NewReader(img)
+
+	stderr := log.WithField("component", "detector").WriterLevel(log.WarnLevel)
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	return out, errors.Wrap(err, "Unable to execute detector command")
+}
+
+// detectHTTP posts the JPEG as "image" field of a multipart form to the
+// detector URL (DeepStack / CodeProject.AI API)
+func detectHTTP(ctx context.Context, img []byte) ([]byte, error) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	part, err := mw.CreateFormFile("image", "frame.jpg")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create image part")
+	}
+
+	if _, err = part.Write(img); err != nil {
+		return nil, errors.Wrap(err, "Unable to write image part")
+	}
+
+	if err = mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "Unable to finish multipart body")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.DetectorURL, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("User-Agent", "cam2mjpeg/"+version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to execute request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.Errorf("Unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(resp.Body)
+	return buf.Bytes(), errors.Wrap(err, "Unable to read response")
+}
+
+// filterDetections returns the detections matching the configured
+// labels (all labels if none are configured) and minimum confidence
+func filterDetections(in []detection) []detection {
+	var out []detection
+	for _, d := range in {
+		if d.Confidence < cfg.DetectorMinConfidence {
+			continue
+		}
+
+		if !detectorLabelAllowed(d.Label) {
+			continue
+		}
+
+		out = append(out, d)
+	}
+	return out
+}
+
+func detectorLabelAllowed(label string) bool {
+	var filtered bool
+	for _, l := range cfg.DetectorLabels {
+		if l == "" {
+			continue
+		}
+
+		filtered = true
+		if strings.EqualFold(l, label) {
+			return true
+		}
+	}
+
+	return !filtered
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// cameraUUID returns a stable identifier of the camera announced by the
+// discovery protocols
+func cameraUUID() string {
+	hostname, _ := os.Hostname()
+	return uuid.NewV5(uuid.NamespaceURL, "cam2mjpeg://"+hostname+cfg.Device).String()
+}
+
+// cameraModel returns the card name of the capture device
+func cameraModel() string {
+	if caps, err := getCapabilities(); err == nil && caps.Card != "" {
+		return caps.Card
+	}
+	return "USB camera"
+}
+
+// discoveryBaseURL returns the base URL reachable by the given peer:
+// the public URL if configured or the local address routed to the peer
+// with the port of the first TCP listener
+func discoveryBaseURL(peer *net.UDPAddr) (string, error) {
+	if u := cfgValue(&cfg.PublicURL); u != "" {
+		return strings.TrimRight(u, "/"), nil
+	}
+
+	var port string
+	for _, addr := range cfg.Listen {
+		if _, p, err := net.SplitHostPort(addr); err == nil && !strings.HasPrefix(addr, unixSocketPrefix) {
+			port = p
+			break
+		}
+	}
+	if port == "" {
+		return "", errors.New("No TCP listener to announce")
+	}
+
+	conn, err := net.DialUDP("udp4", nil, peer)
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to determine local address")
+	}
+	defer conn.Close()
+
+	host := conn.LocalAddr().(*net.UDPAddr).IP.String()
+	return "http://" + net.JoinHostPort(host, port), nil
+}
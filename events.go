@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Types of the events published through the event bus
+const (
+	eventClient    = "client"
+	eventFrameHook = "frame_hook"
+	eventLifecycle = "lifecycle"
+	eventMotion    = "motion"
+	eventRecording = "recording"
+)
+
+const (
+	eventBufferSize        = 64
+	eventKeepaliveInterval = 30 * time.Second
+)
+
+type (
+	// busEvent wraps the payload of an event, for most types the
+	// payload is the one sent to the corresponding webhooks
+	busEvent struct {
+		Data interface{} `json:"data"`
+		ID   uint64      `json:"id"`
+		Time time.Time   `json:"time"`
+		Type string      `json:"type"`
+	}
+
+	// eventBus distributes internal events to subscribers without
+	// blocking the publisher, events for slow subscribers are dropped
+	eventBus struct {
+		closed bool
+		lock   sync.Mutex
+		seq    uint64
+		subs   map[chan busEvent]struct{}
+	}
+
+	recordingEvent struct {
+		Camera  string   `json:"camera"`
+		Event   string   `json:"event"`
+		Sources []string `json:"sources"`
+	}
+)
+
+var (
+	events = newEventBus()
+
+	eventUpgrader = websocket.Upgrader{}
+)
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: map[chan busEvent]struct{}{}}
+}
+
+// Close closes all subscriptions and discards further events
+func (e *eventBus) Close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.closed = true
+	for c := range e.subs {
+		close(c)
+		delete(e.subs, c)
+	}
+}
+
+// Publish sends the event to all subscribers
+func (e *eventBus) Publish(typ string, data interface{}) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.closed {
+		return
+	}
+
+	e.seq++
+	evt := busEvent{Data: data, ID: e.seq, Time: time.Now(), Type: typ}
+
+	for c := range e.subs {
+		select {
+		case c <- evt:
+		default:
+			log.WithField("type", typ).Debug("Event subscriber did not keep up, dropping event")
+		}
+	}
+}
+
+// Subscribe returns a channel receiving all events until the returned
+// function is called or the bus is closed
+func (e *eventBus) Subscribe() (<-chan busEvent, func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	c := make(chan busEvent, eventBufferSize)
+	if e.closed {
+		close(c)
+		return c, func() {}
+	}
+	e.subs[c] = struct{}{}
+
+	return c, func() {
+		e.lock.Lock()
+		defer e.lock.Unlock()
+
+		if _, ok := e.subs[c]; ok {
+			close(c)
+			delete(e.subs, c)
+		}
+	}
+}
+
+// publishMotionEvent is the motion event listener publishing to the
+// event bus
+func publishMotionEvent(evt motionEvent) {
+	events.Publish(eventMotion, newMotionWebhookPayload(evt))
+}
+
+// handleEvents streams the events as server-sent events or through a
+// WebSocket, limited to the comma separated types parameter if given
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	var types map[string]bool
+	if v := r.URL.Query().Get("types"); v != "" {
+		types = map[string]bool{}
+		for _, t := range strings.Split(v, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	c, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	next := func() (busEvent, bool) {
+		for evt := range c {
+			if types == nil || types[evt.Type] {
+				return evt, true
+			}
+		}
+		return busEvent{}, false
+	}
+
+	if websocket.IsWebSocketUpgrade(r) {
+		streamEventsWebSocket(w, r, next)
+		return
+	}
+
+	streamEventsSSE(w, r, next)
+}
+
+func streamEventsSSE(w http.ResponseWriter, r *http.Request, next func() (busEvent, bool)) {
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.WithError(err).Error("Unable to flush event stream")
+		return
+	}
+
+	evts := make(chan busEvent)
+	go func() {
+		defer close(evts)
+		for {
+			evt, ok := next()
+			if !ok {
+				return
+			}
+			select {
+			case evts <- evt:
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		var msg string
+
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-keepalive.C:
+			msg = ": keepalive\n\n"
+
+		case evt, ok := <-evts:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(evt)
+			if err != nil {
+				log.WithError(err).WithField("type", evt.Type).Error("Unable to marshal event")
+				continue
+			}
+			msg = fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, data)
+		}
+
+		if err := writeWithDeadline(w, rc, msg); err != nil {
+			log.WithError(err).Debug("Unable to write event, closing event stream")
+			return
+		}
+	}
+}
+
+func streamEventsWebSocket(w http.ResponseWriter, r *http.Request, next func() (busEvent, bool)) {
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already responded with an error
+		log.WithError(err).Debug("Unable to upgrade event connection")
+		return
+	}
+	defer conn.Close()
+
+	// Reading is required to process control messages, the client is
+	// not expected to send anything else
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	evts := make(chan busEvent)
+	go func() {
+		defer close(evts)
+		for {
+			evt, ok := next()
+			if !ok {
+				return
+			}
+			select {
+			case evts <- evt:
+			case <-closed:
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(eventKeepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+
+		select {
+		case <-closed:
+			return
+
+		case <-keepalive.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventKeepaliveInterval))
+
+		case evt, ok := <-evts:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+				return
+			}
+
+			if cfg.ClientWriteTimeout > 0 {
+				conn.SetWriteDeadline(time.Now().Add(cfg.ClientWriteTimeout))
+			}
+			err = conn.WriteJSON(evt)
+		}
+
+		if err != nil {
+			log.WithError(err).Debug("Unable to write event, closing event connection")
+			return
+		}
+	}
+}
+
+// writeWithDeadline writes and flushes the message within the client
+// write timeout
+func writeWithDeadline(w http.ResponseWriter, rc *http.ResponseController, msg string) error {
+	if cfg.ClientWriteTimeout > 0 {
+		_ = rc.SetWriteDeadline(time.Now().Add(cfg.ClientWriteTimeout))
+	}
+
+	if _, err := fmt.Fprint(w, msg); err != nil {
+		return err
+	}
+	return rc.Flush()
+}
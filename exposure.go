@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Luminance values considered as clipped (crushed blacks / blown
+// highlights) in the downscaled frame
+const (
+	exposureClipDark   = 5
+	exposureClipBright = 250
+)
+
+type exposureStats struct {
+	ClippedBright float64   `json:"clipped_bright"`
+	ClippedDark   float64   `json:"clipped_dark"`
+	MeanLuminance float64   `json:"mean_luminance"`
+	Updated       time.Time `json:"updated"`
+}
+
+var (
+	currentExposure     *exposureStats
+	currentExposureLock = new(sync.RWMutex)
+)
+
+func init() {
+	if _, err := meter.Float64ObservableGauge("cam2mjpeg.exposure.luminance",
+		metric.WithDescription("Mean luminance of the last analyzed frame (0-255)"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			if s := getExposure(); s != nil {
+				o.Observe(s.MeanLuminance)
+			}
+			return nil
+		})); err != nil {
+		log.WithError(err).Fatal("Unable to create luminance instrument")
+	}
+
+	if _, err := meter.Float64ObservableGauge("cam2mjpeg.exposure.clipped",
+		metric.WithDescription("Fraction of clipped pixels in the last analyzed frame"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			if s := getExposure(); s != nil {
+				o.Observe(s.ClippedDark, metric.WithAttributes(attribute.String("side", "dark")))
+				o.Observe(s.ClippedBright, metric.WithAttributes(attribute.String("side", "bright")))
+			}
+			return nil
+		})); err != nil {
+		log.WithError(err).Fatal("Unable to create clipping instrument")
+	}
+}
+
+// getExposure returns the last computed statistics or nil if none
+// were computed yet
+func getExposure() *exposureStats {
+	currentExposureLock.RLock()
+	defer currentExposureLock.RUnlock()
+
+	return currentExposure
+}
+
+// runExposureStats computes the statistics of a frame per interval
+// while the capture is running until the context is cancelled
+func runExposureStats(ctx context.Context) {
+	t := time.NewTicker(cfg.ExposureInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if !isCapturing() {
+			// Do not keep an on-demand capture running for statistics
+			continue
+		}
+
+		imgCtx, cancel := context.WithTimeout(ctx, cfg.ExposureInterval)
+		img, err := frameBroadcaster.NextFrame(imgCtx)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		frame, err := decodeGrayFrame(img.Data, motionAnalysisWidth)
+		img.Release()
+		if err != nil {
+			log.WithError(err).Debug("Unable to decode frame for exposure statistics")
+			continue
+		}
+
+		stats := computeExposure(frame)
+
+		currentExposureLock.Lock()
+		currentExposure = &stats
+		currentExposureLock.Unlock()
+	}
+}
+
+func computeExposure(frame *grayFrame) exposureStats {
+	var sum, dark, bright int
+	for _, p := range frame.Pix {
+		sum += int(p)
+
+		switch {
+		case p <= exposureClipDark:
+			dark++
+		case p >= exposureClipBright:
+			bright++
+		}
+	}
+
+	n := float64(len(frame.Pix))
+	return exposureStats{
+		ClippedBright: float64(bright) / n,
+		ClippedDark:   float64(dark) / n,
+		MeanLuminance: float64(sum) / n,
+		Updated:       time.Now(),
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// ffmpegCommand returns the command running ffmpeg with the given
+// arguments at the configured nice level, CPU set and cgroup
+func ffmpegCommand(ctx context.Context, args ...string) *exec.Cmd {
+	var wrap []string
+	if cfg.FFMpegNice != 0 {
+		wrap = append(wrap, "nice", "-n", strconv.Itoa(cfg.FFMpegNice))
+	}
+	if cfg.FFMpegCPUs != "" {
+		wrap = append(wrap, "taskset", "-c", cfg.FFMpegCPUs)
+	}
+
+	// Both wrappers exec ffmpeg keeping the process ID so signals
+	// still reach ffmpeg
+	wrap = append(wrap, "ffmpeg")
+	cmd := exec.CommandContext(ctx, wrap[0], append(wrap[1:], args...)...)
+	applyFFmpegCgroup(cmd)
+
+	return cmd
+}
+
+// validateFFmpegLimits checks the limit options and prepares the cgroup
+// to spawn ffmpeg in
+func validateFFmpegLimits() error {
+	if cfg.FFMpegNice < -20 || cfg.FFMpegNice > 19 {
+		return errors.Errorf("Nice level must be within -20..19, got %d", cfg.FFMpegNice)
+	}
+
+	if cfg.FFMpegThreads < 0 {
+		return errors.Errorf("Thread count must not be negative, got %d", cfg.FFMpegThreads)
+	}
+
+	for tool, used := range map[string]bool{"nice": cfg.FFMpegNice != 0, "taskset": cfg.FFMpegCPUs != ""} {
+		if _, err := exec.LookPath(tool); used && err != nil {
+			return errors.Wrapf(err, "%s not found", tool)
+		}
+	}
+
+	if cfg.FFMpegCgroup != "" {
+		return errors.Wrap(openFFmpegCgroup(cfg.FFMpegCgroup), "Unable to open cgroup")
+	}
+
+	return nil
+}
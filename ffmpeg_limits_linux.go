@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ffmpegCgroupFD is the cgroup directory ffmpeg is spawned in, kept
+// open for the lifetime of the process
+var ffmpegCgroupFD = -1
+
+func openFFmpegCgroup(path string) error {
+	if _, err := os.Stat(filepath.Join(path, "cgroup.controllers")); err != nil {
+		return errors.Wrap(err, "Not a cgroup v2 directory")
+	}
+
+	fd, err := syscall.Open(path, syscall.O_DIRECTORY|syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+
+	ffmpegCgroupFD = fd
+	return nil
+}
+
+// applyFFmpegCgroup lets the command be cloned directly into the cgroup
+// so no thread of it ever runs outside its limits
+func applyFFmpegCgroup(cmd *exec.Cmd) {
+	if ffmpegCgroupFD < 0 {
+		return
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{CgroupFD: ffmpegCgroupFD, UseCgroupFD: true}
+}
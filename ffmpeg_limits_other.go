@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+func openFFmpegCgroup(path string) error {
+	return errors.New("cgroups are only supported on Linux")
+}
+
+func applyFFmpegCgroup(cmd *exec.Cmd) {}
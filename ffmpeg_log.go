@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type ffmpegLogClassifier struct {
+	Match *regexp.Regexp
+	Level log.Level
+	Kind  string
+}
+
+var (
+	// Classifiers are evaluated in order, first match wins
+	ffmpegLogClassifiers = []ffmpegLogClassifier{
+		{regexp.MustCompile(`(?i)device or resource busy`), log.ErrorLevel, "device_busy"},
+		{regexp.MustCompile(`(?i)(no such file or directory|no such device)`), log.ErrorLevel, "device_missing"},
+		{regexp.MustCompile(`(?i)permission denied`), log.ErrorLevel, "permission_denied"},
+		{regexp.MustCompile(`(?i)(not supported|unsupported|cannot find a proper format|invalid argument)`), log.ErrorLevel, "unsupported_format"},
+		{regexp.MustCompile(`(?i)(dropping frame|frames? dropped|buffer (is )?full|past duration too large)`), log.WarnLevel, "dropped_frames"},
+		{regexp.MustCompile(`(?i)\berror\b`), log.ErrorLevel, "error"},
+		{regexp.MustCompile(`(?i)\bwarning\b`), log.WarnLevel, "warning"},
+	}
+
+	ffmpegProgressLine = regexp.MustCompile(`^frame=\s*(\d+).*?drop=\s*(\d+)`)
+)
+
+// ffmpegLogWriter returns a writer to be used as stderr of an ffmpeg
+// process which passes everything written to logFFMpegOutput. It
+// must be closed after the process exited.
+func ffmpegLogWriter(process string) io.WriteCloser {
+	pr, pw := io.Pipe()
+	go logFFMpegOutput(pr, process)
+	return pw
+}
+
+// logFFMpegOutput reads the stderr of ffmpeg line-by-line and emits
+// leveled log entries for every line until the reader is closed. The
+// process name tells apart multiple ffmpeg processes (capture, recorder).
+func logFFMpegOutput(r io.Reader, process string) {
+	var (
+		lastDrop int
+		logger   = log.WithFields(log.Fields{
+			"camera":    cfg.Device,
+			"component": "ffmpeg",
+			"process":   process,
+		})
+	)
+
+	scanner := bufio.NewScanner(r)
+	// ffmpeg terminates progress lines using carriage returns
+	scanner.Split(scanFFMpegLines)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if m := ffmpegProgressLine.FindStringSubmatch(line); m != nil {
+			drop, _ := strconv.Atoi(m[2])
+			if drop > lastDrop {
+				logger.WithFields(log.Fields{
+					"dropped": drop - lastDrop,
+					"frame":   m[1],
+					"kind":    "dropped_frames",
+				}).Warn("ffmpeg dropped frames")
+			}
+			lastDrop = drop
+			continue
+		}
+
+		entry := logger
+		level := log.DebugLevel
+		if cfg.FFMpegLog {
+			level = log.InfoLevel
+		}
+
+		for _, c := range ffmpegLogClassifiers {
+			if c.Match.MatchString(line) {
+				entry = logger.WithField("kind", c.Kind)
+				level = c.Level
+				break
+			}
+		}
+
+		entry.Log(level, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		logger.WithError(err).Error("Unable to read ffmpeg output")
+	}
+}
+
+func scanFFMpegLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	focusAnalysisWidth = 640
+	focusMaxSteps      = 100
+	focusSettleDefault = 500 * time.Millisecond
+)
+
+// Control keys used by the UVC driver for focus, the autofocus key
+// changed in Linux 5.x
+var (
+	focusAbsoluteKey = "focus_absolute"
+	focusAutoKeys    = []string{"focus_automatic_continuous", "focus_auto"}
+)
+
+type (
+	focusState struct {
+		Auto     *bool `json:"auto,omitempty"`
+		Max      int32 `json:"max"`
+		Min      int32 `json:"min"`
+		Position int32 `json:"position"`
+		Step     int32 `json:"step"`
+	}
+
+	focusChange struct {
+		Auto     *bool  `json:"auto,omitempty"`
+		Position *int32 `json:"position,omitempty"`
+	}
+
+	// focusSweepRequest steps through the focus positions, omitted
+	// values default to the full range in 20 steps
+	focusSweepRequest struct {
+		Apply  *bool  `json:"apply,omitempty"`
+		From   *int32 `json:"from,omitempty"`
+		Settle string `json:"settle,omitempty"`
+		Step   *int32 `json:"step,omitempty"`
+		To     *int32 `json:"to,omitempty"`
+	}
+
+	focusSweepResult struct {
+		Best    int32             `json:"best"`
+		Results []focusSweepPoint `json:"results"`
+	}
+
+	focusSweepPoint struct {
+		Position  int32   `json:"position"`
+		Sharpness float64 `json:"sharpness"`
+	}
+)
+
+// getFocusState returns the focus state and the key of the autofocus
+// control (empty if not supported), ok is false without focus support
+func getFocusState() (state focusState, autoKey string, ok bool, err error) {
+	controls, err := listControls()
+	if err != nil {
+		return state, "", false, err
+	}
+
+	byKey := map[string]cameraControl{}
+	for _, c := range controls {
+		byKey[c.Key] = c
+	}
+
+	abs, ok := byKey[focusAbsoluteKey]
+	if !ok {
+		return state, "", false, nil
+	}
+	state = focusState{Max: abs.Max, Min: abs.Min, Position: abs.Value, Step: abs.Step}
+
+	for _, k := range focusAutoKeys {
+		if c, found := byKey[k]; found {
+			auto := c.Value != 0
+			state.Auto, autoKey = &auto, k
+			break
+		}
+	}
+
+	return state, autoKey, true, nil
+}
+
+func handleFocusGet(w http.ResponseWriter, r *http.Request) {
+	state, _, ok, err := getFocusState()
+	switch {
+	case err != nil:
+		log.WithError(err).Error("Unable to query focus state")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to query focus state")
+	case !ok:
+		writeAPIError(w, http.StatusNotFound, "Camera does not support focus control")
+	default:
+		writeAPIResponse(w, http.StatusOK, state)
+	}
+}
+
+// handleFocusSet toggles autofocus and / or sets the focus position,
+// setting a position implicitly disables autofocus
+func handleFocusSet(w http.ResponseWriter, r *http.Request) {
+	var change focusChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Unable to parse focus change")
+		return
+	}
+
+	_, autoKey, ok, err := getFocusState()
+	switch {
+	case err != nil:
+		log.WithError(err).Error("Unable to query focus state")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to query focus state")
+		return
+	case !ok:
+		writeAPIError(w, http.StatusNotFound, "Camera does not support focus control")
+		return
+	}
+
+	if change.Position != nil && change.Auto == nil {
+		manual := false
+		change.Auto = &manual
+	}
+
+	if change.Auto != nil && autoKey != "" {
+		var v int32
+		if *change.Auto {
+			v = 1
+		}
+
+		// Autofocus must be disabled before the position is writable
+		if err = setControls(map[string]int32{autoKey: v}); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	if change.Position != nil {
+		if err = setControls(map[string]int32{focusAbsoluteKey: *change.Position}); err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	handleFocusGet(w, r)
+}
+
+// handleFocusSweep disables autofocus, steps through the focus range
+// scoring the sharpness of a frame per position and finally applies
+// the sharpest position unless apply is false
+func handleFocusSweep(w http.ResponseWriter, r *http.Request) {
+	var req focusSweepRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Unable to parse sweep request")
+			return
+		}
+	}
+
+	state, autoKey, ok, err := getFocusState()
+	switch {
+	case err != nil:
+		log.WithError(err).Error("Unable to query focus state")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to query focus state")
+		return
+	case !ok:
+		writeAPIError(w, http.StatusNotFound, "Camera does not support focus control")
+		return
+	}
+
+	from, to := state.Min, state.Max
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.To != nil {
+		to = *req.To
+	}
+
+	step := (to - from) / 20
+	if req.Step != nil {
+		step = *req.Step
+	}
+	if step < state.Step {
+		step = state.Step
+	}
+	if step < 1 {
+		step = 1
+	}
+
+	if from > to || from < state.Min || to > state.Max || (to-from)/step > focusMaxSteps {
+		writeAPIError(w, http.StatusBadRequest, "Invalid sweep range or too many steps")
+		return
+	}
+
+	settle := focusSettleDefault
+	if req.Settle != "" {
+		if settle, err = time.ParseDuration(req.Settle); err != nil || settle < 0 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid settle duration")
+			return
+		}
+	}
+
+	if autoKey != "" {
+		if err = setControls(map[string]int32{autoKey: 0}); err != nil {
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	result := focusSweepResult{Best: from}
+	var best float64 = -1
+
+	for pos := from; pos <= to; pos += step {
+		score, err := focusScore(r.Context(), pos, settle)
+		if err != nil {
+			log.WithError(err).WithField("position", pos).Error("Focus sweep failed")
+			writeAPIError(w, http.StatusInternalServerError, "Focus sweep failed")
+			return
+		}
+
+		result.Results = append(result.Results, focusSweepPoint{Position: pos, Sharpness: score})
+		if score > best {
+			best, result.Best = score, pos
+		}
+	}
+
+	target := state.Position
+	if req.Apply == nil || *req.Apply {
+		target = result.Best
+	}
+
+	if err = setControls(map[string]int32{focusAbsoluteKey: target}); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"best":    result.Best,
+		"applied": target,
+	}).Info("Focus sweep finished")
+
+	writeAPIResponse(w, http.StatusOK, result)
+}
+
+// focusScore moves the focus, waits for the lens to settle and returns
+// the sharpness of the next frame
+func focusScore(ctx context.Context, pos int32, settle time.Duration) (float64, error) {
+	if err := setControls(map[string]int32{focusAbsoluteKey: pos}); err != nil {
+		return 0, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-time.After(settle):
+	}
+
+	fctx, cancel := context.WithTimeout(ctx, snapshotGrabTimeout)
+	defer cancel()
+
+	img, err := frameBroadcaster.NextFrame(fctx)
+	if err != nil {
+		return 0, err
+	}
+	defer img.Release()
+
+	frame, err := decodeGrayFrame(img.Data, focusAnalysisWidth)
+	if err != nil {
+		return 0, err
+	}
+
+	return sharpness(frame), nil
+}
+
+// sharpness returns the variance of the Laplacian of the frame: sharp
+// images have strong edges and therefore a high variance
+func sharpness(f *grayFrame) float64 {
+	if f.Width < 3 || f.Height < 3 {
+		return 0
+	}
+
+	var sum, sumSq float64
+	var n int
+	for y := 1; y < f.Height-1; y++ {
+		for x := 1; x < f.Width-1; x++ {
+			i := y*f.Width + x
+			l := float64(4*int(f.Pix[i]) - int(f.Pix[i-1]) - int(f.Pix[i+1]) - int(f.Pix[i-f.Width]) - int(f.Pix[i+f.Width]))
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
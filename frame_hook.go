@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	frameHookMaxEvent = 1024 * 1024
+	frameHookTimeout  = 30 * time.Second
+
+	recordSourceFrameHook = "frame-hook"
+)
+
+// frameHookEvent wraps a JSON event emitted by the frame hook with the
+// frame it was emitted for
+type frameHookEvent struct {
+	Camera    string          `json:"camera"`
+	Event     json.RawMessage `json:"event"`
+	FrameSeq  uint64          `json:"frame_seq"`
+	FrameTime time.Time       `json:"frame_time"`
+}
+
+// runFrameHook passes the captured frames to the frame hook at the
+// configured interval until the context is cancelled. Frames captured
+// while the hook runs are skipped.
+func runFrameHook(ctx context.Context) {
+	sub := frameBroadcaster.SubscribeInternal("frame-hook")
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	var lastRun time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case img := <-sub.Frames():
+			now := time.Now()
+			if now.Sub(lastRun) < cfg.FrameHookInterval {
+				img.Release()
+				continue
+			}
+			lastRun = now
+
+			if err := execFrameHook(ctx, img); err != nil {
+				log.WithError(err).Error("Unable to execute frame hook")
+			}
+			img.Release()
+
+			// Skip the frames queued while the hook was running
+			for drained := false; !drained; {
+				select {
+				case f := <-sub.Frames():
+					f.Release()
+				default:
+					drained = true
+				}
+			}
+		}
+	}
+}
+
+// execFrameHook executes the frame hook through the shell with the
+// JPEG on stdin and executes every JSON line of its stdout having an
+// action field, all other lines are published as event
+func execFrameHook(ctx context.Context, img *frame) error {
+	ctx, cancel := context.WithTimeout(ctx, frameHookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", cfg.FrameHook)
+	cmd.Env = append(cmd.Environ(),
+		"CAM2MJPEG_CAMERA="+cfg.Device,
+		"CAM2MJPEG_FRAME_SEQ="+strconv.FormatUint(img.Seq, 10),
+		"CAM2MJPEG_FRAME_TIME="+img.Time.Format(time.RFC3339Nano),
+	)
+	cmd.Stdin = bytes.NewReader(img.Data)
+
+	stderr := log.WithField("component", "frame-hook").WriterLevel(log.WarnLevel)
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdout pipe")
+	}
+
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to start frame hook")
+	}
+
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(nil, frameHookMaxEvent)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if !json.Valid(line) {
+			log.WithField("line", string(line)).Warn("Frame hook emitted invalid JSON, ignoring")
+			continue
+		}
+
+		var act hookAction
+		if json.Unmarshal(line, &act) == nil && act.Action != "" {
+			logger := log.WithField("action", act.Action)
+			if err := execHookAction(ctx, recordSourceFrameHook, act, img); err != nil {
+				logger.WithError(err).Error("Unable to execute frame hook action")
+				continue
+			}
+			logger.Debug("Executed frame hook action")
+			continue
+		}
+
+		publishFrameHookEvent(frameHookEvent{
+			Camera:    cfg.Device,
+			Event:     json.RawMessage(bytes.Clone(line)),
+			FrameSeq:  img.Seq,
+			FrameTime: img.Time,
+		})
+	}
+	if err = scanner.Err(); err != nil {
+		// Keep the hook from blocking on a full pipe
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.Wrap(err, "Unable to read frame hook output")
+	}
+
+	return errors.Wrap(cmd.Wait(), "Frame hook failed")
+}
+
+// publishFrameHookEvent sends the event to the event bus, the frame hook
+// webhooks and the MQTT events topic
+func publishFrameHookEvent(evt frameHookEvent) {
+	log.WithField("event", string(evt.Event)).Debug("Frame hook event")
+	events.Publish(eventFrameHook, evt)
+
+	for _, u := range cfgValue(&cfg.FrameHookWebhook) {
+		go func(u string) {
+			if err := sendWebhook(u, evt); err != nil {
+				log.WithError(err).Error("Unable to send frame hook webhook")
+			}
+		}(u)
+	}
+
+	if mqttClient != nil {
+		body, err := json.Marshal(evt)
+		if err != nil {
+			log.WithError(err).Error("Unable to marshal frame hook event")
+			return
+		}
+		go mqttPublish("events", body, false)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Restream endpoints tailored to be used as Frigate camera inputs
+// (input preset "preset-http-mjpeg-generic"): both streams are paced
+// at a constant frame rate repeating the last frame while the camera
+// stalls so Frigate does not consider the camera offline, the detect
+// stream is downscaled to the resolution Frigate runs detection at.
+const (
+	frigateDetectPath = "/frigate/detect.mjpeg"
+	frigateRecordPath = "/frigate/record.mjpeg"
+)
+
+// applyFrigateMode overrides options conflicting with a constant
+// stream expected by Frigate
+func applyFrigateMode() {
+	if cfg.OnDemand {
+		log.Warn("Frigate restream mode keeps the capture running, ignoring --on-demand")
+		cfg.OnDemand = false
+	}
+
+	if cfg.IdleFPS > 0 {
+		log.Warn("Frigate restream mode needs a constant frame rate, ignoring --idle-fps")
+		cfg.IdleFPS = 0
+	}
+}
+
+func registerFrigateHandlers(mux *http.ServeMux) {
+	// The detect stream is shared with the low-bandwidth stream if both
+	// use the same frame rate and width
+	mux.Handle("GET "+frigateDetectPath, sharedPacedStream(cfg.FrigateDetectFPS, cfg.FrigateDetectWidth).Handler(frigateDetectPath))
+	mux.Handle("GET "+frigateRecordPath, newPacedStream(func() int { return cfgValue(&cfg.FrameRate) }, 0).Handler(frigateRecordPath))
+
+	log.WithFields(log.Fields{
+		"detect": publicURL(frigateDetectPath),
+		"record": publicURL(frigateRecordPath),
+	}).Info("Frigate restream enabled")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+)
+
+type (
+	go2rtcStream struct {
+		Consumers []interface{}    `json:"consumers"`
+		Producers []go2rtcProducer `json:"producers"`
+	}
+
+	go2rtcProducer struct {
+		URL string `json:"url"`
+	}
+)
+
+// registerGo2RTCHandlers adds the subset of the go2rtc HTTP API needed
+// by consumers expecting a go2rtc server serving the camera as a
+// single stream
+func registerGo2RTCHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api", handleGo2RTCInfo)
+	mux.HandleFunc("GET /api/streams", handleGo2RTCStreams)
+	mux.Handle("GET /api/frame.jpeg", go2rtcSource(http.HandlerFunc(handleSnapshot)))
+	mux.Handle("GET /api/stream.mjpeg", go2rtcSource(newMJPEGHandler(frameBroadcaster.Hub, "/api/stream.mjpeg", mjpegHooks)))
+}
+
+// go2rtcSource rejects requests for other streams than the one served
+func go2rtcSource(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if src := r.URL.Query().Get("src"); src != "" && src != cfg.Go2RTCStream {
+			http.Error(w, "404 stream not found", http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleGo2RTCInfo(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, http.StatusOK, map[string]string{"version": "cam2mjpeg-" + version})
+}
+
+func handleGo2RTCStreams(w http.ResponseWriter, r *http.Request) {
+	stream := go2rtcStream{
+		Consumers: []interface{}{},
+		Producers: []go2rtcProducer{{URL: "v4l2:" + cfg.Device}},
+	}
+
+	switch src := r.URL.Query().Get("src"); src {
+	case "":
+		writeAPIResponse(w, http.StatusOK, map[string]go2rtcStream{cfg.Go2RTCStream: stream})
+	case cfg.Go2RTCStream:
+		writeAPIResponse(w, http.StatusOK, stream)
+	default:
+		http.Error(w, "404 stream not found", http.StatusNotFound)
+	}
+}
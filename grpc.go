@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/camerapb"
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcMaxMessageSize allows frames of up to the maximum frame size to
+// be sent
+const grpcMaxMessageSize = 64 * 1024 * 1024
+
+type grpcCameraServer struct {
+	camerapb.UnimplementedCameraServer
+}
+
+// serveGRPC serves the gRPC API on the listener until the context is
+// cancelled
+func serveGRPC(ctx context.Context, l net.Listener) {
+	srv := grpc.NewServer(
+		grpc.MaxSendMsgSize(grpcMaxMessageSize),
+		grpc.StreamInterceptor(grpcStreamAuth),
+		grpc.UnaryInterceptor(grpcUnaryAuth),
+	)
+	camerapb.RegisterCameraServer(srv, grpcCameraServer{})
+
+	go func() {
+		<-ctx.Done()
+		// Streams return with the app context, pending calls finish
+		srv.GracefulStop()
+	}()
+
+	log.WithField("addr", cfg.GRPCListen).Info("Serving gRPC API")
+	if err := srv.Serve(l); err != nil {
+		log.WithError(err).WithField("addr", cfg.GRPCListen).Fatal("gRPC server has gone")
+	}
+}
+
+// grpcReadOnlyMethods are the calls not changing any state, all other
+// calls require an API token
+var grpcReadOnlyMethods = []string{
+	camerapb.Camera_GetSnapshot_FullMethodName,
+	camerapb.Camera_GetStatus_FullMethodName,
+	camerapb.Camera_ListControls_FullMethodName,
+	camerapb.Camera_StreamFrames_FullMethodName,
+}
+
+// grpcAuthorize requires the configured API token as bearer token in
+// the authorization metadata if one is configured, without token only
+// read-only calls are accepted
+func grpcAuthorize(ctx context.Context, method string) error {
+	apiToken := cfgValue(&cfg.APIToken)
+	if apiToken == "" {
+		if !slices.Contains(grpcReadOnlyMethods, method) {
+			return status.Error(codes.PermissionDenied, "Changes require --api-token")
+		}
+		return nil
+	}
+
+	var token string
+	if v := metadata.ValueFromIncomingContext(ctx, "authorization"); len(v) > 0 {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+		return status.Error(codes.Unauthenticated, "Invalid or missing API token")
+	}
+	return nil
+}
+
+func grpcStreamAuth(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := grpcAuthorize(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+func grpcUnaryAuth(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := grpcAuthorize(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamFrames sends the frames of a client subscriber, optionally
+// limited in frame rate and downscaled
+func (grpcCameraServer) StreamFrames(req *camerapb.StreamFramesRequest, stream grpc.ServerStreamingServer[camerapb.Frame]) error {
+	if req.Width < 0 || req.Fps < 0 {
+		return status.Error(codes.InvalidArgument, "Width and fps must not be negative")
+	}
+
+	sub := frameBroadcaster.Subscribe(uuid.Must(uuid.NewV4()).String())
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	var (
+		ctx      = stream.Context()
+		interval time.Duration
+		lastSent time.Time
+	)
+	if req.Fps > 0 {
+		interval = time.Duration(float64(time.Second) / req.Fps)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-appContext.Done():
+			return nil
+
+		case <-sub.Done():
+			return nil
+
+		case img := <-sub.Frames():
+			now := time.Now()
+			if now.Sub(lastSent) < interval {
+				img.Release()
+				continue
+			}
+			lastSent = now
+
+			// The message is serialized before Send returns
+			f, err := grpcFrame(img, int(req.Width))
+			if err == nil {
+				err = stream.Send(f)
+			}
+			if err == nil {
+				atomic.AddInt64(&sentBytes, int64(len(f.Jpeg)))
+				observeFrameLatency(ctx, "write", img.Time, time.Now())
+			}
+			img.Release()
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// GetSnapshot returns the next captured frame, optionally downscaled
+func (grpcCameraServer) GetSnapshot(ctx context.Context, req *camerapb.GetSnapshotRequest) (*camerapb.Frame, error) {
+	if req.Width < 0 {
+		return nil, status.Error(codes.InvalidArgument, "Width must not be negative")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, snapshotGrabTimeout)
+	defer cancel()
+
+	img, err := frameBroadcaster.NextFrame(ctx)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	defer img.Release()
+
+	f, err := grpcFrame(img, int(req.Width))
+	if err != nil {
+		return nil, err
+	}
+
+	// The frame data is only valid until released
+	f.Jpeg = append([]byte(nil), f.Jpeg...)
+	return f, nil
+}
+
+func (grpcCameraServer) GetStatus(context.Context, *camerapb.GetStatusRequest) (*camerapb.Status, error) {
+	return grpcStatus(), nil
+}
+
+func (grpcCameraServer) ListControls(context.Context, *camerapb.ListControlsRequest) (*camerapb.ListControlsResponse, error) {
+	controls, err := listControls()
+	if err != nil {
+		log.WithError(err).Error("Unable to list camera controls")
+		return nil, status.Error(codes.Internal, "Unable to list camera controls")
+	}
+
+	resp := &camerapb.ListControlsResponse{}
+	for _, c := range controls {
+		resp.Controls = append(resp.Controls, &camerapb.Control{
+			Default: c.Default,
+			Flags:   c.Flags,
+			Key:     c.Key,
+			Max:     c.Max,
+			Menu:    c.Menu,
+			Min:     c.Min,
+			Name:    c.Name,
+			Step:    c.Step,
+			Type:    c.Type,
+			Value:   c.Value,
+		})
+	}
+	return resp, nil
+}
+
+func (s grpcCameraServer) SetControls(ctx context.Context, req *camerapb.SetControlsRequest) (*camerapb.ListControlsResponse, error) {
+	if err := setControls(req.Values); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.ListControls(ctx, nil)
+}
+
+func (grpcCameraServer) SetPrivacy(_ context.Context, req *camerapb.SetPrivacyRequest) (*camerapb.Status, error) {
+	setPrivacy(req.Enabled)
+	return grpcStatus(), nil
+}
+
+func (grpcCameraServer) RestartCapture(context.Context, *camerapb.RestartCaptureRequest) (*camerapb.Status, error) {
+	log.Info("Capture restart requested through gRPC")
+	restartCapture()
+	return grpcStatus(), nil
+}
+
+// grpcFrame converts the frame, the JPEG references the frame data
+// unless it was downscaled
+func grpcFrame(img *frame, width int) (*camerapb.Frame, error) {
+	data := img.Data
+	if width > 0 {
+		var err error
+		if data, err = downscaleJPEG(data, width); err != nil {
+			log.WithError(err).Error("Unable to downscale gRPC frame")
+			return nil, status.Error(codes.Internal, "Unable to downscale frame")
+		}
+	}
+
+	return &camerapb.Frame{
+		CaptureTime: timestamppb.New(img.Time),
+		Jpeg:        data,
+		Motion:      motionDetection.Active(),
+		Seq:         img.Seq,
+	}, nil
+}
+
+func grpcStatus() *camerapb.Status {
+	s := currentStatus()
+
+	return &camerapb.Status{
+		Camera:         s.Camera,
+		Capturing:      s.Capturing,
+		Clients:        int32(s.Clients),
+		FfmpegRestarts: s.FFMpegRestarts,
+		Frames:         s.Frames,
+		LastFrame:      timestamppb.New(s.LastFrame),
+		Motion:         s.Motion,
+		Privacy:        s.Privacy,
+		Quality:        int32(s.Quality),
+		Recording:      s.Recording,
+		Version:        s.Version,
+	}
+}
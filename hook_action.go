@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// hookAction is an action requested by the frame hook or the script,
+// the fields used depend on the action
+type hookAction struct {
+	Action   string           `json:"action"`
+	Controls map[string]int32 `json:"controls"`
+	Duration string           `json:"duration"`
+	Label    string           `json:"label"`
+	Payload  json.RawMessage  `json:"payload"`
+	URL      string           `json:"url"`
+}
+
+// execHookAction executes the action, recordings are requested as the
+// given source and snapshots are taken from the frame if given or the
+// next captured frame otherwise
+func execHookAction(ctx context.Context, source string, act hookAction, img *frame) error {
+	switch act.Action {
+	case "record":
+		if cfg.RecordDir == "" {
+			return errors.New("Recording requires --record-dir")
+		}
+
+		var until time.Time
+		if act.Duration != "" {
+			d, err := time.ParseDuration(act.Duration)
+			if err != nil || d <= 0 {
+				return errors.New("Invalid duration")
+			}
+			until = time.Now().Add(d)
+		}
+		recordControl.Request(source, until)
+		return nil
+
+	case "record_stop":
+		recordControl.Release(source)
+		return nil
+
+	case "set_controls":
+		return setControls(act.Controls)
+
+	case "snapshot":
+		if cfg.SnapshotDir == "" {
+			return errors.New("Snapshots require --snapshot-dir")
+		}
+
+		if img == nil {
+			ctx, cancel := context.WithTimeout(ctx, snapshotGrabTimeout)
+			defer cancel()
+
+			var err error
+			if img, err = frameBroadcaster.NextFrame(ctx); err != nil {
+				return errors.Wrap(err, "Unable to grab snapshot frame")
+			}
+			defer img.Release()
+		}
+
+		_, err := saveSnapshot(img.Data, act.Label, img.Time)
+		return errors.Wrap(err, "Unable to store snapshot")
+
+	case "webhook":
+		if act.URL == "" {
+			return errors.New("Webhook requires url")
+		}
+		return sendWebhook(act.URL, act.Payload)
+
+	default:
+		return errors.Errorf("Unknown action %q", act.Action)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	lifecycleCaptureFailed    = "capture_failed"
+	lifecycleCaptureRecovered = "capture_recovered"
+	lifecycleCaptureRestart   = "capture_restart"
+	lifecycleStart            = "start"
+	lifecycleStop             = "stop"
+)
+
+type lifecycleWebhookPayload struct {
+	Camera   string    `json:"camera"`
+	Error    string    `json:"error,omitempty"`
+	Event    string    `json:"event"`
+	Failures int       `json:"failures,omitempty"`
+	Restarts int64     `json:"restarts"`
+	RetryIn  string    `json:"retry_in,omitempty"`
+	Time     time.Time `json:"time"`
+	Version  string    `json:"version"`
+}
+
+// notifyLifecycle sends the event to all lifecycle webhooks and waits
+// for them to be delivered
+func notifyLifecycle(payload lifecycleWebhookPayload) {
+	payload.Camera = cfg.Device
+	payload.Restarts = atomic.LoadInt64(&captureRestarts)
+	payload.Time = time.Now()
+	payload.Version = version
+	events.Publish(eventLifecycle, payload)
+
+	var wg sync.WaitGroup
+	for _, u := range cfgValue(&cfg.LifecycleWebhook) {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			if err := sendWebhook(u, payload); err != nil {
+				log.WithError(err).WithField("event", payload.Event).Error("Unable to send lifecycle webhook")
+			}
+		}(u)
+	}
+	wg.Wait()
+}
+
+// awaitCaptureRecovery sends the recovery event as soon as the capture
+// started after failures produces its first frame
+func awaitCaptureRecovery(ctx context.Context, start time.Time, failures int) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if lastFrameTime().After(start) {
+			log.WithField("camera", cfg.Device).Info("Capture recovered")
+			notifyLifecycle(lifecycleWebhookPayload{Event: lifecycleCaptureRecovered, Failures: failures})
+			return
+		}
+	}
+}
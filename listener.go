@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const unixSocketPrefix = "unix:"
+
+// listenNetworks maps the listen families to the network passed to
+// net.Listen: "tcp" on a wildcard address binds dual-stack if the host
+// supports IPv6, "tcp6" sets IPV6_V6ONLY
+var listenNetworks = map[string]string{
+	"auto": "tcp",
+	"dual": "tcp",
+	"v4":   "tcp4",
+	"v6":   "tcp6",
+}
+
+// listen opens a TCP listener for host:port addresses or a unix socket
+// for addresses given as unix:<path>
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixSocketPrefix)
+	if !ok {
+		return listenTCP(addr)
+	}
+
+	// Remove the socket left behind by a process not shut down cleanly
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "Unable to remove stale socket")
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("path", path).Info("Listening on unix socket")
+	return l, nil
+}
+
+func listenTCP(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid listen address")
+	}
+
+	if cfg.ListenFamily == "dual" && host != "" && host != "::" {
+		return nil, errors.Errorf("Dual-stack listening requires a wildcard host (:port or [::]:port), got %q", host)
+	}
+
+	l, err := net.Listen(listenNetworks[cfg.ListenFamily], addr)
+	if err != nil {
+		return nil, err
+	}
+
+	family := listenerFamily(l.Addr().(*net.TCPAddr))
+	if cfg.ListenFamily == "dual" && family != "dual-stack" {
+		l.Close()
+		return nil, errors.New("Dual-stack listening is not supported by the host (IPv6 disabled?)")
+	}
+
+	log.WithFields(log.Fields{
+		"addr":   l.Addr().String(),
+		"family": family,
+	}).Info("Listening on TCP")
+
+	return l, nil
+}
+
+// listenerFamily describes which address families the listener accepts
+// connections of
+func listenerFamily(addr *net.TCPAddr) string {
+	switch {
+	case addr.IP.To4() != nil:
+		return "ipv4"
+	case addr.IP.IsUnspecified() && cfg.ListenFamily != "v6":
+		// Wildcard IPv6 socket without IPV6_V6ONLY accepts mapped IPv4
+		return "dual-stack"
+	default:
+		return "ipv6"
+	}
+}
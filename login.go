@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+)
+
+// loginCookie carries the API token for the pages and the API requests
+// made by them so the token is not part of any URL
+const loginCookie = "cam2mjpeg_token"
+
+// loginPages are the pages allowed as target after login
+var loginPages = []string{"admin", "recordings"}
+
+type loginPage struct {
+	Camera  string
+	Error   string
+	Next    string
+	Version string
+}
+
+// loginRequired shows the login form instead of the page if an API
+// token is configured and the request does not carry it
+func loginRequired(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiToken := cfgValue(&cfg.APIToken)
+		if apiToken == "" || validAPIToken(requestAPIToken(r), apiToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		renderPage(w, "login.html", loginPage{Camera: cfg.Device, Next: r.URL.Path[1:], Version: version})
+	})
+}
+
+// handleLogin stores the API token in the login cookie and redirects to
+// the page the login form was shown for
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	next := r.PostFormValue("next")
+	if !slices.Contains(loginPages, next) {
+		next = loginPages[0]
+	}
+
+	token := r.PostFormValue("token")
+	if apiToken := cfgValue(&cfg.APIToken); apiToken == "" || !validAPIToken(token, apiToken) {
+		renderPage(w, "login.html", loginPage{Camera: cfg.Device, Error: "Invalid API token", Next: next, Version: version})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		Name:     loginCookie,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   r.TLS != nil,
+		Value:    token,
+	})
+	// Relative to keep working behind proxies serving below a path
+	w.Header().Set("Location", next)
+	w.WriteHeader(http.StatusSeeOther)
+}
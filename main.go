@@ -1,14 +1,20 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
+	"context"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
+	"github.com/Luzifer/cam2mjpeg/pkg/capture"
 	"github.com/gofrs/uuid"
 	log "github.com/sirupsen/logrus"
 
@@ -17,38 +23,166 @@ import (
 
 var (
 	cfg = struct {
-		Device         string `flag:"input,i" default:"/dev/video0" description:"Video device to read from"`
-		FFMpegLog      bool   `flag:"ffmpeg-log" default:"false" description:"Send ffmpeg logs to stderr"`
-		FrameRate      int    `flag:"rate,r" default:"10" description:"Frame rate to show in MJPEG"`
-		Height         int    `flag:"height,h" default:"720" description:"Height of video frames"`
-		Listen         string `flag:"listen" default:":3000" description:"Port/IP to listen on"`
-		LogLevel       string `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error, fatal)"`
-		Quality        int    `flag:"quality,q" default:"5" description:"Image quality (2..31)"`
-		VersionAndExit bool   `flag:"version" default:"false" description:"Prints current version and exits"`
-		Width          int    `flag:"width,w" default:"1280" description:"Width of video frames"`
+		AccessLog             string        `flag:"access-log" default:"none" vardefault:"access-log" env:"CAM2MJPEG_ACCESS_LOG" description:"Access log format written to stdout (none, common, combined, json)"`
+		AdaptiveBandwidth     string        `flag:"adaptive-bandwidth" default:"" vardefault:"adaptive-bandwidth" env:"CAM2MJPEG_ADAPTIVE_BANDWIDTH" description:"Output bandwidth per second (e.g. 2MiB) above which the encoder quality is stepped down (empty to disable)"`
+		AdaptiveClients       int           `flag:"adaptive-clients" default:"0" vardefault:"adaptive-clients" env:"CAM2MJPEG_ADAPTIVE_CLIENTS" description:"Number of clients above which the encoder quality is stepped down (0 to disable)"`
+		AdaptiveMaxQuality    int           `flag:"adaptive-max-quality" default:"20" vardefault:"adaptive-max-quality" env:"CAM2MJPEG_ADAPTIVE_MAX_QUALITY" description:"Worst quality (2..31) to step down to under load"`
+		AdminListen           string        `flag:"admin-listen" default:"" vardefault:"admin-listen" env:"CAM2MJPEG_ADMIN_LISTEN" description:"Port/IP or unix:<path> to listen on for admin endpoints (empty: use main listeners)"`
+		APIToken              string        `flag:"api-token" default:"" vardefault:"api-token" env:"CAM2MJPEG_API_TOKEN" description:"Token required as bearer token for /api endpoints (empty: read-only unless on --admin-listen)"`
+		AudioDevice           string        `flag:"audio-device" default:"" vardefault:"audio-device" env:"CAM2MJPEG_AUDIO_DEVICE" description:"Audio device to record alongside the video (e.g. hw:1,0, empty to record video only)"`
+		AudioFormat           string        `flag:"audio-format" default:"alsa" vardefault:"audio-format" env:"CAM2MJPEG_AUDIO_FORMAT" description:"ffmpeg input format of the audio device (alsa, pulse, ...)"`
+		BenchClients          int           `flag:"bench-clients" default:"10" description:"Number of MJPEG clients the bench command connects"`
+		BenchDuration         time.Duration `flag:"bench-duration" default:"30s" description:"Time the bench command keeps the clients connected"`
+		BenchURL              string        `flag:"bench-url" default:"" description:"MJPEG stream the bench command loads (empty to serve a test pattern in-process)"`
+		Config                string        `flag:"config,c" default:"" env:"CAM2MJPEG_CONFIG" description:"YAML or TOML (.toml) file to read options (keys are the flag names, flags take precedence) and cameras, masks, overlays and webhooks from, reloaded on SIGHUP"`
+		ClientWebhook         []string      `flag:"client-webhook" default:"" vardefault:"client-webhook" env:"CAM2MJPEG_CLIENT_WEBHOOK" description:"URL to POST client connect / disconnect events to (may be repeated)"`
+		ClientWriteTimeout    time.Duration `flag:"client-write-timeout" default:"10s" vardefault:"client-write-timeout" env:"CAM2MJPEG_CLIENT_WRITE_TIMEOUT" description:"Time a stream client may not accept data before it is disconnected (0 to disable)"`
+		ControlState          string        `flag:"control-state" default:"" vardefault:"control-state" env:"CAM2MJPEG_CONTROL_STATE" description:"File to periodically store camera controls in, restored at startup and when the device re-enumerates"`
+		ControlStateInterval  time.Duration `flag:"control-state-interval" default:"1m" vardefault:"control-state-interval" env:"CAM2MJPEG_CONTROL_STATE_INTERVAL" description:"Interval to store the camera controls at"`
+		DetectorCommand       string        `flag:"detector-command" default:"" vardefault:"detector-command" env:"CAM2MJPEG_DETECTOR_COMMAND" description:"Shell command to detect objects on motion (JPEG on stdin, DeepStack style JSON on stdout)"`
+		DetectorLabels        []string      `flag:"detector-labels" default:"" vardefault:"detector-labels" env:"CAM2MJPEG_DETECTOR_LABELS" description:"Object labels to report motion for (e.g. person,car, empty for all)"`
+		DetectorMinConfidence float64       `flag:"detector-min-confidence" default:"0.5" vardefault:"detector-min-confidence" env:"CAM2MJPEG_DETECTOR_MIN_CONFIDENCE" description:"Minimum confidence of detected objects (0-1)"`
+		DetectorURL           string        `flag:"detector-url" default:"" vardefault:"detector-url" env:"CAM2MJPEG_DETECTOR_URL" description:"DeepStack / CodeProject.AI style endpoint to detect objects on motion (e.g. http://localhost:5000/v1/vision/detection)"`
+		Device                string        `flag:"input,i" default:"/dev/video0" vardefault:"input" env:"CAM2MJPEG_DEVICE" description:"Video device to read from"`
+		DashboardCamera       []string      `flag:"dashboard-camera" default:"" vardefault:"dashboard-camera" env:"CAM2MJPEG_DASHBOARD_CAMERA" description:"Additional camera to show on /dashboard as title=stream-url (may be repeated)"`
+		DryRun                bool          `flag:"dry-run" default:"false" description:"Validate the device and print the ffmpeg commands instead of starting the server"`
+		EnableMetrics         bool          `flag:"enable-metrics" default:"false" vardefault:"enable-metrics" env:"CAM2MJPEG_ENABLE_METRICS" description:"Expose Prometheus metrics on /metrics of the admin listener"`
+		EnablePprof           bool          `flag:"enable-pprof" default:"false" vardefault:"enable-pprof" env:"CAM2MJPEG_ENABLE_PPROF" description:"Expose pprof endpoints on the admin listener"`
+		ExposureInterval      time.Duration `flag:"exposure-interval" default:"10s" vardefault:"exposure-interval" env:"CAM2MJPEG_EXPOSURE_INTERVAL" description:"Interval to compute exposure statistics at while capturing (0 to disable)"`
+		FFMpegCgroup          string        `flag:"ffmpeg-cgroup" default:"" vardefault:"ffmpeg-cgroup" env:"CAM2MJPEG_FFMPEG_CGROUP" description:"cgroup v2 directory to spawn ffmpeg in to apply its CPU / memory limits (e.g. /sys/fs/cgroup/cam2mjpeg, Linux only)"`
+		FFMpegCPUs            string        `flag:"ffmpeg-cpus" default:"" vardefault:"ffmpeg-cpus" env:"CAM2MJPEG_FFMPEG_CPUS" description:"CPUs to pin ffmpeg to as taskset list (e.g. 2-3, requires taskset)"`
+		FFMpegLog             bool          `flag:"ffmpeg-log" default:"false" vardefault:"ffmpeg-log" env:"CAM2MJPEG_FFMPEG_LOG" description:"Log all ffmpeg output at info level (warnings and errors are always logged)"`
+		FFMpegNice            int           `flag:"ffmpeg-nice" default:"0" vardefault:"ffmpeg-nice" env:"CAM2MJPEG_FFMPEG_NICE" description:"Nice level to run ffmpeg at (-20..19, requires nice)"`
+		FFMpegThreads         int           `flag:"ffmpeg-threads" default:"0" vardefault:"ffmpeg-threads" env:"CAM2MJPEG_FFMPEG_THREADS" description:"Threads ffmpeg uses to encode the capture (0 to let ffmpeg decide)"`
+		FrameHook             string        `flag:"frame-hook" default:"" vardefault:"frame-hook" env:"CAM2MJPEG_FRAME_HOOK" description:"Shell command to pass frames to (JPEG on stdin, one JSON event or action per line on stdout)"`
+		FrameHookInterval     time.Duration `flag:"frame-hook-interval" default:"0" vardefault:"frame-hook-interval" env:"CAM2MJPEG_FRAME_HOOK_INTERVAL" description:"Minimum interval between frames passed to the frame hook (0 for every frame, frames are skipped while the hook runs)"`
+		FrameHookWebhook      []string      `flag:"frame-hook-webhook" default:"" vardefault:"frame-hook-webhook" env:"CAM2MJPEG_FRAME_HOOK_WEBHOOK" description:"URL to POST frame hook events to (may be repeated)"`
+		FrameRate             int           `flag:"rate,r" default:"10" vardefault:"rate" env:"CAM2MJPEG_FRAME_RATE" description:"Frame rate to show in MJPEG"`
+		Frigate               bool          `flag:"frigate" default:"false" vardefault:"frigate" env:"CAM2MJPEG_FRIGATE" description:"Serve constant rate record and detect streams for use as Frigate camera inputs"`
+		FrigateDetectFPS      int           `flag:"frigate-detect-fps" default:"5" vardefault:"frigate-detect-fps" env:"CAM2MJPEG_FRIGATE_DETECT_FPS" description:"Frame rate of the Frigate detect stream"`
+		FrigateDetectWidth    int           `flag:"frigate-detect-width" default:"640" vardefault:"frigate-detect-width" env:"CAM2MJPEG_FRIGATE_DETECT_WIDTH" description:"Width to downscale the Frigate detect stream to"`
+		Go2RTCStream          string        `flag:"go2rtc-stream" default:"" vardefault:"go2rtc-stream" env:"CAM2MJPEG_GO2RTC_STREAM" description:"Serve a go2rtc compatible API exposing the camera as stream with this name (empty to disable)"`
+		GRPCListen            string        `flag:"grpc-listen" default:"" vardefault:"grpc-listen" env:"CAM2MJPEG_GRPC_LISTEN" description:"Port/IP or unix:<path> to serve the gRPC API on (empty to disable)"`
+		Height                int           `flag:"height,h" default:"720" vardefault:"height" env:"CAM2MJPEG_HEIGHT" description:"Height of video frames"`
+		IdleFPS               float64       `flag:"idle-fps" default:"0" vardefault:"idle-fps" env:"CAM2MJPEG_IDLE_FPS" description:"Frame rate to stream and record at while no motion is detected (requires --motion, 0 to disable)"`
+		IdleTimeout           time.Duration `flag:"idle-timeout" default:"30s" vardefault:"idle-timeout" env:"CAM2MJPEG_IDLE_TIMEOUT" description:"Time without viewers after which ffmpeg is stopped in on-demand mode"`
+		LatencyHeaders        bool          `flag:"latency-headers" default:"false" vardefault:"latency-headers" env:"CAM2MJPEG_LATENCY_HEADERS" description:"Add sequence, capture time and latency headers to every MJPEG part"`
+		Listen                []string      `flag:"listen" default:":3000" vardefault:"listen" env:"CAM2MJPEG_LISTEN" description:"Port/IP or unix:<path> to listen on (may be repeated)"`
+		ListenFamily          string        `flag:"listen-family" default:"auto" vardefault:"listen-family" env:"CAM2MJPEG_LISTEN_FAMILY" description:"Address families to listen on for TCP addresses (auto, dual, v4, v6)"`
+		LifecycleWebhook      []string      `flag:"lifecycle-webhook" default:"" vardefault:"lifecycle-webhook" env:"CAM2MJPEG_LIFECYCLE_WEBHOOK" description:"URL to POST daemon start / stop and capture failure / recovery events to (may be repeated)"`
+		LogFormat             string        `flag:"log-format" default:"text" vardefault:"log-format" env:"CAM2MJPEG_LOG_FORMAT" description:"Log format (text, json)"`
+		LogLevel              string        `flag:"log-level" default:"info" vardefault:"log-level" env:"CAM2MJPEG_LOG_LEVEL" description:"Log level (debug, info, warn, error, fatal)"`
+		LowStreamFPS          int           `flag:"low-stream-fps" default:"2" vardefault:"low-stream-fps" env:"CAM2MJPEG_LOW_STREAM_FPS" description:"Frame rate of the low-bandwidth stream on /mjpeg/low"`
+		LowStreamWidth        int           `flag:"low-stream-width" default:"480" vardefault:"low-stream-width" env:"CAM2MJPEG_LOW_STREAM_WIDTH" description:"Width to downscale the low-bandwidth stream and mobile view stills to"`
+		MaxDisk               string        `flag:"max-disk" default:"0" vardefault:"max-disk" env:"CAM2MJPEG_MAX_DISK" description:"Maximum size of recordings, snapshots and timelapse frames before pruning the oldest (0 to disable)"`
+		MaxFrameMemory        string        `flag:"max-frame-memory" default:"0" vardefault:"max-frame-memory" env:"CAM2MJPEG_MAX_FRAME_MEMORY" description:"Maximum memory of frames held in client queues and the replay buffer before dropping the oldest (0 to disable)"`
+		MaxFrameSize          string        `flag:"max-frame-size" default:"32MiB" vardefault:"max-frame-size" env:"CAM2MJPEG_MAX_FRAME_SIZE" description:"Maximum size of a single frame, the read buffer grows up to this size"`
+		Motion                bool          `flag:"motion" default:"false" vardefault:"motion" env:"CAM2MJPEG_MOTION" description:"Enable motion detection"`
+		MotionClipDir         string        `flag:"motion-clip-dir" default:"" vardefault:"motion-clip-dir" env:"CAM2MJPEG_MOTION_CLIP_DIR" description:"Directory to write a clip per motion event to (empty to disable)"`
+		MotionCooldown        time.Duration `flag:"motion-cooldown" default:"10s" vardefault:"motion-cooldown" env:"CAM2MJPEG_MOTION_COOLDOWN" description:"Time without motion before the motion is considered stopped"`
+		MotionFPS             float64       `flag:"motion-fps" default:"2" vardefault:"motion-fps" env:"CAM2MJPEG_MOTION_FPS" description:"Frames per second to analyze for motion"`
+		MotionMinArea         float64       `flag:"motion-min-area" default:"0.01" vardefault:"motion-min-area" env:"CAM2MJPEG_MOTION_MIN_AREA" description:"Fraction of the image which needs to change to detect motion (0-1)"`
+		MotionPostroll        time.Duration `flag:"motion-postroll" default:"10s" vardefault:"motion-postroll" env:"CAM2MJPEG_MOTION_POSTROLL" description:"Time to continue motion clips after the motion stopped"`
+		MotionPreroll         time.Duration `flag:"motion-preroll" default:"5s" vardefault:"motion-preroll" env:"CAM2MJPEG_MOTION_PREROLL" description:"Time to include in motion clips before the motion started"`
+		MotionThreshold       int           `flag:"motion-threshold" default:"25" vardefault:"motion-threshold" env:"CAM2MJPEG_MOTION_THRESHOLD" description:"Minimum luminance change of a pixel to count as changed (1-255)"`
+		MotionWebhook         []string      `flag:"motion-webhook" default:"" vardefault:"motion-webhook" env:"CAM2MJPEG_MOTION_WEBHOOK" description:"URL to POST motion start / stop events to (may be repeated)"`
+		MotionWebhookAttach   bool          `flag:"motion-webhook-attach" default:"false" vardefault:"motion-webhook-attach" env:"CAM2MJPEG_MOTION_WEBHOOK_ATTACH" description:"Send motion webhooks as multipart form with the JPEG attached"`
+		MotionZones           string        `flag:"motion-zones" default:"" vardefault:"motion-zones" env:"CAM2MJPEG_MOTION_ZONES" description:"YAML file containing motion zones and ignore masks (updated through the API)"`
+		MQTTBroker            string        `flag:"mqtt-broker" default:"" vardefault:"mqtt-broker" env:"CAM2MJPEG_MQTT_BROKER" description:"MQTT broker to publish motion and availability to (e.g. tcp://localhost:1883, empty to disable)"`
+		MQTTClientID          string        `flag:"mqtt-client-id" default:"" vardefault:"mqtt-client-id" env:"CAM2MJPEG_MQTT_CLIENT_ID" description:"Client ID to use for MQTT (default: cam2mjpeg-<pid>)"`
+		MQTTDiscoveryPrefix   string        `flag:"mqtt-discovery-prefix" default:"" vardefault:"mqtt-discovery-prefix" env:"CAM2MJPEG_MQTT_DISCOVERY_PREFIX" description:"Home Assistant MQTT discovery prefix to announce the camera at (e.g. homeassistant, empty to disable)"`
+		MQTTPassword          string        `flag:"mqtt-password" default:"" vardefault:"mqtt-password" env:"CAM2MJPEG_MQTT_PASSWORD" description:"Password for the MQTT broker"`
+		MQTTSnapshotInterval  time.Duration `flag:"mqtt-snapshot-interval" default:"0" vardefault:"mqtt-snapshot-interval" env:"CAM2MJPEG_MQTT_SNAPSHOT_INTERVAL" description:"Interval to publish the latest frame to the MQTT snapshot topic at (0 to disable)"`
+		MQTTSnapshotWidth     int           `flag:"mqtt-snapshot-width" default:"0" vardefault:"mqtt-snapshot-width" env:"CAM2MJPEG_MQTT_SNAPSHOT_WIDTH" description:"Width to downscale MQTT snapshots to (0 to publish full size)"`
+		MQTTTopicPrefix       string        `flag:"mqtt-topic-prefix" default:"" vardefault:"mqtt-topic-prefix" env:"CAM2MJPEG_MQTT_TOPIC_PREFIX" description:"Prefix for all MQTT topics (default: cam2mjpeg/<hostname>)"`
+		MQTTUser              string        `flag:"mqtt-user" default:"" vardefault:"mqtt-user" env:"CAM2MJPEG_MQTT_USER" description:"Username for the MQTT broker"`
+		NDIName               string        `flag:"ndi-name" default:"" vardefault:"ndi-name" env:"CAM2MJPEG_NDI_NAME" description:"Name to emit the camera as NDI source on the LAN with (requires ffmpeg with libndi_newtek, empty to disable)"`
+		ONVIF                 bool          `flag:"onvif" default:"false" vardefault:"onvif" env:"CAM2MJPEG_ONVIF" description:"Serve a minimal ONVIF device and media service and answer ONVIF discovery probes"`
+		OnDemand              bool          `flag:"on-demand" default:"false" vardefault:"on-demand" env:"CAM2MJPEG_ON_DEMAND" description:"Start ffmpeg only while viewers are connected or snapshots are taken (motion detection, recording and hooks only get frames meanwhile)"`
+		OTLPEndpoint          string        `flag:"otlp-endpoint" default:"" vardefault:"otlp-endpoint" env:"CAM2MJPEG_OTLP_ENDPOINT" description:"OTLP/HTTP collector to export traces and metrics to (i.e. http://localhost:4318, empty to disable)"`
+		OTLPSampleRatio       float64       `flag:"otlp-sample-ratio" default:"0.1" vardefault:"otlp-sample-ratio" env:"CAM2MJPEG_OTLP_SAMPLE_RATIO" description:"Ratio of traces to sample when exporting to OTLP"`
+		Output                string        `flag:"output,o" default:"-" description:"File to write the frame of the snapshot command to (- for stdout)"`
+		PowerLine             int           `flag:"powerline" default:"0" vardefault:"powerline" env:"CAM2MJPEG_POWERLINE" description:"Power line frequency to set on the camera against flicker (50, 60, 0 to leave unchanged)"`
+		Preset                string        `flag:"preset" default:"" vardefault:"preset" env:"CAM2MJPEG_PRESET" description:"Control preset to apply at startup (requires --preset-dir)"`
+		PresetDir             string        `flag:"preset-dir" default:"" vardefault:"preset-dir" env:"CAM2MJPEG_PRESET_DIR" description:"Directory to store named control presets in (empty to disable presets)"`
+		PrivacyImage          string        `flag:"privacy-image" default:"" vardefault:"privacy-image" env:"CAM2MJPEG_PRIVACY_IMAGE" description:"JPEG to send instead of frames in privacy mode (default: generated dark image)"`
+		ProfileDayAbove       float64       `flag:"profile-day-above" default:"80" vardefault:"profile-day-above" env:"CAM2MJPEG_PROFILE_DAY_ABOVE" description:"Mean luminance to switch to the day profile above (luminance mode)"`
+		ProfileDayStart       string        `flag:"profile-day-start" default:"07:00" vardefault:"profile-day-start" env:"CAM2MJPEG_PROFILE_DAY_START" description:"Time to switch to the day profile at (time mode)"`
+		ProfileMode           string        `flag:"profile-mode" default:"time" vardefault:"profile-mode" env:"CAM2MJPEG_PROFILE_MODE" description:"How to switch between day and night profile (time, luminance, manual)"`
+		ProfileNightBelow     float64       `flag:"profile-night-below" default:"40" vardefault:"profile-night-below" env:"CAM2MJPEG_PROFILE_NIGHT_BELOW" description:"Mean luminance to switch to the night profile below (luminance mode)"`
+		ProfileNightStart     string        `flag:"profile-night-start" default:"19:00" vardefault:"profile-night-start" env:"CAM2MJPEG_PROFILE_NIGHT_START" description:"Time to switch to the night profile at (time mode)"`
+		Profiles              string        `flag:"profiles" default:"" vardefault:"profiles" env:"CAM2MJPEG_PROFILES" description:"YAML file with control profiles (e.g. day / night) mapping control keys to values"`
+		PublicURL             string        `flag:"public-url" default:"" vardefault:"public-url" env:"CAM2MJPEG_PUBLIC_URL" description:"Base URL the server is reachable at, used for links in notifications"`
+		PushBitrate           string        `flag:"push-bitrate" default:"2M" vardefault:"push-bitrate" env:"CAM2MJPEG_PUSH_BITRATE" description:"Video bitrate of the RTMP / SRT push"`
+		PushEncoder           string        `flag:"push-encoder" default:"auto" vardefault:"push-encoder" env:"CAM2MJPEG_PUSH_ENCODER" description:"H.264 encoder for RTMP / SRT pushes and restreaming (auto to use the first usable hardware encoder, e.g. h264_v4l2m2m, libx264)"`
+		PushRTMP              string        `flag:"push-rtmp" default:"" vardefault:"push-rtmp" env:"CAM2MJPEG_PUSH_RTMP" description:"RTMP ingest to push the camera to as H.264 (e.g. rtmp://server/app/key, empty to disable)"`
+		PushSRT               string        `flag:"push-srt" default:"" vardefault:"push-srt" env:"CAM2MJPEG_PUSH_SRT" description:"SRT URL to push the camera to as H.264 in MPEG-TS (e.g. srt://collector:9000, srt://:9000?mode=listener to wait for a caller, empty to disable)"`
+		PushSRTLatency        time.Duration `flag:"push-srt-latency" default:"200ms" vardefault:"push-srt-latency" env:"CAM2MJPEG_PUSH_SRT_LATENCY" description:"SRT latency bounding retransmissions unless given in the URL"`
+		Quality               int           `flag:"quality,q" default:"5" vardefault:"quality" env:"CAM2MJPEG_QUALITY" description:"Image quality (2..31)"`
+		RecordContainer       string        `flag:"record-container" default:"mkv" vardefault:"record-container" env:"CAM2MJPEG_RECORD_CONTAINER" description:"Container format for recording segments (mkv, mp4, avi)"`
+		RecordContinuous      bool          `flag:"record-continuous" default:"true" vardefault:"record-continuous" env:"CAM2MJPEG_RECORD_CONTINUOUS" description:"Record continuously (disable to record only on schedule or API request)"`
+		RecordDir             string        `flag:"record-dir" default:"" vardefault:"record-dir" env:"CAM2MJPEG_RECORD_DIR" description:"Directory to continuously record segments to (empty to disable recording)"`
+		RecordSchedule        []string      `flag:"record-schedule" default:"" vardefault:"record-schedule" env:"CAM2MJPEG_RECORD_SCHEDULE" description:"Time windows to record in (e.g. 'mon-fri 08:00-18:00', can be repeated)"`
+		RecordSegment         time.Duration `flag:"record-segment" default:"10m" vardefault:"record-segment" env:"CAM2MJPEG_RECORD_SEGMENT" description:"Length of a single recording segment"`
+		ReplayBuffer          time.Duration `flag:"replay-buffer" default:"0" vardefault:"replay-buffer" env:"CAM2MJPEG_REPLAY_BUFFER" description:"Keep frames of this duration in memory for the /replay endpoint (0 to disable)"`
+		Retention             string        `flag:"retention" default:"0" vardefault:"retention" env:"CAM2MJPEG_RETENTION" description:"Remove recordings, snapshots and timelapse frames older than this (e.g. 12h, 7d, 0 to disable)"`
+		RestartBackoffMax     time.Duration `flag:"restart-backoff-max" default:"1m" vardefault:"restart-backoff-max" env:"CAM2MJPEG_RESTART_BACKOFF_MAX" description:"Maximum time to wait before restarting a failed ffmpeg"`
+		RestartBackoffMin     time.Duration `flag:"restart-backoff-min" default:"1s" vardefault:"restart-backoff-min" env:"CAM2MJPEG_RESTART_BACKOFF_MIN" description:"Initial time to wait before restarting a failed ffmpeg"`
+		Restream              string        `flag:"restream" default:"" vardefault:"restream" env:"CAM2MJPEG_RESTREAM" description:"Restream to a live streaming service given as service:streamkey (youtube, twitch)"`
+		SceneDir              string        `flag:"scene-dir" default:"" vardefault:"scene-dir" env:"CAM2MJPEG_SCENE_DIR" description:"Directory to store a frame in whenever the scene changed (empty to disable)"`
+		SceneInterval         time.Duration `flag:"scene-interval" default:"10s" vardefault:"scene-interval" env:"CAM2MJPEG_SCENE_INTERVAL" description:"Interval to compare the scene at"`
+		SceneThreshold        float64       `flag:"scene-threshold" default:"0.2" vardefault:"scene-threshold" env:"CAM2MJPEG_SCENE_THRESHOLD" description:"Fraction of the image which needs to change since the last stored frame (0-1)"`
+		Script                string        `flag:"script" default:"" vardefault:"script" env:"CAM2MJPEG_SCRIPT" description:"Starlark script whose on_event function receives the events and may execute actions"`
+		ScriptTick            time.Duration `flag:"script-tick" default:"1m" vardefault:"script-tick" env:"CAM2MJPEG_SCRIPT_TICK" description:"Interval to send tick events to the script at (0 to disable)"`
+		SkipDuplicateFrames   bool          `flag:"skip-duplicate-frames" default:"false" vardefault:"skip-duplicate-frames" env:"CAM2MJPEG_SKIP_DUPLICATE_FRAMES" description:"Do not broadcast frames identical to the previous one (static scenes of passthrough cameras)"`
+		SkipPreflight         bool          `flag:"skip-preflight" default:"false" vardefault:"skip-preflight" env:"CAM2MJPEG_SKIP_PREFLIGHT" description:"Do not check ffmpeg and the device before spawning ffmpeg"`
+		SnapshotDir           string        `flag:"snapshot-dir" default:"" vardefault:"snapshot-dir" env:"CAM2MJPEG_SNAPSHOT_DIR" description:"Directory to store snapshots requested through the API in (empty to disable)"`
+		SnapshotFilename      string        `flag:"snapshot-filename" default:"{{ .Time.Format \"2006-01-02_15-04-05\" }}{{ with .Label }}_{{ . }}{{ end }}.jpg" vardefault:"snapshot-filename" env:"CAM2MJPEG_SNAPSHOT_FILENAME" description:"Template for snapshot filenames (Camera, Hostname, Label, Time)"`
+		SSDP                  bool          `flag:"ssdp" default:"false" vardefault:"ssdp" env:"CAM2MJPEG_SSDP" description:"Announce the camera via SSDP / UPnP"`
+		TelegramChatID        string        `flag:"telegram-chat-id" default:"" vardefault:"telegram-chat-id" env:"CAM2MJPEG_TELEGRAM_CHAT_ID" description:"Telegram chat to send snapshots to"`
+		TelegramMotion        bool          `flag:"telegram-motion" default:"true" vardefault:"telegram-motion" env:"CAM2MJPEG_TELEGRAM_MOTION" description:"Send a Telegram photo when motion starts (requires --motion)"`
+		TelegramToken         string        `flag:"telegram-token" default:"" vardefault:"telegram-token" env:"CAM2MJPEG_TELEGRAM_TOKEN" description:"Telegram bot token to send snapshots with (empty to disable)"`
+		TimelapseDir          string        `flag:"timelapse-dir" default:"" vardefault:"timelapse-dir" env:"CAM2MJPEG_TIMELAPSE_DIR" description:"Directory to store timelapse frames in (empty to disable timelapse)"`
+		TimelapseInterval     time.Duration `flag:"timelapse-interval" default:"1m" vardefault:"timelapse-interval" env:"CAM2MJPEG_TIMELAPSE_INTERVAL" description:"Interval to store timelapse frames at"`
+		UIDir                 string        `flag:"ui-dir" default:"" vardefault:"ui-dir" env:"CAM2MJPEG_UI_DIR" description:"Directory with templates overriding the embedded UI pages and assets served below /ui/"`
+		UploadPrefix          string        `flag:"upload-prefix" default:"{{ .Hostname }}/{{ .Kind }}/{{ .Time.Format \"2006-01-02\" }}" vardefault:"upload-prefix" env:"CAM2MJPEG_UPLOAD_PREFIX" description:"Template for the remote directory of uploaded files (Camera, Filename, Hostname, Kind, Time)"`
+		UploadRetries         int           `flag:"upload-retries" default:"5" vardefault:"upload-retries" env:"CAM2MJPEG_UPLOAD_RETRIES" description:"How often to retry failed uploads"`
+		UploadURL             string        `flag:"upload-url" default:"" vardefault:"upload-url" env:"CAM2MJPEG_UPLOAD_URL" description:"Target to upload recordings and snapshots to (s3://, sftp://, webdav:// or webdavs:// URL, empty to disable)"`
+		VersionAndExit        bool          `flag:"version" default:"false" description:"Prints current version and exits"`
+		WatchdogAction        string        `flag:"watchdog-action" default:"log" vardefault:"watchdog-action" env:"CAM2MJPEG_WATCHDOG_ACTION" description:"Action when watchdog triggers (log, webhook, restart, exit)"`
+		WatchdogTimeout       time.Duration `flag:"watchdog-timeout" default:"0" vardefault:"watchdog-timeout" env:"CAM2MJPEG_WATCHDOG_TIMEOUT" description:"Trigger watchdog when no frame was produced for this duration (0 to disable)"`
+		WatchdogWebhook       string        `flag:"watchdog-webhook" default:"" vardefault:"watchdog-webhook" env:"CAM2MJPEG_WATCHDOG_WEBHOOK" description:"URL to POST to when watchdog action is 'webhook'"`
+		Width                 int           `flag:"width,w" default:"1280" vardefault:"width" env:"CAM2MJPEG_WIDTH" description:"Width of video frames"`
 	}{}
 
-	requester     = map[string]chan []byte{}
-	requesterLock = new(sync.RWMutex)
+	// appContext is cancelled when the process is asked to shut down
+	appContext = context.Background()
 
 	version = "dev"
 )
 
-var (
-	beginOfJPEG = []byte{0xff, 0xd8}
-	endOfJPEG   = []byte{0xff, 0xd9}
-)
-
-const maxBacklog = 5
+const shutdownTimeout = 10 * time.Second
 
 func init() {
+	extractCommand()
+
+	if err := rconfig.Parse(&cfg); err != nil {
+		log.Fatalf("Unable to parse commandline options: %s", err)
+	}
+
+	// Parse again to apply the defaults from the config file given
+	sections, err := loadConfigFile(cfg.Config)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load config file")
+	}
+	fileSections = sections
+
 	if err := rconfig.ParseAndValidate(&cfg); err != nil {
 		log.Fatalf("Unable to parse commandline options: %s", err)
 	}
 
 	if cfg.VersionAndExit {
-		fmt.Printf("cam2mjpeg %s\n", version)
-		os.Exit(0)
+		command = "version"
 	}
 
 	if l, err := log.ParseLevel(cfg.LogLevel); err != nil {
@@ -56,132 +190,564 @@ func init() {
 	} else {
 		log.SetLevel(l)
 	}
+
+	if err := validateCaptureOptions(cfg.FrameRate, cfg.Width, cfg.Height, cfg.Quality); err != nil {
+		log.WithError(err).Fatal("Invalid capture options")
+	}
+
+	if _, ok := listenNetworks[cfg.ListenFamily]; !ok {
+		log.WithField("family", cfg.ListenFamily).Fatal("Unknown listen family")
+	}
+
+	if !slices.ContainsFunc(cfg.Listen, func(addr string) bool { return addr != "" }) {
+		log.Fatal("At least one listen address is required")
+	}
+
+	for _, addr := range append([]string{cfg.AdminListen, cfg.GRPCListen}, cfg.Listen...) {
+		if addr == "" {
+			continue
+		}
+		if err := validateListenAddress(addr); err != nil {
+			log.WithError(err).Fatal("Invalid listen address")
+		}
+	}
+
+	if cfg.PushRTMP != "" {
+		if err := validatePushURL(cfg.PushRTMP, "rtmp", "rtmps"); err != nil {
+			log.WithError(err).Fatal("Invalid RTMP push target")
+		}
+	}
+
+	if cfg.PushSRT != "" {
+		if err := validatePushURL(cfg.PushSRT, "srt"); err != nil {
+			log.WithError(err).Fatal("Invalid SRT push target")
+		}
+	}
+
+	if cfg.Restream != "" {
+		if _, _, err := parseRestream(cfg.Restream); err != nil {
+			log.WithError(err).Fatal("Invalid restream target")
+		}
+	}
+
+	switch cfg.AccessLog {
+	case "none", "common", "combined", "json":
+	default:
+		log.WithField("format", cfg.AccessLog).Fatal("Unknown access log format")
+	}
+
+	if s, err := parseByteSize(cfg.MaxFrameSize); err != nil || s < capture.InitialBufferSize {
+		log.WithField("size", cfg.MaxFrameSize).Fatal("Maximum frame size must be a valid size of at least 1MiB")
+	} else {
+		maxFrameSize = int(s)
+	}
+
+	if cfg.AdaptiveBandwidth != "" {
+		if s, err := parseByteSize(cfg.AdaptiveBandwidth); err != nil || s <= 0 {
+			log.WithField("bandwidth", cfg.AdaptiveBandwidth).Fatal("Adaptive quality bandwidth must be a valid size")
+		} else {
+			adaptiveBandwidth = s
+		}
+	}
+
+	if err := validateFFmpegLimits(); err != nil {
+		log.WithError(err).Fatal("Invalid ffmpeg limits")
+	}
+
+	if cfg.AdaptiveMaxQuality < 2 || cfg.AdaptiveMaxQuality > 31 {
+		log.WithField("quality", cfg.AdaptiveMaxQuality).Fatal("Adaptive quality maximum must be within 2-31")
+	}
+
+	if s, err := parseByteSize(cfg.MaxFrameMemory); err != nil {
+		log.WithField("size", cfg.MaxFrameMemory).Fatal("Maximum frame memory must be a valid size")
+	} else {
+		maxFrameMemory = s
+	}
+
+	if s, err := parseByteSize(cfg.MaxDisk); err != nil {
+		log.WithField("size", cfg.MaxDisk).Fatal("Maximum disk usage must be a valid size")
+	} else {
+		maxDiskUsage = s
+	}
+
+	if d, err := parseRetention(cfg.Retention); err != nil {
+		log.WithField("retention", cfg.Retention).Fatal("Retention must be a valid duration")
+	} else {
+		retentionAge = d
+	}
+
+	if _, ok := recordingContainers[cfg.RecordContainer]; !ok {
+		log.WithField("container", cfg.RecordContainer).Fatal("Unknown recording container")
+	}
+
+	// The flag is split on commas, rejoin weekday lists ("sat,sun 10:00-14:00")
+	var schedule []string
+	for _, s := range cfg.RecordSchedule {
+		if s == "" {
+			continue
+		}
+		if n := len(schedule); n > 0 && !strings.Contains(schedule[n-1], ":") {
+			schedule[n-1] += "," + s
+			continue
+		}
+		schedule = append(schedule, s)
+	}
+
+	for _, s := range schedule {
+		w, err := parseScheduleWindow(s)
+		if err != nil {
+			log.WithError(err).Fatal("Unable to parse recording schedule")
+		}
+		recordSchedule = append(recordSchedule, w)
+	}
+
+	if cfg.RecordSegment <= 0 {
+		log.Fatal("Recording segment length must be positive")
+	}
+
+	if cfg.SceneInterval <= 0 || cfg.SceneThreshold <= 0 || cfg.SceneThreshold > 1 {
+		log.Fatal("Scene archive needs a positive interval and a threshold of 0-1")
+	}
+
+	if cfg.TimelapseInterval <= 0 {
+		log.Fatal("Timelapse interval must be positive")
+	}
+
+	if cfg.RestartBackoffMin <= 0 || cfg.RestartBackoffMax < cfg.RestartBackoffMin {
+		log.Fatal("Restart backoff must be positive and maximum must not be below minimum")
+	}
+
+	if t, err := template.New("snapshot").Parse(cfg.SnapshotFilename); err != nil {
+		log.WithError(err).Fatal("Unable to parse snapshot filename template")
+	} else {
+		snapshotFilename = t
+	}
+
+	if cfg.MotionFPS <= 0 || cfg.MotionThreshold < 1 || cfg.MotionThreshold > 255 || cfg.MotionMinArea <= 0 || cfg.MotionMinArea > 1 {
+		log.Fatal("Motion detection needs positive fps, a threshold of 1-255 and a minimum area of 0-1")
+	}
+
+	if cfg.LowStreamFPS < 1 || cfg.LowStreamWidth < 1 {
+		log.Fatal("Low-bandwidth stream needs a positive frame rate and width")
+	}
+
+	if cfg.Frigate {
+		if cfg.FrigateDetectFPS < 1 || cfg.FrigateDetectWidth < 1 {
+			log.Fatal("Frigate detect stream needs a positive frame rate and width")
+		}
+		applyFrigateMode()
+	}
+
+	if cfg.IdleFPS < 0 || (cfg.IdleFPS > 0 && !cfg.Motion) {
+		log.Fatal("Idle frame rate must not be negative and requires motion detection")
+	}
+
+	if err := loadMotionZones(); err != nil {
+		log.WithError(err).Fatal("Unable to load motion zones")
+	}
+
+	if cfg.Profiles != "" {
+		if err := loadProfiles(); err != nil {
+			log.WithError(err).Fatal("Unable to load control profiles")
+		}
+
+		if cfg.ProfileMode == "luminance" && cfg.ExposureInterval <= 0 {
+			log.Fatal("Profile mode 'luminance' requires exposure statistics")
+		}
+	}
+
+	if cfg.ControlState != "" && cfg.ControlStateInterval <= 0 {
+		log.Fatal("Control state interval must be positive")
+	}
+
+	if cfg.PowerLine != 0 && cfg.PowerLine != 50 && cfg.PowerLine != 60 {
+		log.Fatal("Power line frequency must be 50 or 60")
+	}
+
+	if cfg.Preset != "" && cfg.PresetDir == "" {
+		log.Fatal("Startup preset requires a preset directory")
+	}
+
+	if cfg.UIDir != "" {
+		if err := loadUIOverrides(); err != nil {
+			log.WithError(err).Fatal("Unable to load UI overrides")
+		}
+	}
+
+	if _, err := dashboardTiles(); err != nil {
+		log.WithError(err).Fatal("Invalid dashboard camera")
+	}
+
+	if err := validateOptionCombinations(); err != nil {
+		log.WithError(err).Fatal("Invalid combination of options")
+	}
+
+	if err := setupUpload(); err != nil {
+		log.WithError(err).Fatal("Unable to set up upload")
+	}
+
+	switch cfg.WatchdogAction {
+	case "log", "restart", "exit":
+	case "webhook":
+		if cfg.WatchdogWebhook == "" {
+			log.Fatal("Watchdog action 'webhook' requires a watchdog webhook URL")
+		}
+	default:
+		log.WithField("action", cfg.WatchdogAction).Fatal("Unknown watchdog action")
+	}
+
+	switch cfg.LogFormat {
+	case "text":
+		// Default formatter of logrus
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.WithField("format", cfg.LogFormat).Fatal("Unknown log format")
+	}
 }
 
 func main() {
-	http.HandleFunc("/mjpeg", handle)
-	http.HandleFunc("/snapshot.jpg", handleSnapshot)
+	run, ok := commands[command]
+	if !ok {
+		log.WithField("command", command).Fatalf("Unknown command, available: %s", strings.Join(commandNames(), ", "))
+	}
+
+	if err := run.Run(); err != nil {
+		withPreflightHint(log.WithError(err), err).Fatalf("Command %s failed", command)
+	}
+}
+
+// serve runs the capture and the HTTP servers until the process is
+// asked to shut down
+func serve() error {
+	if cfg.DryRun {
+		return runDryRun()
+	}
+
+	// The parent process already warned for its camera processes
+	if cfg.APIToken == "" && !isCameraProcess() {
+		log.Warn("No --api-token set: API changes are only accepted on --admin-listen, gRPC is read-only")
+	}
+
+	if cfg.OTLPEndpoint != "" || cfg.EnableMetrics {
+		shutdownTelemetry, err := setupTelemetry(context.Background())
+		if err != nil {
+			log.WithError(err).Fatal("Unable to set up telemetry")
+		}
+		defer shutdownTelemetry(context.Background())
+
+		log.WithFields(log.Fields{
+			"metrics": cfg.EnableMetrics,
+			"otlp":    cfg.OTLPEndpoint,
+		}).Debug("Telemetry export configured")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	appContext = ctx
+
+	if !cfg.SkipPreflight {
+		if err := preflightCheck(cfg.Device); err != nil {
+			withPreflightHint(log.WithError(err), err).Fatal("Preflight check failed")
+		}
+
+		if cfg.NDIName != "" {
+			if err := checkNDISupport(ctx); err != nil {
+				withPreflightHint(log.WithError(err), err).Fatal("Preflight check failed")
+			}
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", handleViewer)
+	mux.HandleFunc("GET /dashboard", handleDashboard)
+	mux.HandleFunc("/cameras/{name}/", handleCameraProxy)
+	mux.HandleFunc("GET /embed", handleEmbed)
+	if cfg.UIDir != "" {
+		mux.Handle("GET /ui/", http.StripPrefix("/ui/", http.FileServer(http.Dir(cfg.UIDir))))
+	}
+	mux.Handle("/mjpeg", newMJPEGHandler(frameBroadcaster.Hub, "/mjpeg", mjpegHooks))
+	mux.Handle("GET /mjpeg/low", sharedPacedStream(cfg.LowStreamFPS, cfg.LowStreamWidth).Handler("/mjpeg/low"))
+	mux.HandleFunc("GET /m", handleMobileViewer)
+	mux.Handle("/replay", newReplayHandler())
+	mux.HandleFunc("/snapshot.jpg", handleSnapshot)
+	if cfg.SnapshotDir != "" {
+		// Served on the main listeners the snapshot URLs point to
+		mux.Handle("GET /snapshots/", apiAuth(http.StripPrefix("/snapshots/", http.FileServer(http.Dir(cfg.SnapshotDir)))))
+	}
+	if cfg.TimelapseDir != "" {
+		mux.HandleFunc("/timelapse.mp4", handleTimelapseRender)
+	}
+	if cfg.SSDP {
+		mux.HandleFunc("GET "+ssdpDescriptionURL, handleSSDPDescription)
+	}
+	if cfg.Frigate {
+		registerFrigateHandlers(mux)
+	}
+	if cfg.Go2RTCStream != "" {
+		registerGo2RTCHandlers(mux)
+	}
+	if cfg.ONVIF {
+		mux.HandleFunc("POST "+onvifDevicePath, handleONVIF)
+		mux.HandleFunc("POST "+onvifMediaPath, handleONVIF)
+	}
+
+	var servers []*http.Server
+	for _, addr := range cfg.Listen {
+		if addr != "" {
+			servers = append(servers, &http.Server{Addr: addr, Handler: tracingHandler(accessLogHandler(mux))})
+		}
+	}
+
+	adminMux := mux
+	if cfg.AdminListen != "" {
+		adminMux = http.NewServeMux()
+		servers = append(servers, &http.Server{Addr: cfg.AdminListen, Handler: tracingHandler(accessLogHandler(adminMux))})
+	}
+	registerAdminHandlers(adminMux)
+	registerAPIHandlers(adminMux)
+
+	for _, srv := range servers {
+		l, err := listen(srv.Addr)
+		if err != nil {
+			log.WithError(err).WithField("addr", srv.Addr).Fatal("Unable to listen")
+		}
+
+		go func(srv *http.Server, l net.Listener) {
+			if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).WithField("addr", srv.Addr).Fatal("HTTP server has gone")
+			}
+		}(srv, l)
+	}
+
+	log.Debug("HTTP server spawned")
+
+	if cfg.GRPCListen != "" {
+		l, err := listen(cfg.GRPCListen)
+		if err != nil {
+			log.WithError(err).WithField("addr", cfg.GRPCListen).Fatal("Unable to listen")
+		}
+		go serveGRPC(ctx, l)
+	}
+
+	go runSystemdNotify(ctx)
+
 	go func() {
-		log.WithError(http.ListenAndServe(cfg.Listen, nil)).Fatal("HTTP server has gone")
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			if _, err := reloadConfig(); err != nil {
+				log.WithError(err).Error("Unable to reload configuration")
+			}
+		}
 	}()
 
-	log.Debug("HTTP server spawned")
+	if cfg.WatchdogTimeout > 0 {
+		go runWatchdog()
+	}
 
-	cmd := exec.Command("ffmpeg",
-		"-f", "video4linux2",
-		"-input_format", "yuyv422",
-		"-s", fmt.Sprintf("%dx%d", cfg.Width, cfg.Height),
-		"-r", strconv.Itoa(cfg.FrameRate),
-		"-i", cfg.Device,
-		"-fflags", "nobuffer",
-		"-c:v", "mjpeg",
-		"-q:v", strconv.Itoa(cfg.Quality),
-		"-boundary_tag", "ffmpeg",
-		"-f", "image2pipe",
-		"-")
+	ringSize := cfg.ReplayBuffer
+	if cfg.Motion && cfg.MotionClipDir != "" && cfg.MotionPreroll > ringSize {
+		// Pre-roll of motion clips is read from the replay buffer
+		ringSize = cfg.MotionPreroll
+	}
 
-	if cfg.FFMpegLog {
-		cmd.Stderr = os.Stderr
+	if ringSize > 0 {
+		frameBroadcaster.ring = newFrameRing(ringSize)
 	}
+	go frameBroadcaster.Run(ctx)
 
-	out, err := cmd.StdoutPipe()
-	if err != nil {
-		log.WithError(err).Fatal("Unable to create stdout pipe")
+	// Workers finishing their output (recordings, ...) on shutdown
+	var workers sync.WaitGroup
+
+	if cfg.AdaptiveClients > 0 || adaptiveBandwidth > 0 {
+		go runAdaptiveQuality(ctx)
 	}
 
-	if err := cmd.Start(); err != nil {
-		log.WithError(err).Fatal("Unable to spawn ffmpeg")
+	if cfg.TimelapseDir != "" {
+		go runTimelapse(ctx)
 	}
-	defer cmd.Process.Kill()
 
-	log.Debug("ffmpeg spawned")
+	if cfg.FrameHook != "" {
+		go runFrameHook(ctx)
+	}
+
+	if cfg.Script != "" {
+		s, err := loadScript(ctx, cfg.Script)
+		if err != nil {
+			log.WithError(err).Fatal("Unable to load script")
+		}
+		go s.run(ctx)
+	}
+
+	for _, target := range pushTargets() {
+		workers.Add(1)
+		go func(target pushTarget) {
+			defer workers.Done()
+			runPush(ctx, target)
+		}(target)
+	}
 
-	var (
-		br, bw int
-		buf    = make([]byte, 10*1024*1024) // 10MB (jpg should be smaller)
-	)
+	if cfg.ONVIF {
+		go runONVIFDiscovery(ctx)
+	}
+
+	if cfg.SSDP {
+		go runSSDP(ctx)
+	}
+
+	if cfg.SceneDir != "" {
+		go runSceneArchive(ctx)
+	}
+
+	if cfg.ExposureInterval > 0 {
+		go runExposureStats(ctx)
+	}
 
-	for {
-		// If buffer was read, slide the remains to the beginning
-		if br > 0 {
-			copy(buf, buf[br:bw])
-			bw -= br
-			br = 0
+	if cfg.ControlState != "" {
+		if err := restoreControlState(); err != nil {
+			log.WithError(err).Error("Unable to restore control state")
 		}
+		go runControlState(ctx)
+	}
 
-		// Fill buffer
-		n, err := out.Read(buf[bw:])
-		if err != nil {
-			log.WithError(err).Fatal("Unable to read from output")
+	if cfg.Preset != "" {
+		if err := applyPreset(cfg.Preset); err != nil {
+			log.WithError(err).WithField("preset", cfg.Preset).Error("Unable to apply startup preset")
 		}
-		bw += n
+	}
 
-		if n == 0 {
-			// Nothing read, try again
-			continue
+	if cfg.PowerLine != 0 {
+		if err := setPowerLineFrequency(cfg.PowerLine); err != nil {
+			log.WithError(err).Error("Unable to set power line frequency")
 		}
+	}
 
-		// Extract as many images as possible before next read
-		for eoj := bytes.Index(buf[br:bw], endOfJPEG); eoj >= 0; eoj = bytes.Index(buf[br:bw], endOfJPEG) {
-			eoj += len(endOfJPEG)
-			img := make([]byte, eoj-br)
-			copy(img, buf[br:br+eoj])
+	if cfg.Profiles != "" && cfg.ProfileMode != "manual" {
+		go runProfiles(ctx)
+	}
 
-			br += eoj
+	if cfg.MQTTBroker != "" {
+		if err := setupMQTT(); err != nil {
+			log.WithError(err).Fatal("Unable to set up MQTT")
+		}
+		motionDetection.OnEvent(publishMotionMQTT)
+		go runMQTTMotion(ctx)
 
-			if !bytes.HasPrefix(img, beginOfJPEG) || !bytes.HasSuffix(img, endOfJPEG) {
-				log.Warn("Found invalid JPEG, skipping")
-				continue
-			}
+		if cfg.MQTTSnapshotInterval > 0 {
+			go runMQTTSnapshots(ctx)
+		}
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			runMQTT(ctx)
+		}()
+	}
+
+	if cfg.Motion {
+		// Always registered as webhooks might be added by reloading
+		motionDetection.OnEvent(notifyMotionWebhooks)
+		motionDetection.OnEvent(publishMotionEvent)
+		motionDetection.OnEvent(recordMotionHistory)
+
+		if cfg.TelegramToken != "" && cfg.TelegramMotion {
+			motionDetection.OnEvent(notifyTelegramMotion)
+		}
+
+		if cfg.MotionClipDir != "" {
+			motionDetection.OnEvent(queueMotionClipEvent)
 
-			go sendImage(img)
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				runMotionClips(ctx)
+			}()
 		}
+		go motionDetection.Run(ctx)
 	}
-}
 
-func sendImage(jpg []byte) {
-	requesterLock.RLock()
-	defer requesterLock.RUnlock()
+	if uploadTarget != nil {
+		go runUploader(ctx)
+	}
 
-	if len(requester) == 0 {
-		return
+	if (maxDiskUsage > 0 || retentionAge > 0) && len(retentionDirs()) > 0 {
+		go runRetention(ctx)
 	}
 
-	for _, c := range requester {
-		if len(c) < maxBacklog {
-			c <- jpg
+	if cfg.RecordDir != "" {
+		if cfg.RecordContinuous {
+			recordControl.Request(recordSourceContinuous, time.Time{})
+		}
+
+		if len(recordSchedule) > 0 {
+			go runSchedule(ctx)
 		}
+
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			recordControl.Run(ctx)
+		}()
 	}
+	applyCameras(fileSections.Cameras)
 
-	log.WithField("requesters", len(requester)).Debug("sent frame")
-}
+	go notifyLifecycle(lifecycleWebhookPayload{Event: lifecycleStart})
+	runCaptureLoop(ctx)
 
-func handle(res http.ResponseWriter, r *http.Request) {
-	imgChan := make(chan []byte, 10)
-	uid := uuid.Must(uuid.NewV4()).String()
+	log.Info("Shutting down")
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.WithError(err).Error("Unable to notify systemd about stopping")
+	}
+	notifyLifecycle(lifecycleWebhookPayload{Event: lifecycleStop})
+	events.Close()
 
-	defer func() {
-		deregisterImgChan(uid)
-		close(imgChan)
-	}()
+	// Streaming handlers are already returning as the app context is
+	// done, give them some time to send their final boundary
+	sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	registerImgChan(uid, imgChan)
+	for _, srv := range servers {
+		if err := srv.Shutdown(sctx); err != nil {
+			log.WithError(err).WithField("addr", srv.Addr).Error("Unable to gracefully shut down HTTP server")
+		}
+	}
 
-	handleMJPEG(res, r, imgChan, uid)
+	workers.Wait()
+	cameraWorkers.Wait()
+	return nil
 }
 
 func handleSnapshot(w http.ResponseWriter, r *http.Request) {
-	imgChan := make(chan []byte, 10)
-	uid := uuid.Must(uuid.NewV4()).String()
-
-	defer func() {
-		deregisterImgChan(uid)
-		close(imgChan)
-	}()
+	sub := frameBroadcaster.Subscribe(uuid.Must(uuid.NewV4()).String())
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	var img []byte
+	select {
+	case f := <-sub.Frames():
+		defer f.Release()
+		img = f.Data
+	case <-r.Context().Done():
+		return
+	case <-appContext.Done():
+		http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
 
-	registerImgChan(uid, imgChan)
+	if v := r.URL.Query().Get("width"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width < 1 {
+			http.Error(w, "400 Invalid width", http.StatusBadRequest)
+			return
+		}
 
-	img := <-imgChan
+		if img, err = downscaleJPEG(img, width); err != nil {
+			log.WithError(err).Error("Unable to downscale snapshot")
+			http.Error(w, "500 Unable to downscale snapshot", http.StatusInternalServerError)
+			return
+		}
+	}
 
 	w.Header().Add("Cache-Control", "no-store, no-cache")
 	w.Header().Add("Connection", "close")
@@ -189,19 +755,3 @@ func handleSnapshot(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(img)
 }
-
-func registerImgChan(id string, ic chan []byte) {
-	requesterLock.Lock()
-	defer requesterLock.Unlock()
-
-	requester[id] = ic
-	log.WithField("id", id).Debug("registered new requester")
-}
-
-func deregisterImgChan(id string) {
-	requesterLock.Lock()
-	defer requesterLock.Unlock()
-
-	delete(requester, id)
-	log.WithField("id", id).Debug("removed requester")
-}
@@ -1,67 +1,102 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"mime/multipart"
+	"io"
 	"net/http"
-	"net/textproto"
+	"os"
 	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/Luzifer/cam2mjpeg/pkg/httpserv"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func handleMJPEG(res http.ResponseWriter, r *http.Request, imgs chan []byte, uid string) {
-	if r.Method != "GET" {
-		http.Error(res, "405 Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+type (
+	// mjpegRequest is the state of a MJPEG response shared by its hooks
+	mjpegRequest struct {
+		// lastWritten is the last frame written, paced streams repeat
+		// it while the camera stalls
+		lastWritten uint64
+		// replayed is the last frame written as part of the replay
+		replayed uint64
 	}
 
-	logger := log.WithField("id", uid)
+	mjpegRequestKey struct{}
+)
 
-	mimeWriter := multipart.NewWriter(res)
-	mimeWriter.SetBoundary("--boundary")
-	defer mimeWriter.Close()
+// mjpegHooks are used by all MJPEG endpoints to send the client
+// webhooks, add the latency headers and record the telemetry
+var mjpegHooks = httpserv.Hooks{
+	OnSubscribe: func(_ http.ResponseWriter, r *http.Request, sub *subscriber) error {
+		notifyClientEvent("connect", sub.ID, r)
+		return nil
+	},
 
-	res.Header().Add("Connection", "close")
-	res.Header().Add("Cache-Control", "no-store, no-cache")
-	res.Header().Add("Content-Type", fmt.Sprintf("multipart/x-mixed-replace;boundary=%s", mimeWriter.Boundary()))
+	OnPart: func(_ *http.Request, f *frame, part *httpserv.Part) error {
+		if cfg.LatencyHeaders {
+			// Times in seconds to be comparable with the latency metrics
+			part.Header.Add("X-Frame-Seq", strconv.FormatUint(f.Seq, 10))
+			part.Header.Add("X-Capture-Time", fmt.Sprintf("%.6f", float64(f.Time.UnixMicro())/1e6))
+			part.Header.Add("X-Broadcast-Latency", fmt.Sprintf("%.6f", f.BroadcastAt.Sub(f.Time).Seconds()))
+			part.Header.Add("X-Send-Latency", fmt.Sprintf("%.6f", time.Since(f.Time).Seconds()))
+		}
+		return nil
+	},
 
-	cn := res.(http.CloseNotifier).CloseNotify()
-	errC := 0
+	OnWrite: func(r *http.Request, f *frame, stats httpserv.PartStats) {
+		ctx := r.Context()
+		_, span := tracer.Start(ctx, "mjpeg.write", trace.WithTimestamp(stats.Start))
+		span.End(trace.WithTimestamp(stats.Start.Add(stats.Duration)))
+		telemetry.WriteDuration.Record(ctx, stats.Duration.Seconds())
+		atomic.AddInt64(&sentBytes, int64(stats.Bytes))
 
-	for {
-		select {
-		case <-cn:
-			return
+		// Replayed and repeated frames are stale by design, only the
+		// first write of a live frame is a meaningful latency
+		req := ctx.Value(mjpegRequestKey{}).(*mjpegRequest)
+		if stats.Err == nil && f.Seq > req.replayed && f.Seq != req.lastWritten {
+			observeFrameLatency(ctx, "write", f.Time, stats.Start.Add(stats.Duration))
+		}
+		req.lastWritten = f.Seq
+	},
 
-		case img := <-imgs:
-			err := func() error {
-				partHeader := make(textproto.MIMEHeader)
-				partHeader.Add("Content-Type", "image/jpeg")
-				partHeader.Add("Content-Length", strconv.Itoa(len(img)))
+	OnClose: func(r *http.Request, sub *subscriber, stats httpserv.StreamStats) {
+		logger := log.WithField("id", sub.ID)
 
-				partWriter, err := mimeWriter.CreatePart(partHeader)
-				if err != nil {
-					return errors.Wrap(err, "Unable to create mime part")
-				}
+		switch {
+		case stats.Err == nil:
+		case os.IsTimeout(errors.Cause(stats.Err)):
+			logger.WithError(stats.Err).Warn("Client did not keep up, disconnecting")
+		default:
+			logger.WithError(stats.Err).Error("Unable to write frame, disconnecting")
+		}
 
-				_, err = partWriter.Write(img)
-				return errors.Wrap(err, "Unable to write image")
-			}()
+		notifyClientEvent("disconnect", sub.ID, r)
+	},
+}
 
-			if err != nil {
-				logger.WithError(err).Error("Unable to process image")
-				errC++
+// newMJPEGHandler returns the handler streaming the frames of the hub
+// at the given path
+func newMJPEGHandler(hub *broadcast.Hub, path string, hooks httpserv.Hooks) http.Handler {
+	h := httpserv.NewHandler(hub, httpserv.Options{Hooks: hooks, MJPEGPath: path, WriteTimeout: cfg.ClientWriteTimeout})
 
-				if errC > 5 {
-					logger.Error("Too many errors, killing connection")
-					return
-				}
-				continue
-			}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Streams are ended on shutdown sending the final boundary
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		defer context.AfterFunc(appContext, cancel)()
 
-			errC = 0
-		}
-	}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(ctx, mjpegRequestKey{}, &mjpegRequest{})))
+	})
+}
+
+// clientWriter returns the writer to stream to the client with, it
+// fails writes the client did not accept within the write timeout
+func clientWriter(res http.ResponseWriter) io.Writer {
+	return httpserv.DeadlineWriter(res, cfg.ClientWriteTimeout)
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	motionAnalysisWidth = 160
+
+	motionEventStart = "start"
+	motionEventStop  = "stop"
+)
+
+// motionEvent is emitted when motion starts or stops, Frame contains the
+// JPEG frame which triggered the event
+type motionEvent struct {
+	Area       float64
+	Detections []detection
+	Frame      []byte
+	Time       time.Time
+	Type       string
+	Zones      []string
+}
+
+// grayFrame is a downscaled grayscale version of a frame used to
+// compare frames against each other
+type grayFrame struct {
+	Height int
+	Pix    []uint8
+	Width  int
+}
+
+type motionDetector struct {
+	active        bool
+	detectHoldoff time.Time
+	lastMotion    time.Time
+	listeners     []func(motionEvent)
+	lock          sync.RWMutex
+	prev          *grayFrame
+}
+
+var motionDetection = new(motionDetector)
+
+// Active returns whether motion is currently detected
+func (m *motionDetector) Active() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.active
+}
+
+// OnEvent registers a listener for motion events, listeners are called
+// synchronously and must not block
+func (m *motionDetector) OnEvent(fn func(motionEvent)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.listeners = append(m.listeners, fn)
+}
+
+// Run analyzes captured frames at the configured rate until the
+// context is cancelled
+func (m *motionDetector) Run(ctx context.Context) {
+	sub := frameBroadcaster.SubscribeUnthrottled("motion")
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	var (
+		interval     = time.Duration(float64(time.Second) / cfg.MotionFPS)
+		lastAnalysis time.Time
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case img := <-sub.Frames():
+			now := time.Now()
+			if now.Sub(lastAnalysis) < interval {
+				img.Release()
+				continue
+			}
+			lastAnalysis = now
+
+			if err := m.analyze(now, img.Data); err != nil {
+				log.WithError(err).Debug("Unable to analyze frame for motion")
+			}
+			img.Release()
+		}
+	}
+}
+
+func (m *motionDetector) analyze(now time.Time, img []byte) error {
+	frame, err := decodeGrayFrame(img, motionAnalysisWidth)
+	if err != nil {
+		return errors.Wrap(err, "Unable to decode frame")
+	}
+
+	m.lock.Lock()
+	prev := m.prev
+	m.prev = frame
+	m.lock.Unlock()
+
+	if prev == nil || prev.Width != frame.Width || prev.Height != frame.Height {
+		// First frame or resolution changed, nothing to compare
+		return nil
+	}
+
+	var (
+		area   float64
+		mask   = getMotionMask(frame.Width, frame.Height)
+		motion bool
+		zones  []string
+	)
+
+	for _, z := range mask.Zones {
+		za := changedArea(prev, frame, z.Pixels, uint8(cfg.MotionThreshold))
+		if za > area {
+			area = za
+		}
+
+		if za >= cfg.MotionMinArea {
+			motion = true
+			if z.Name != "" {
+				zones = append(zones, z.Name)
+			}
+		}
+	}
+
+	m.lock.RLock()
+	start := motion && !m.active && !now.Before(m.detectHoldoff)
+	m.lock.RUnlock()
+
+	var detections []detection
+	if start && detectorConfigured() {
+		if detections, err = detectObjects(img); err != nil {
+			// Rather report too many events than missing one
+			log.WithError(err).Error("Object detection failed, reporting motion unfiltered")
+		} else if detections = filterDetections(detections); len(detections) == 0 {
+			log.Debug("Motion without matching objects, ignoring")
+
+			m.lock.Lock()
+			m.detectHoldoff = now.Add(detectorHoldoff)
+			m.lock.Unlock()
+			return nil
+		}
+	}
+
+	m.lock.Lock()
+	var evt *motionEvent
+	switch {
+	case motion && m.active:
+		m.lastMotion = now
+
+	case start:
+		m.active = true
+		m.lastMotion = now
+		evt = &motionEvent{Area: area, Detections: detections, Frame: bytes.Clone(img), Time: now, Type: motionEventStart, Zones: zones}
+
+	case motion:
+		// Held off after the detector found no matching objects
+
+	case m.active && now.Sub(m.lastMotion) >= cfg.MotionCooldown:
+		m.active = false
+		evt = &motionEvent{Area: area, Frame: bytes.Clone(img), Time: now, Type: motionEventStop}
+	}
+	listeners := m.listeners
+	m.lock.Unlock()
+
+	if evt == nil {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"area":    area,
+		"camera":  cfg.Device,
+		"event":   evt.Type,
+		"objects": len(evt.Detections),
+		"zones":   evt.Zones,
+	}).Info("Motion event")
+
+	for _, fn := range listeners {
+		fn(*evt)
+	}
+
+	return nil
+}
+
+// changedArea returns the fraction of the given pixels differing by
+// more than the threshold between both frames
+func changedArea(a, b *grayFrame, pixels []int, threshold uint8) float64 {
+	if len(pixels) == 0 {
+		return 0
+	}
+
+	var changed int
+	for _, i := range pixels {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		if d < 0 {
+			d = -d
+		}
+		if d > int(threshold) {
+			changed++
+		}
+	}
+
+	return float64(changed) / float64(len(pixels))
+}
+
+// decodeGrayFrame decodes the JPEG and downscales its luminance to the
+// given width by averaging blocks of pixels, which also suppresses noise
+func decodeGrayFrame(data []byte, width int) (*grayFrame, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	luma := func(x, y int) uint8 {
+		return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+	}
+	// Fast path: JPEGs usually decode to YCbCr carrying the luminance
+	// in a separate plane
+	switch i := img.(type) {
+	case *image.YCbCr:
+		luma = func(x, y int) uint8 { return i.Y[i.YOffset(x, y)] }
+	case *image.Gray:
+		luma = func(x, y int) uint8 { return i.Pix[i.PixOffset(x, y)] }
+	}
+
+	frame := &grayFrame{Height: height, Pix: make([]uint8, width*height), Width: width}
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+
+			var sum, n int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += int(luma(sx, sy))
+					n++
+				}
+			}
+
+			frame.Pix[y*width+x] = uint8(sum / n)
+		}
+	}
+
+	return frame, nil
+}
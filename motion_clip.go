@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// motionClips writes a clip per motion event including the pre-roll
+// from the replay ring and the configured post-roll
+var motionClips = make(chan motionEvent, 10)
+
+// queueMotionClipEvent is the motion event listener feeding the clip
+// recorder
+func queueMotionClipEvent(evt motionEvent) {
+	select {
+	case motionClips <- evt:
+	default:
+		log.WithField("event", evt.Type).Error("Motion clip event queue full, dropping event")
+	}
+}
+
+// runMotionClips starts a clip on motion start and stops it after the
+// post-roll elapsed without further motion until the context is
+// cancelled, finishing the current clip before returning
+func runMotionClips(ctx context.Context) {
+	var (
+		// cancelClip and clipDone are set while a clip is written
+		cancelClip func()
+		clipDone   chan struct{}
+		motion     bool
+		stopAt     time.Time
+	)
+
+	stopClip := func() {
+		if cancelClip != nil {
+			cancelClip()
+			<-clipDone
+			cancelClip, clipDone = nil, nil
+		}
+	}
+	defer stopClip()
+
+	for {
+		var timer <-chan time.Time
+		if clipDone != nil && !motion {
+			timer = time.After(time.Until(stopAt))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case evt := <-motionClips:
+			if evt.Type != motionEventStart {
+				motion = false
+				stopAt = evt.Time.Add(cfg.MotionPostroll)
+				continue
+			}
+
+			motion = true
+			if clipDone != nil {
+				// Motion restarted within the post-roll: extend the clip
+				continue
+			}
+
+			cctx, cancel := context.WithCancel(context.Background())
+			cancelClip = cancel
+			clipDone = make(chan struct{})
+			go func(done chan struct{}, start time.Time) {
+				defer close(done)
+				if err := writeMotionClip(cctx, start); err != nil {
+					log.WithError(err).Error("Unable to write motion clip")
+				}
+			}(clipDone, evt.Time)
+
+		case <-timer:
+			stopClip()
+
+		case <-clipDone:
+			// Clip writer failed before the clip was stopped
+			cancelClip()
+			cancelClip, clipDone = nil, nil
+		}
+	}
+}
+
+// writeMotionClip writes the pre-roll before start and all following
+// frames into a clip named after the event time until the context is
+// cancelled
+func writeMotionClip(ctx context.Context, start time.Time) error {
+	if err := os.MkdirAll(cfg.MotionClipDir, 0o755); err != nil {
+		return errors.Wrap(err, "Unable to create clip directory")
+	}
+
+	// The clip is written at constant frame rate: do not receive frames
+	// throttled to the idle frame rate during the post-roll
+	sub := frameBroadcaster.SubscribeUnthrottled(uuid.Must(uuid.NewV4()).String())
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	var preroll []*frame
+	if frameBroadcaster.ring != nil {
+		preroll = frameBroadcaster.ring.Since(start.Add(-cfg.MotionPreroll))
+		defer broadcast.ReleaseFrames(preroll)
+	}
+
+	cfgLock.RLock()
+	rate := cfg.FrameRate
+	cfgLock.RUnlock()
+
+	path := filepath.Join(cfg.MotionClipDir, start.Format(timelapseFileFormat)+"_motion."+cfg.RecordContainer)
+	logger := log.WithFields(log.Fields{
+		"camera": cfg.Device,
+		"path":   path,
+	})
+
+	// Buffered frames lost their timing when written at once, use the
+	// configured frame rate instead of wall clock timestamps
+	cmd := ffmpegCommand(context.Background(),
+		"-hide_banner", "-nostats", "-y",
+		"-f", "mjpeg",
+		"-framerate", strconv.Itoa(rate),
+		"-i", "pipe:0",
+		"-an",
+		"-c:v", "copy",
+		"-f", recordingContainers[cfg.RecordContainer],
+		path,
+	)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdin pipe")
+	}
+
+	stderr := ffmpegLogWriter("motion-clip")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+
+	logger.WithField("preroll_frames", len(preroll)).Info("Motion clip started")
+
+	writeErr := func() error {
+		defer stdin.Close()
+
+		var lastSeq uint64
+		for _, f := range preroll {
+			if _, err := stdin.Write(f.Data); err != nil {
+				return errors.Wrap(err, "Unable to write frame")
+			}
+			lastSeq = f.Seq
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+
+			case img := <-sub.Frames():
+				if img.Seq <= lastSeq {
+					// Already written as part of the pre-roll
+					img.Release()
+					continue
+				}
+
+				_, err := stdin.Write(img.Data)
+				img.Release()
+				if err != nil {
+					return errors.Wrap(err, "Unable to write frame")
+				}
+			}
+		}
+	}()
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	select {
+	case err = <-waitErr:
+	case <-time.After(ffmpegStopTimeout):
+		cmd.Process.Kill()
+		err = <-waitErr
+	}
+
+	if writeErr != nil {
+		return writeErr
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "ffmpeg exited")
+	}
+
+	logger.Info("Motion clip completed")
+	queueUpload("motion", path)
+
+	return nil
+}
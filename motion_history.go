@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// motionHistorySize is the number of motion events kept in memory for
+// the recordings timeline
+const motionHistorySize = 1000
+
+type motionInterval struct {
+	End   *time.Time `json:"end,omitempty"`
+	Start time.Time  `json:"start"`
+	Zones []string   `json:"zones,omitempty"`
+}
+
+var (
+	motionHistory     []motionInterval
+	motionHistoryLock sync.RWMutex
+)
+
+// recordMotionHistory is the motion event listener collecting the
+// intervals motion was active in
+func recordMotionHistory(evt motionEvent) {
+	motionHistoryLock.Lock()
+	defer motionHistoryLock.Unlock()
+
+	switch evt.Type {
+	case motionEventStart:
+		motionHistory = append(motionHistory, motionInterval{Start: evt.Time, Zones: evt.Zones})
+		if len(motionHistory) > motionHistorySize {
+			motionHistory = motionHistory[len(motionHistory)-motionHistorySize:]
+		}
+
+	case motionEventStop:
+		if n := len(motionHistory); n > 0 && motionHistory[n-1].End == nil {
+			end := evt.Time
+			motionHistory[n-1].End = &end
+		}
+	}
+}
+
+// motionIntervals returns the motion intervals overlapping from - to,
+// intervals still active have no end
+func motionIntervals(from, to time.Time) []motionInterval {
+	motionHistoryLock.RLock()
+	defer motionHistoryLock.RUnlock()
+
+	out := []motionInterval{}
+	for _, m := range motionHistory {
+		if m.Start.After(to) || (m.End != nil && m.End.Before(from)) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
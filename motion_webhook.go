@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type motionWebhookPayload struct {
+	Area        float64     `json:"area"`
+	Camera      string      `json:"camera"`
+	Detections  []detection `json:"detections,omitempty"`
+	Event       string      `json:"event"`
+	SnapshotURL string      `json:"snapshot_url,omitempty"`
+	Time        time.Time   `json:"time"`
+	Zones       []string    `json:"zones,omitempty"`
+}
+
+func newMotionWebhookPayload(evt motionEvent) motionWebhookPayload {
+	return motionWebhookPayload{
+		Area:       evt.Area,
+		Camera:     cfg.Device,
+		Detections: evt.Detections,
+		Event:      evt.Type,
+		Time:       evt.Time,
+		Zones:      evt.Zones,
+	}
+}
+
+// notifyMotionWebhooks stores the frame as event snapshot if a snapshot
+// directory is configured and sends the motion event to all configured
+// motion webhooks
+func notifyMotionWebhooks(evt motionEvent) {
+	var (
+		urls   []string
+		attach = cfgValue(&cfg.MotionWebhookAttach)
+		store  = cfg.SnapshotDir != "" && evt.Type == motionEventStart
+	)
+	for _, u := range cfgValue(&cfg.MotionWebhook) {
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+
+	if len(urls) == 0 && !store {
+		return
+	}
+
+	payload := newMotionWebhookPayload(evt)
+
+	go func() {
+		if store {
+			snap, err := saveSnapshot(evt.Frame, "motion", evt.Time)
+			if err != nil {
+				log.WithError(err).Error("Unable to store motion snapshot")
+			}
+			payload.SnapshotURL = snap.URL
+		}
+
+		for _, u := range urls {
+			var err error
+			if attach {
+				err = sendWebhookWithAttachment(u, payload, "snapshot.jpg", evt.Frame)
+			} else {
+				err = sendWebhook(u, payload)
+			}
+
+			if err != nil {
+				log.WithError(err).WithField("event", evt.Type).Error("Unable to send motion webhook")
+			}
+		}
+	}()
+}
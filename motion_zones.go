@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// motionZone is a polygon (or rectangle given as x, y, width, height)
+// in coordinates relative to the image size (0-1)
+type motionZone struct {
+	Name   string       `json:"name" yaml:"name"`
+	Points [][2]float64 `json:"points,omitempty" yaml:"points,omitempty"`
+	Rect   []float64    `json:"rect,omitempty" yaml:"rect,omitempty"`
+}
+
+// motionZoneConfig contains the zones where motion is detected (whole
+// image if none are given) and the regions always ignored
+type motionZoneConfig struct {
+	Ignore []motionZone `json:"ignore" yaml:"ignore"`
+	Zones  []motionZone `json:"zones" yaml:"zones"`
+}
+
+// motionMask is the zone config rasterized to the analysis grid
+type motionMask struct {
+	Height int
+	Ignore []bool
+	Width  int
+	Zones  []rasterZone
+}
+
+type rasterZone struct {
+	Name   string
+	Pixels []int
+}
+
+var (
+	motionZones     motionZoneConfig
+	motionMaskCache *motionMask
+	motionZonesLock = new(sync.RWMutex)
+)
+
+// loadMotionZones reads the zone configuration from the configured
+// file, a missing file is treated as an empty configuration
+func loadMotionZones() error {
+	if cfg.MotionZones == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(cfg.MotionZones)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return errors.Wrap(err, "Unable to read motion zones")
+	}
+
+	var zc motionZoneConfig
+	if err = yaml.Unmarshal(data, &zc); err != nil {
+		return errors.Wrap(err, "Unable to parse motion zones")
+	}
+
+	return setMotionZones(zc)
+}
+
+// setMotionZones validates and activates the zone configuration
+func setMotionZones(zc motionZoneConfig) error {
+	for _, z := range append(append([]motionZone{}, zc.Zones...), zc.Ignore...) {
+		if err := z.validate(); err != nil {
+			return errors.Wrapf(err, "Invalid zone %q", z.Name)
+		}
+	}
+
+	motionZonesLock.Lock()
+	defer motionZonesLock.Unlock()
+
+	motionZones = zc
+	motionMaskCache = nil
+	return nil
+}
+
+// getMotionMask returns the rasterized zones for the given grid size
+func getMotionMask(width, height int) *motionMask {
+	motionZonesLock.RLock()
+	m := motionMaskCache
+	motionZonesLock.RUnlock()
+
+	if m != nil && m.Width == width && m.Height == height {
+		return m
+	}
+
+	motionZonesLock.Lock()
+	defer motionZonesLock.Unlock()
+
+	m = &motionMask{Height: height, Ignore: make([]bool, width*height), Width: width}
+	for _, z := range motionZones.Ignore {
+		for _, p := range z.rasterize(width, height) {
+			m.Ignore[p] = true
+		}
+	}
+
+	for _, z := range motionZones.Zones {
+		rz := rasterZone{Name: z.Name}
+		for _, p := range z.rasterize(width, height) {
+			if !m.Ignore[p] {
+				rz.Pixels = append(rz.Pixels, p)
+			}
+		}
+		m.Zones = append(m.Zones, rz)
+	}
+
+	if len(m.Zones) == 0 {
+		// No zones defined: the whole image except ignored regions
+		rz := rasterZone{}
+		for p := range m.Ignore {
+			if !m.Ignore[p] {
+				rz.Pixels = append(rz.Pixels, p)
+			}
+		}
+		m.Zones = append(m.Zones, rz)
+	}
+
+	motionMaskCache = m
+	return m
+}
+
+func (z motionZone) polygon() [][2]float64 {
+	if len(z.Rect) == 4 {
+		x, y, w, h := z.Rect[0], z.Rect[1], z.Rect[2], z.Rect[3]
+		return [][2]float64{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}}
+	}
+	return z.Points
+}
+
+// rasterize returns the offsets of all grid pixels having their center
+// inside the zone
+func (z motionZone) rasterize(width, height int) []int {
+	poly := z.polygon()
+
+	var pixels []int
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if pointInPolygon((float64(x)+0.5)/float64(width), (float64(y)+0.5)/float64(height), poly) {
+				pixels = append(pixels, y*width+x)
+			}
+		}
+	}
+
+	return pixels
+}
+
+func (z motionZone) validate() error {
+	switch {
+	case len(z.Rect) > 0 && len(z.Points) > 0:
+		return errors.New("Only one of points and rect may be given")
+	case len(z.Rect) > 0 && len(z.Rect) != 4:
+		return errors.New("Rect needs x, y, width and height")
+	case len(z.Rect) == 0 && len(z.Points) < 3:
+		return errors.New("Polygon needs at least three points")
+	}
+
+	for _, p := range z.polygon() {
+		if p[0] < 0 || p[0] > 1 || p[1] < 0 || p[1] > 1 {
+			return errors.New("Coordinates must be within 0-1")
+		}
+	}
+
+	return nil
+}
+
+// pointInPolygon uses ray casting to determine whether the point is
+// inside the polygon
+func pointInPolygon(x, y float64, poly [][2]float64) bool {
+	var inside bool
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		xi, yi := poly[i][0], poly[i][1]
+		xj, yj := poly[j][0], poly[j][1]
+
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func handleMotionZonesGet(w http.ResponseWriter, r *http.Request) {
+	motionZonesLock.RLock()
+	zc := motionZones
+	motionZonesLock.RUnlock()
+
+	writeAPIResponse(w, http.StatusOK, zc)
+}
+
+// handleMotionZonesPut replaces the zone configuration and writes it to
+// the zones file if one is configured
+func handleMotionZonesPut(w http.ResponseWriter, r *http.Request) {
+	var zc motionZoneConfig
+	if err := json.NewDecoder(r.Body).Decode(&zc); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Unable to parse zones")
+		return
+	}
+
+	if err := setMotionZones(zc); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if cfg.MotionZones != "" {
+		data, err := yaml.Marshal(zc)
+		if err == nil {
+			err = os.WriteFile(cfg.MotionZones, data, 0o644)
+		}
+		if err != nil {
+			log.WithError(err).Error("Unable to store motion zones")
+			writeAPIError(w, http.StatusInternalServerError, "Zones applied but not stored")
+			return
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"ignore": len(zc.Ignore),
+		"zones":  len(zc.Zones),
+	}).Info("Motion zones updated")
+
+	writeAPIResponse(w, http.StatusOK, zc)
+}
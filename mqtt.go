@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	mqttPublishTimeout = 10 * time.Second
+	mqttStatusInterval = 10 * time.Second
+
+	mqttOffline = "offline"
+	mqttOnline  = "online"
+)
+
+var (
+	mqttClient mqtt.Client
+	// mqttMotionStates feeds the motion states to a single publisher so
+	// they reach the broker in order
+	mqttMotionStates = make(chan bool, 10)
+)
+
+// mqttTopic joins the configured topic prefix with the given topic
+func mqttTopic(topic string) string {
+	prefix := cfg.MQTTTopicPrefix
+	if prefix == "" {
+		hostname, _ := os.Hostname()
+		prefix = "cam2mjpeg/" + hostname
+	}
+	return strings.TrimRight(prefix, "/") + "/" + topic
+}
+
+// setupMQTT connects to the configured broker with a last will marking
+// the camera offline
+func setupMQTT() error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.MQTTBroker).
+		SetAutoReconnect(true).
+		SetClientID(cfg.MQTTClientID).
+		SetConnectRetry(true).
+		SetPassword(cfg.MQTTPassword).
+		SetUsername(cfg.MQTTUser).
+		SetWill(mqttTopic("availability"), mqttOffline, 1, true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			log.WithField("broker", cfg.MQTTBroker).Info("MQTT connected")
+			// Re-announce after reconnects as the broker published the will
+			if cfg.MQTTDiscoveryPrefix != "" {
+				mqttAnnounceDiscovery()
+			}
+			mqttPublish("availability", mqttOnline, true)
+			queueMotionMQTT(motionDetection.Active())
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.WithError(err).Warn("MQTT connection lost")
+		})
+
+	if cfg.MQTTClientID == "" {
+		opts.SetClientID(fmt.Sprintf("cam2mjpeg-%d", os.Getpid()))
+	}
+
+	mqttClient = mqtt.NewClient(opts)
+
+	// With connect retry the token only completes once connected, do not
+	// block startup on an unreachable broker
+	tok := mqttClient.Connect()
+	if tok.WaitTimeout(mqttPublishTimeout) && tok.Error() != nil {
+		return errors.Wrap(tok.Error(), "Unable to connect to MQTT broker")
+	}
+
+	return nil
+}
+
+// runMQTT publishes the frame age periodically until the context is
+// cancelled and marks the camera offline afterwards
+func runMQTT(ctx context.Context) {
+	t := time.NewTicker(mqttStatusInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			mqttPublish("availability", mqttOffline, true)
+			mqttClient.Disconnect(uint(time.Second / time.Millisecond))
+			return
+
+		case <-t.C:
+			age := -1.0
+			if lf := lastFrameTime(); !lf.IsZero() {
+				age = time.Since(lf).Seconds()
+			}
+			mqttPublish("last_frame_age", fmt.Sprintf("%.1f", age), false)
+		}
+	}
+}
+
+// runMQTTSnapshots publishes the latest frame to the snapshot topic
+// per configured interval until the context is cancelled
+func runMQTTSnapshots(ctx context.Context) {
+	t := time.NewTicker(cfg.MQTTSnapshotInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		if mqttClient == nil || !mqttClient.IsConnected() {
+			continue
+		}
+
+		imgCtx, cancel := context.WithTimeout(ctx, snapshotGrabTimeout)
+		img, err := frameBroadcaster.NextFrame(imgCtx)
+		cancel()
+		if err != nil {
+			log.WithError(err).Error("Unable to grab MQTT snapshot frame")
+			continue
+		}
+
+		// The payload might be kept queued by the client after publishing
+		// timed out, it must not reference the shared frame
+		payload := bytes.Clone(img.Data)
+		img.Release()
+
+		if cfg.MQTTSnapshotWidth > 0 {
+			if payload, err = downscaleJPEG(payload, cfg.MQTTSnapshotWidth); err != nil {
+				log.WithError(err).Error("Unable to downscale MQTT snapshot")
+				continue
+			}
+		}
+
+		mqttPublish("snapshot", payload, true)
+	}
+}
+
+func mqttPublish(topic string, payload interface{}, retained bool) {
+	mqttPublishRaw(mqttTopic(topic), payload, retained)
+}
+
+// mqttPublishRaw publishes to the topic without adding the prefix
+func mqttPublishRaw(topic string, payload interface{}, retained bool) {
+	if mqttClient == nil || !mqttClient.IsConnected() {
+		return
+	}
+
+	tok := mqttClient.Publish(topic, 1, retained, payload)
+	if !tok.WaitTimeout(mqttPublishTimeout) {
+		log.WithField("topic", topic).Error("Timeout publishing MQTT message")
+		return
+	}
+
+	if err := tok.Error(); err != nil {
+		log.WithError(err).WithField("topic", topic).Error("Unable to publish MQTT message")
+	}
+}
+
+func mqttPublishMotion(active bool) {
+	if !cfg.Motion {
+		return
+	}
+
+	state := "OFF"
+	if active {
+		state = "ON"
+	}
+	mqttPublish("motion", state, true)
+}
+
+// publishMotionMQTT is the motion event listener publishing the state
+func publishMotionMQTT(evt motionEvent) {
+	queueMotionMQTT(evt.Type == motionEventStart)
+}
+
+func queueMotionMQTT(active bool) {
+	select {
+	case mqttMotionStates <- active:
+	default:
+		log.WithField("active", active).Error("MQTT motion queue full, dropping state")
+	}
+}
+
+// runMQTTMotion publishes the queued motion states until the context
+// is cancelled
+func runMQTTMotion(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case active := <-mqttMotionStates:
+			mqttPublishMotion(active)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var haObjectIDCleaner = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+type (
+	haDevice struct {
+		ConfigurationURL string   `json:"configuration_url,omitempty"`
+		Identifiers      []string `json:"identifiers"`
+		Manufacturer     string   `json:"manufacturer"`
+		Model            string   `json:"model,omitempty"`
+		Name             string   `json:"name"`
+		SWVersion        string   `json:"sw_version"`
+	}
+
+	// haDiscoveryConfig contains the fields of the Home Assistant MQTT
+	// discovery payload used for all announced entities
+	haDiscoveryConfig struct {
+		AvailabilityTopic   string   `json:"availability_topic"`
+		Device              haDevice `json:"device"`
+		DeviceClass         string   `json:"device_class,omitempty"`
+		JSONAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+		Name                string   `json:"name"`
+		PayloadAvailable    string   `json:"payload_available"`
+		PayloadNotAvailable string   `json:"payload_not_available"`
+		PayloadOff          string   `json:"payload_off,omitempty"`
+		PayloadOn           string   `json:"payload_on,omitempty"`
+		StateClass          string   `json:"state_class,omitempty"`
+		StateTopic          string   `json:"state_topic,omitempty"`
+		Topic               string   `json:"topic,omitempty"`
+		UniqueID            string   `json:"unique_id"`
+		UnitOfMeasurement   string   `json:"unit_of_measurement,omitempty"`
+	}
+
+	haAttributes struct {
+		SnapshotURL string `json:"snapshot_url,omitempty"`
+		StreamURL   string `json:"stream_url,omitempty"`
+	}
+)
+
+// mqttAnnounceDiscovery publishes the Home Assistant discovery configs
+// for the camera, the motion sensor and the frame age
+func mqttAnnounceDiscovery() {
+	var (
+		nodeID    = strings.Trim(haObjectIDCleaner.ReplaceAllString(mqttTopic(""), "_"), "_")
+		hasPublic = cfgValue(&cfg.PublicURL) != ""
+	)
+
+	device := haDevice{
+		Identifiers:  []string{nodeID},
+		Manufacturer: "cam2mjpeg",
+		Name:         nodeID,
+		SWVersion:    version,
+	}
+	if hasPublic {
+		device.ConfigurationURL = publicURL("/")
+	}
+	if caps, err := getCapabilities(); err == nil {
+		device.Model = caps.Card
+	}
+
+	base := haDiscoveryConfig{
+		AvailabilityTopic:   mqttTopic("availability"),
+		Device:              device,
+		PayloadAvailable:    mqttOnline,
+		PayloadNotAvailable: mqttOffline,
+	}
+
+	type entity struct {
+		component, objectID string
+		config              haDiscoveryConfig
+	}
+	var entities []entity
+
+	if cfg.MQTTSnapshotInterval > 0 {
+		c := base
+		c.JSONAttributesTopic = mqttTopic("attributes")
+		c.Name = "Camera"
+		c.Topic = mqttTopic("snapshot")
+		c.UniqueID = nodeID + "_camera"
+		entities = append(entities, entity{"camera", "camera", c})
+	}
+
+	if cfg.Motion {
+		c := base
+		c.DeviceClass = "motion"
+		c.Name = "Motion"
+		c.PayloadOff = "OFF"
+		c.PayloadOn = "ON"
+		c.StateTopic = mqttTopic("motion")
+		c.UniqueID = nodeID + "_motion"
+		entities = append(entities, entity{"binary_sensor", "motion", c})
+	}
+
+	age := base
+	age.DeviceClass = "duration"
+	age.Name = "Last frame age"
+	age.StateClass = "measurement"
+	age.StateTopic = mqttTopic("last_frame_age")
+	age.UniqueID = nodeID + "_last_frame_age"
+	age.UnitOfMeasurement = "s"
+	entities = append(entities, entity{"sensor", "last_frame_age", age})
+
+	for _, e := range entities {
+		payload, err := json.Marshal(e.config)
+		if err != nil {
+			log.WithError(err).WithField("entity", e.objectID).Error("Unable to encode discovery config")
+			continue
+		}
+
+		mqttPublishRaw(strings.Join([]string{cfg.MQTTDiscoveryPrefix, e.component, nodeID, e.objectID, "config"}, "/"), payload, true)
+	}
+
+	if hasPublic {
+		attrs, _ := json.Marshal(haAttributes{
+			SnapshotURL: publicURL("/snapshot.jpg"),
+			StreamURL:   publicURL("/mjpeg"),
+		})
+		mqttPublish("attributes", attrs, true)
+	}
+}
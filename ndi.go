@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const ndiMuxer = "libndi_newtek"
+
+// ndiTarget returns the push target emitting the frames as NDI source
+// with the given name, NDI transports uncompressed video
+func ndiTarget(name string) pushTarget {
+	return pushTarget{
+		Format:    ndiMuxer,
+		Name:      "ndi",
+		URL:       name,
+		VideoArgs: []string{"-c:v", "rawvideo", "-pix_fmt", "uyvy422"},
+	}
+}
+
+// checkNDISupport verifies the ffmpeg build contains the NDI muxer
+func checkNDISupport(ctx context.Context) error {
+	out, err := ffmpegCommand(ctx, "-hide_banner", "-muxers").Output()
+	if err != nil {
+		return errors.Wrap(err, "Unable to list ffmpeg muxers")
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == ndiMuxer {
+			return nil
+		}
+	}
+
+	return preflightError{
+		Err:  errors.New("ffmpeg does not support NDI output"),
+		Hint: "Use an ffmpeg build with the NDI SDK (--enable-libndi_newtek) as upstream ffmpeg dropped it in version 4.4",
+	}
+}
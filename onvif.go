@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	onvifDevicePath  = "/onvif/device_service"
+	onvifMediaPath   = "/onvif/media_service"
+	onvifMaxBodySize = 1 << 20
+	onvifProfile     = "profile_1"
+
+	onvifNSDevice = "http://www.onvif.org/ver10/device/wsdl"
+	onvifNSMedia  = "http://www.onvif.org/ver10/media/wsdl"
+
+	onvifEnvelope = `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"` +
+		` xmlns:ter="http://www.onvif.org/ver10/error"` +
+		` xmlns:tds="http://www.onvif.org/ver10/device/wsdl"` +
+		` xmlns:trt="http://www.onvif.org/ver10/media/wsdl"` +
+		` xmlns:tt="http://www.onvif.org/ver10/schema">` +
+		`<s:Body>%s</s:Body></s:Envelope>`
+)
+
+// onvifActions maps the SOAP actions of the device and media service
+// to their response body, only the subset needed by NVRs to adopt the
+// camera as Profile S device is implemented
+var onvifActions = map[string]func(r *http.Request) string{
+	"GetCapabilities":      onvifGetCapabilities,
+	"GetDeviceInformation": onvifGetDeviceInformation,
+	"GetProfile":           onvifGetProfile,
+	"GetProfiles":          onvifGetProfiles,
+	"GetScopes":            onvifGetScopes,
+	"GetServices":          onvifGetServices,
+	"GetSnapshotUri":       onvifGetSnapshotURI,
+	"GetStreamUri":         onvifGetStreamURI,
+	"GetSystemDateAndTime": onvifGetSystemDateAndTime,
+	"GetVideoSources":      onvifGetVideoSources,
+	"GetVideoSourceConfigurations": func(r *http.Request) string {
+		return "<trt:GetVideoSourceConfigurationsResponse>" + onvifVideoSourceConfiguration("Configurations") + "</trt:GetVideoSourceConfigurationsResponse>"
+	},
+}
+
+// handleONVIF dispatches SOAP requests to the ONVIF device and media
+// services by the name of the first element in the body
+func handleONVIF(w http.ResponseWriter, r *http.Request) {
+	action, err := onvifAction(io.LimitReader(r.Body, onvifMaxBodySize))
+	if err != nil {
+		log.WithError(err).Debug("Unable to parse ONVIF request")
+		writeONVIF(w, http.StatusBadRequest, onvifFault("s:Sender", "ter:WellFormed", "Unable to parse request"))
+		return
+	}
+
+	fn, ok := onvifActions[action]
+	if !ok {
+		log.WithField("action", action).Debug("Unsupported ONVIF action")
+		writeONVIF(w, http.StatusBadRequest, onvifFault("s:Receiver", "ter:ActionNotSupported", "Action "+action+" is not supported"))
+		return
+	}
+
+	writeONVIF(w, http.StatusOK, fn(r))
+}
+
+// onvifAction returns the local name of the first element inside the
+// SOAP body
+func onvifAction(body io.Reader) (string, error) {
+	dec := xml.NewDecoder(body)
+	inBody := false
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+
+		if el, ok := tok.(xml.StartElement); ok {
+			if inBody {
+				return el.Name.Local, nil
+			}
+			inBody = el.Name.Local == "Body"
+		}
+	}
+}
+
+func writeONVIF(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, onvifEnvelope, body)
+}
+
+func onvifFault(code, subcode, reason string) string {
+	return fmt.Sprintf(`<s:Fault><s:Code><s:Value>%s</s:Value><s:Subcode><s:Value>%s</s:Value></s:Subcode></s:Code><s:Reason><s:Text xml:lang="en">%s</s:Text></s:Reason></s:Fault>`,
+		code, subcode, xmlEscape(reason))
+}
+
+// onvifBaseURL returns the URL the services are reachable at, preferring
+// the configured public URL over the host requested by the client
+func onvifBaseURL(r *http.Request) string {
+	if u := cfgValue(&cfg.PublicURL); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://" + r.Host
+}
+
+func onvifGetCapabilities(r *http.Request) string {
+	base := onvifBaseURL(r)
+	return "<tds:GetCapabilitiesResponse><tds:Capabilities>" +
+		"<tt:Device><tt:XAddr>" + xmlEscape(base+onvifDevicePath) + "</tt:XAddr></tt:Device>" +
+		"<tt:Media><tt:XAddr>" + xmlEscape(base+onvifMediaPath) + "</tt:XAddr>" +
+		"<tt:StreamingCapabilities><tt:RTPMulticast>false</tt:RTPMulticast><tt:RTP_TCP>false</tt:RTP_TCP><tt:RTP_RTSP_TCP>false</tt:RTP_RTSP_TCP></tt:StreamingCapabilities>" +
+		"</tt:Media></tds:Capabilities></tds:GetCapabilitiesResponse>"
+}
+
+func onvifGetDeviceInformation(*http.Request) string {
+	return "<tds:GetDeviceInformationResponse>" +
+		"<tds:Manufacturer>cam2mjpeg</tds:Manufacturer>" +
+		"<tds:Model>" + xmlEscape(cameraModel()) + "</tds:Model>" +
+		"<tds:FirmwareVersion>" + xmlEscape(version) + "</tds:FirmwareVersion>" +
+		"<tds:SerialNumber>" + cameraUUID() + "</tds:SerialNumber>" +
+		"<tds:HardwareId>" + xmlEscape(cfg.Device) + "</tds:HardwareId>" +
+		"</tds:GetDeviceInformationResponse>"
+}
+
+func onvifGetScopes(*http.Request) string {
+	var buf bytes.Buffer
+	buf.WriteString("<tds:GetScopesResponse>")
+	for _, s := range onvifScopes() {
+		buf.WriteString("<tds:Scopes><tt:ScopeDef>Fixed</tt:ScopeDef><tt:ScopeItem>" + xmlEscape(s) + "</tt:ScopeItem></tds:Scopes>")
+	}
+	buf.WriteString("</tds:GetScopesResponse>")
+	return buf.String()
+}
+
+func onvifScopes() []string {
+	hostname, _ := os.Hostname()
+	return []string{
+		"onvif://www.onvif.org/Profile/Streaming",
+		"onvif://www.onvif.org/type/video_encoder",
+		"onvif://www.onvif.org/name/" + strings.ReplaceAll(hostname, " ", "_"),
+		"onvif://www.onvif.org/hardware/" + strings.ReplaceAll(cameraModel(), " ", "_"),
+	}
+}
+
+func onvifGetServices(r *http.Request) string {
+	base := onvifBaseURL(r)
+	service := func(ns, path string) string {
+		return "<tds:Service><tds:Namespace>" + ns + "</tds:Namespace><tds:XAddr>" + xmlEscape(base+path) + "</tds:XAddr>" +
+			"<tds:Version><tt:Major>2</tt:Major><tt:Minor>0</tt:Minor></tds:Version></tds:Service>"
+	}
+	return "<tds:GetServicesResponse>" + service(onvifNSDevice, onvifDevicePath) + service(onvifNSMedia, onvifMediaPath) + "</tds:GetServicesResponse>"
+}
+
+func onvifGetSystemDateAndTime(*http.Request) string {
+	now := time.Now().UTC()
+	return fmt.Sprintf("<tds:GetSystemDateAndTimeResponse><tds:SystemDateAndTime>"+
+		"<tt:DateTimeType>NTP</tt:DateTimeType><tt:DaylightSavings>false</tt:DaylightSavings>"+
+		"<tt:TimeZone><tt:TZ>UTC</tt:TZ></tt:TimeZone>"+
+		"<tt:UTCDateTime><tt:Time><tt:Hour>%d</tt:Hour><tt:Minute>%d</tt:Minute><tt:Second>%d</tt:Second></tt:Time>"+
+		"<tt:Date><tt:Year>%d</tt:Year><tt:Month>%d</tt:Month><tt:Day>%d</tt:Day></tt:Date></tt:UTCDateTime>"+
+		"</tds:SystemDateAndTime></tds:GetSystemDateAndTimeResponse>",
+		now.Hour(), now.Minute(), now.Second(), now.Year(), now.Month(), now.Day())
+}
+
+func onvifVideoSourceConfiguration(element string) string {
+	s := currentCaptureSettings()
+	return fmt.Sprintf(`<trt:%s token="source_config"><tt:Name>source</tt:Name><tt:UseCount>1</tt:UseCount>`+
+		`<tt:SourceToken>source</tt:SourceToken><tt:Bounds x="0" y="0" width="%d" height="%d"/></trt:%s>`,
+		element, *s.Width, *s.Height, element)
+}
+
+func onvifProfileXML(element string) string {
+	s := currentCaptureSettings()
+	// ONVIF JPEG quality is 0-100 with higher being better, ffmpeg uses
+	// 2-31 with lower being better
+	quality := 100 - (*s.Quality-2)*100/29
+
+	return fmt.Sprintf(`<trt:%s token="%s" fixed="true"><tt:Name>MJPEG</tt:Name>`+
+		`<tt:VideoSourceConfiguration token="source_config"><tt:Name>source</tt:Name><tt:UseCount>1</tt:UseCount>`+
+		`<tt:SourceToken>source</tt:SourceToken><tt:Bounds x="0" y="0" width="%[3]d" height="%[4]d"/></tt:VideoSourceConfiguration>`+
+		`<tt:VideoEncoderConfiguration token="encoder_config"><tt:Name>MJPEG</tt:Name><tt:UseCount>1</tt:UseCount>`+
+		`<tt:Encoding>JPEG</tt:Encoding><tt:Resolution><tt:Width>%[3]d</tt:Width><tt:Height>%[4]d</tt:Height></tt:Resolution>`+
+		`<tt:Quality>%[5]d</tt:Quality><tt:RateControl><tt:FrameRateLimit>%[6]d</tt:FrameRateLimit><tt:EncodingInterval>1</tt:EncodingInterval><tt:BitrateLimit>0</tt:BitrateLimit></tt:RateControl>`+
+		`<tt:SessionTimeout>PT60S</tt:SessionTimeout></tt:VideoEncoderConfiguration></trt:%[1]s>`,
+		element, onvifProfile, *s.Width, *s.Height, quality, *s.FrameRate)
+}
+
+func onvifGetProfiles(*http.Request) string {
+	return "<trt:GetProfilesResponse>" + onvifProfileXML("Profiles") + "</trt:GetProfilesResponse>"
+}
+
+func onvifGetProfile(*http.Request) string {
+	return "<trt:GetProfileResponse>" + onvifProfileXML("Profile") + "</trt:GetProfileResponse>"
+}
+
+func onvifGetVideoSources(*http.Request) string {
+	s := currentCaptureSettings()
+	return fmt.Sprintf(`<trt:GetVideoSourcesResponse><trt:VideoSources token="source"><tt:Framerate>%d</tt:Framerate>`+
+		`<tt:Resolution><tt:Width>%d</tt:Width><tt:Height>%d</tt:Height></tt:Resolution></trt:VideoSources></trt:GetVideoSourcesResponse>`,
+		*s.FrameRate, *s.Width, *s.Height)
+}
+
+func onvifMediaURI(uri string) string {
+	return "<trt:MediaUri><tt:Uri>" + xmlEscape(uri) + "</tt:Uri><tt:InvalidAfterConnect>false</tt:InvalidAfterConnect>" +
+		"<tt:InvalidAfterReboot>false</tt:InvalidAfterReboot><tt:Timeout>PT0S</tt:Timeout></trt:MediaUri>"
+}
+
+// onvifGetStreamURI points to the MJPEG stream as there is no RTSP
+// output to offer
+func onvifGetStreamURI(r *http.Request) string {
+	return "<trt:GetStreamUriResponse>" + onvifMediaURI(onvifBaseURL(r)+"/mjpeg") + "</trt:GetStreamUriResponse>"
+}
+
+func onvifGetSnapshotURI(r *http.Request) string {
+	return "<trt:GetSnapshotUriResponse>" + onvifMediaURI(onvifBaseURL(r)+"/snapshot.jpg") + "</trt:GetSnapshotUriResponse>"
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wsDiscoveryAddr       = "239.255.255.250:3702"
+	wsDiscoveryMaxMessage = 64 * 1024
+)
+
+type wsDiscoveryProbe struct {
+	Body struct {
+		Probe *struct {
+			Types string `xml:"Types"`
+		} `xml:"Probe"`
+	} `xml:"Body"`
+	Header struct {
+		MessageID string `xml:"MessageID"`
+	} `xml:"Header"`
+}
+
+// runONVIFDiscovery answers WS-Discovery probes for video transmitters
+// until the context is cancelled
+func runONVIFDiscovery(ctx context.Context) {
+	group, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		log.WithError(err).Error("Unable to resolve WS-Discovery address")
+		return
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.WithError(err).Error("Unable to listen for WS-Discovery probes, ONVIF discovery disabled")
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.WithField("addr", wsDiscoveryAddr).Info("Answering ONVIF discovery probes")
+
+	buf := make([]byte, wsDiscoveryMaxMessage)
+	for {
+		n, sender, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("Unable to read WS-Discovery probe")
+			}
+			return
+		}
+
+		var probe wsDiscoveryProbe
+		if err = xml.Unmarshal(buf[:n], &probe); err != nil || probe.Body.Probe == nil {
+			continue
+		}
+
+		if t := probe.Body.Probe.Types; t != "" && !strings.Contains(t, "NetworkVideoTransmitter") && !strings.Contains(t, "Device") {
+			continue
+		}
+
+		match, err := wsDiscoveryProbeMatch(probe.Header.MessageID, sender)
+		if err != nil {
+			log.WithError(err).Debug("Unable to build WS-Discovery probe match")
+			continue
+		}
+
+		if _, err = conn.WriteToUDP(match, sender); err != nil {
+			log.WithError(err).WithField("to", sender.String()).Debug("Unable to send WS-Discovery probe match")
+		}
+	}
+}
+
+// wsDiscoveryProbeMatch builds the answer to a probe with the device
+// service address as seen from the sender
+func wsDiscoveryProbeMatch(relatesTo string, sender *net.UDPAddr) ([]byte, error) {
+	base, err := discoveryBaseURL(sender)
+	if err != nil {
+		return nil, err
+	}
+	xaddr := base + onvifDevicePath
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing"`+
+		` xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">`+
+		`<s:Header><a:MessageID>urn:uuid:%s</a:MessageID><a:RelatesTo>%s</a:RelatesTo>`+
+		`<a:To>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:To>`+
+		`<a:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</a:Action></s:Header>`+
+		`<s:Body><d:ProbeMatches><d:ProbeMatch>`+
+		`<a:EndpointReference><a:Address>urn:uuid:%s</a:Address></a:EndpointReference>`+
+		`<d:Types>dn:NetworkVideoTransmitter</d:Types><d:Scopes>%s</d:Scopes><d:XAddrs>%s</d:XAddrs>`+
+		`<d:MetadataVersion>1</d:MetadataVersion></d:ProbeMatch></d:ProbeMatches></s:Body></s:Envelope>`,
+		uuid.Must(uuid.NewV4()), xmlEscape(relatesTo), cameraUUID(), xmlEscape(strings.Join(onvifScopes(), " ")), xmlEscape(xaddr))
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// apiPrefix is the path all versioned API endpoints are served below
+const apiPrefix = "/api/v1/"
+
+type (
+	// apiRoute describes an API endpoint to register it and to document
+	// it in the OpenAPI specification
+	apiRoute struct {
+		Handler http.HandlerFunc
+		// Pattern is the method and path as registered with the mux
+		Pattern string
+		// Query lists the optional query parameters
+		Query []string
+		// Request and Response are values of the body types, nil for
+		// endpoints without body
+		Request  interface{}
+		Response interface{}
+		// Status of successful responses (default: 200)
+		Status  int
+		Summary string
+	}
+
+	// apiEvents marks endpoints streaming events
+	apiEvents struct{}
+	// apiFile marks endpoints responding with a file
+	apiFile struct{}
+	// apiText marks endpoints reading a plain text body
+	apiText string
+
+	openAPISchemas map[string]interface{}
+)
+
+var (
+	apiPathParam = regexp.MustCompile(`\{(\w+)(\.\.\.)?\}`)
+	apiMethods   = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+)
+
+// handleAPIFallback answers requests to unknown API endpoints or with
+// methods not supported by the endpoint with the JSON error shape
+func handleAPIFallback(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, m := range apiMethods {
+			probe := r.Clone(r.Context())
+			probe.Method = m
+			if _, pattern := mux.Handler(probe); pattern != "" && pattern != apiPrefix {
+				allowed = append(allowed, m)
+			}
+		}
+
+		if len(allowed) > 0 {
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		writeAPIError(w, http.StatusNotFound, "Endpoint not found")
+	}
+}
+
+// handleOpenAPI serves the OpenAPI specification of the enabled API
+// endpoints generated from the route definitions
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, http.StatusOK, openAPISpec(apiRoutes()))
+}
+
+func openAPISpec(routes []apiRoute) map[string]interface{} {
+	var (
+		paths   = map[string]map[string]interface{}{}
+		schemas = openAPISchemas{}
+	)
+
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemas.of(reflect.TypeOf(apiError{}))}},
+	}
+
+	for _, rt := range routes {
+		method, path, _ := strings.Cut(rt.Pattern, " ")
+
+		var params []interface{}
+		for _, m := range apiPathParam.FindAllStringSubmatch(path, -1) {
+			params = append(params, map[string]interface{}{"in": "path", "name": m[1], "required": true, "schema": map[string]string{"type": "string"}})
+		}
+		for _, q := range rt.Query {
+			params = append(params, map[string]interface{}{"in": "query", "name": q, "schema": map[string]string{"type": "string"}})
+		}
+
+		status := rt.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(status)}
+		if rt.Response != nil {
+			success["content"] = schemas.content(rt.Response)
+		}
+
+		op := map[string]interface{}{
+			"summary": rt.Summary,
+			"responses": map[string]interface{}{
+				"default":            errorResponse,
+				strconv.Itoa(status): success,
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if rt.Request != nil {
+			op["requestBody"] = map[string]interface{}{"content": schemas.content(rt.Request)}
+		}
+
+		path = apiPathParam.ReplaceAllString(path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = op
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]string{"title": "cam2mjpeg", "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas":         schemas,
+			"securitySchemes": map[string]interface{}{"bearer": map[string]string{"type": "http", "scheme": "bearer"}},
+		},
+	}
+	if cfgValue(&cfg.APIToken) != "" {
+		spec["security"] = []map[string][]string{{"bearer": {}}}
+	}
+
+	return spec
+}
+
+// content returns the media type object for the body value
+func (s openAPISchemas) content(v interface{}) map[string]interface{} {
+	switch v.(type) {
+	case apiEvents:
+		return map[string]interface{}{"text/event-stream": map[string]interface{}{"schema": s.of(reflect.TypeOf(busEvent{}))}}
+	case apiFile:
+		return map[string]interface{}{"application/octet-stream": map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}}}
+	case apiText:
+		return map[string]interface{}{"text/plain": map[string]interface{}{"schema": map[string]string{"type": "string"}}}
+	default:
+		return map[string]interface{}{"application/json": map[string]interface{}{"schema": s.of(reflect.TypeOf(v))}}
+	}
+}
+
+// of returns the schema of the type as encoded by encoding/json, named
+// structs are added to the schemas and referenced
+func (s openAPISchemas) of(t reflect.Type) interface{} {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]string{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]string{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return map[string]string{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]string{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]string{"type": "number"}
+	case reflect.String:
+		return map[string]string{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]string{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+
+		name := string(unicode.ToUpper(rune(t.Name()[0]))) + t.Name()[1:]
+		if _, ok := s[name]; !ok {
+			// Reserve the name before descending into recursive types
+			s[name] = nil
+			s[name] = s.object(t)
+		}
+		return map[string]string{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]string{}
+	}
+}
+
+func (s openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	s.addFields(props, t)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+func (s openAPISchemas) addFields(props map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch {
+		case name == "-":
+			continue
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			// Embedded struct fields are encoded inline
+			s.addFields(props, f.Type)
+			continue
+		case !f.IsExported():
+			continue
+		case name == "":
+			name = f.Name
+		}
+
+		props[name] = s.of(f.Type)
+	}
+}
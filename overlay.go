@@ -0,0 +1,137 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	overlayDefaultColor = "white"
+	overlayDefaultSize  = 24
+	overlayMargin       = "10"
+)
+
+var (
+	// overlayPositions maps the overlay positions to the drawtext
+	// coordinates of the text
+	overlayPositions = map[string][2]string{
+		"bottom-left":  {overlayMargin, "h-th-" + overlayMargin},
+		"bottom-right": {"w-tw-" + overlayMargin, "h-th-" + overlayMargin},
+		"top-left":     {overlayMargin, overlayMargin},
+		"top-right":    {"w-tw-" + overlayMargin, overlayMargin},
+	}
+
+	overlayColor = regexp.MustCompile(`^[a-zA-Z0-9#@.]+$`)
+)
+
+// maskConfig blacks out a rectangle of the captured frames given as
+// x, y, width and height relative to the image size (0-1)
+type maskConfig struct {
+	Name string    `toml:"name" yaml:"name"`
+	Rect []float64 `toml:"rect" yaml:"rect"`
+}
+
+// overlayConfig draws a text onto the captured frames, the text may
+// contain drawtext expansions like %{localtime}
+type overlayConfig struct {
+	Box      bool   `toml:"box" yaml:"box"`
+	Color    string `toml:"color" yaml:"color"`
+	Font     string `toml:"font" yaml:"font"`
+	Position string `toml:"position" yaml:"position"`
+	Size     int    `toml:"size" yaml:"size"`
+	Text     string `toml:"text" yaml:"text"`
+}
+
+func (m maskConfig) validate() error {
+	if len(m.Rect) != 4 {
+		return errors.New("Rect needs x, y, width and height")
+	}
+
+	x, y, w, h := m.Rect[0], m.Rect[1], m.Rect[2], m.Rect[3]
+	if x < 0 || y < 0 || w <= 0 || h <= 0 || x+w > 1 || y+h > 1 {
+		return errors.New("Rect must be within 0-1")
+	}
+
+	return nil
+}
+
+func (o overlayConfig) validate() error {
+	switch {
+	case o.Text == "":
+		return errors.New("Text is required")
+	case o.Position != "" && overlayPositions[o.Position] == [2]string{}:
+		return errors.Errorf("Unknown position %q (top-left, top-right, bottom-left, bottom-right)", o.Position)
+	case o.Color != "" && !overlayColor.MatchString(o.Color):
+		return errors.Errorf("Invalid color %q", o.Color)
+	case o.Size < 0:
+		return errors.New("Size must not be negative")
+	}
+
+	return nil
+}
+
+// captureFilter returns the ffmpeg filter chain drawing the masks and
+// overlays (empty if there are none), masks are drawn first so they
+// do not hide overlays
+func captureFilter(masks []maskConfig, overlays []overlayConfig) string {
+	var filters []string
+
+	for _, m := range masks {
+		filters = append(filters, "drawbox="+strings.Join([]string{
+			"x=iw*" + formatFilterFloat(m.Rect[0]),
+			"y=ih*" + formatFilterFloat(m.Rect[1]),
+			"w=iw*" + formatFilterFloat(m.Rect[2]),
+			"h=ih*" + formatFilterFloat(m.Rect[3]),
+			"color=black",
+			"t=fill",
+		}, ":"))
+	}
+
+	for _, o := range overlays {
+		var (
+			color = o.Color
+			pos   = overlayPositions[o.Position]
+			size  = o.Size
+		)
+
+		if color == "" {
+			color = overlayDefaultColor
+		}
+		if pos == [2]string{} {
+			pos = overlayPositions["top-left"]
+		}
+		if size == 0 {
+			size = overlayDefaultSize
+		}
+
+		opts := []string{
+			"text=" + escapeFilterValue(o.Text),
+			"fontcolor=" + color,
+			"fontsize=" + strconv.Itoa(size),
+			"x=" + pos[0],
+			"y=" + pos[1],
+		}
+		if o.Box {
+			opts = append(opts, "box=1", "boxcolor=black@0.5", "boxborderw=5")
+		}
+		if o.Font != "" {
+			opts = append(opts, "fontfile="+escapeFilterValue(o.Font))
+		}
+
+		filters = append(filters, "drawtext="+strings.Join(opts, ":"))
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// escapeFilterValue escapes the value of a filter option for the option
+// list and for the filter graph it is part of
+func escapeFilterValue(v string) string {
+	opt := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(v)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(opt)
+}
+
+func formatFilterFloat(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/gofrs/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// pacedStream sends the latest frame, downscaled to the width if it is
+// positive, to its subscribers at a constant frame rate repeating it
+// while the camera stalls. A single producer prepares the frames for
+// all subscribers while any of them is connected.
+type pacedStream struct {
+	*broadcast.Hub
+
+	fps   func() int
+	width int
+
+	lock   sync.Mutex
+	cancel context.CancelFunc
+}
+
+var (
+	pacedStreams     []*pacedStream
+	pacedStreamsLock sync.RWMutex
+	// sharedStreams are the streams of a constant frame rate by frame
+	// rate and width, used by all endpoints serving the same stream
+	sharedStreams = map[[2]int]*pacedStream{}
+)
+
+// newPacedStream creates a stream of a frame rate which might change
+func newPacedStream(fps func() int, width int) *pacedStream {
+	p := &pacedStream{Hub: broadcast.NewHub(), fps: fps, width: width}
+	p.OnChange = func(s *subscriber, added bool) {
+		trackSubscriber(s, added)
+		p.update()
+	}
+
+	pacedStreamsLock.Lock()
+	defer pacedStreamsLock.Unlock()
+	pacedStreams = append(pacedStreams, p)
+
+	return p
+}
+
+// sharedPacedStream returns the stream of the given constant frame
+// rate and width, creating it on first use
+func sharedPacedStream(fps, width int) *pacedStream {
+	key := [2]int{fps, width}
+
+	pacedStreamsLock.RLock()
+	p, ok := sharedStreams[key]
+	pacedStreamsLock.RUnlock()
+	if ok {
+		return p
+	}
+
+	// Streams are set up before serving, no concurrent calls
+	p = newPacedStream(func() int { return fps }, width)
+
+	pacedStreamsLock.Lock()
+	defer pacedStreamsLock.Unlock()
+	sharedStreams[key] = p
+
+	return p
+}
+
+// Handler returns the handler streaming the frames to the clients at
+// the given path
+func (p *pacedStream) Handler(path string) http.Handler {
+	return newMJPEGHandler(p.Hub, path, mjpegHooks)
+}
+
+// pacedClientCount returns the number of clients of all paced streams
+func pacedClientCount() int {
+	pacedStreamsLock.RLock()
+	defer pacedStreamsLock.RUnlock()
+
+	var n int
+	for _, p := range pacedStreams {
+		n += p.ClientCount()
+	}
+	return n
+}
+
+// update starts the producer when the first client connected and stops
+// it after the last one left
+func (p *pacedStream) update() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch active := p.ClientCount() > 0; {
+	case active && p.cancel == nil:
+		var ctx context.Context
+		ctx, p.cancel = context.WithCancel(appContext)
+		go p.run(ctx)
+
+	case !active && p.cancel != nil:
+		p.cancel()
+		p.cancel = nil
+	}
+}
+
+func (p *pacedStream) run(ctx context.Context) {
+	sub := frameBroadcaster.SubscribeInternal(uuid.Must(uuid.NewV4()).String())
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	fps := p.fps()
+	t := time.NewTicker(time.Second / time.Duration(fps))
+	defer t.Stop()
+
+	var (
+		latest *frame
+		// prepared is the (downscaled) latest frame, kept to not
+		// downscale repeated frames again
+		prepared *frame
+	)
+	defer func() {
+		for _, f := range []*frame{latest, prepared} {
+			if f != nil {
+				f.Release()
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-sub.Done():
+			return
+
+		case img := <-sub.Frames():
+			if latest != nil {
+				latest.Release()
+			}
+			if prepared != nil {
+				prepared.Release()
+			}
+			latest, prepared = img, nil
+
+		case <-t.C:
+			if f := p.fps(); f != fps {
+				fps = f
+				t.Reset(time.Second / time.Duration(fps))
+			}
+
+			if latest == nil {
+				continue
+			}
+
+			if prepared == nil {
+				var err error
+				if prepared, err = p.prepare(latest); err != nil {
+					log.WithError(err).Error("Unable to downscale frame")
+					continue
+				}
+			}
+
+			p.Send(prepared, nil)
+		}
+	}
+}
+
+// prepare returns a new reference to the frame downscaled to the width
+// of the stream
+func (p *pacedStream) prepare(f *frame) (*frame, error) {
+	if p.width <= 0 {
+		return f.Retain(), nil
+	}
+
+	if c, err := jpeg.DecodeConfig(bytes.NewReader(f.Data)); err == nil && c.Width <= p.width {
+		return f.Retain(), nil
+	}
+
+	data, err := downscaleJPEG(f.Data, p.width)
+	if err != nil {
+		return nil, err
+	}
+
+	scaled := broadcast.WrapFrame(data, f)
+	scaled.BroadcastAt = f.BroadcastAt
+	return scaled, nil
+}
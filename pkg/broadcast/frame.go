@@ -0,0 +1,97 @@
+// Package broadcast distributes reference counted JPEG frames to any
+// number of subscribers without copying the frame data.
+package broadcast
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Frame is a captured JPEG shared among all its receivers. Every
+// receiver owns a reference it must release when done with the data,
+// the backing buffer of pooled frames is reused afterwards. Receivers
+// keeping the data beyond that must copy it, the data itself must never
+// be modified.
+type Frame struct {
+	Data []byte
+	// Seq is the capture sequence number, increasing with every frame
+	Seq uint64
+	// Time is the time the frame was extracted from the capture
+	Time time.Time
+	// BroadcastAt is the time the broadcaster started distributing it
+	BroadcastAt time.Time
+
+	buf    []byte
+	pooled bool
+	refs   int32
+}
+
+var (
+	framePool = sync.Pool{New: func() interface{} { return &Frame{pooled: true} }}
+
+	// memory is the size of the pooled frame buffers currently
+	// referenced, memoryPeak the highest size seen
+	memory     int64
+	memoryPeak int64
+)
+
+// NewFrame returns a frame with a single reference and room for size
+// bytes taken from the frame pool
+func NewFrame(size int) *Frame {
+	f := framePool.Get().(*Frame)
+	if cap(f.buf) < size {
+		// Leave some headroom as frame sizes vary with the scene
+		f.buf = make([]byte, size, size+size/4)
+	}
+
+	f.Data = f.buf[:size]
+	f.refs = 1
+	for used := atomic.AddInt64(&memory, int64(cap(f.buf))); ; {
+		peak := atomic.LoadInt64(&memoryPeak)
+		if used <= peak || atomic.CompareAndSwapInt64(&memoryPeak, peak, used) {
+			break
+		}
+	}
+	return f
+}
+
+// WrapFrame returns a frame with a single reference for data not
+// taken from the pool (privacy image, ...) replacing the given frame
+func WrapFrame(data []byte, replaces *Frame) *Frame {
+	return &Frame{Data: data, Seq: replaces.Seq, Time: replaces.Time, refs: 1}
+}
+
+// Memory returns the size of the pooled frame buffers currently
+// referenced by queues, buffers and running writes
+func Memory() int64 { return atomic.LoadInt64(&memory) }
+
+// PeakMemory returns the highest frame memory seen
+func PeakMemory() int64 { return atomic.LoadInt64(&memoryPeak) }
+
+// ReleaseFrames releases all given frames
+func ReleaseFrames(frames []*Frame) {
+	for _, f := range frames {
+		f.Release()
+	}
+}
+
+// Retain adds a reference to the frame
+func (f *Frame) Retain() *Frame {
+	atomic.AddInt32(&f.refs, 1)
+	return f
+}
+
+// Release drops a reference and returns the frame to the pool when
+// the last one was released
+func (f *Frame) Release() {
+	switch n := atomic.AddInt32(&f.refs, -1); {
+	case n < 0:
+		panic("frame released more often than retained")
+
+	case n == 0 && f.pooled:
+		atomic.AddInt64(&memory, -int64(cap(f.buf)))
+		f.Data, f.Seq, f.Time, f.BroadcastAt = nil, 0, time.Time{}, time.Time{}
+		framePool.Put(f)
+	}
+}
@@ -0,0 +1,217 @@
+package broadcast
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gofrs/uuid"
+)
+
+// MaxBacklog is the number of frames queued for a subscriber before
+// the oldest ones are dropped
+const MaxBacklog = 5
+
+type (
+	// Hub hands frames to its subscribers, each of them receiving its
+	// own reference
+	Hub struct {
+		// OnChange is called after a subscriber was added or removed
+		OnChange func(s *Subscriber, added bool)
+
+		lock        sync.RWMutex
+		subscribers map[string]*Subscriber
+	}
+
+	// Subscriber receives the frames of a hub. Its frame channel is
+	// never closed: done is closed when the subscriber is removed so
+	// neither side can run into a send on a closed channel.
+	Subscriber struct {
+		ID string
+		// Internal subscribers (recorder, ...) are not counted as
+		// clients
+		Internal bool
+		// Unthrottled subscribers receive all frames even when frames
+		// are withheld from other subscribers
+		Unthrottled bool
+
+		done     chan struct{}
+		doneOnce sync.Once
+		frames   chan *Frame
+	}
+)
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{subscribers: map[string]*Subscriber{}}
+}
+
+// ClientCount returns the number of current non-internal subscribers
+func (h *Hub) ClientCount() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var n int
+	for _, s := range h.subscribers {
+		if !s.Internal {
+			n++
+		}
+	}
+	return n
+}
+
+// Count returns the number of current subscribers
+func (h *Hub) Count() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return len(h.subscribers)
+}
+
+// DropOldest drops the oldest queued frame of every subscriber having
+// more than one frame queued and returns the number of dropped frames
+func (h *Hub) DropOldest() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var n int
+	for _, s := range h.subscribers {
+		if s.dropOldest() {
+			n++
+		}
+	}
+	return n
+}
+
+// NextFrame waits for the next frame using an internal subscriber and
+// returns it without copying, it must be released after use
+func (h *Hub) NextFrame(ctx context.Context) (*Frame, error) {
+	sub := h.Subscribe(uuid.Must(uuid.NewV4()).String(), true, false)
+	defer h.Unsubscribe(sub)
+
+	select {
+	case f := <-sub.Frames():
+		return f, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Send pushes the frame to all subscribers not being skipped and
+// returns the number of subscribers it was pushed to. The reference
+// of the caller is not consumed.
+func (h *Hub) Send(f *Frame, skip func(*Subscriber) bool) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	var n int
+	for _, s := range h.subscribers {
+		if skip != nil && skip(s) {
+			continue
+		}
+		s.push(f.Retain())
+		n++
+	}
+	return n
+}
+
+// Subscribe registers a new subscriber which must be passed to
+// Unsubscribe when no longer interested in frames
+func (h *Hub) Subscribe(id string, internal, unthrottled bool) *Subscriber {
+	s := &Subscriber{
+		ID:          id,
+		Internal:    internal,
+		Unthrottled: unthrottled,
+		done:        make(chan struct{}),
+		frames:      make(chan *Frame, MaxBacklog),
+	}
+
+	h.lock.Lock()
+	h.subscribers[id] = s
+	h.lock.Unlock()
+
+	if h.OnChange != nil {
+		h.OnChange(s, true)
+	}
+
+	return s
+}
+
+// Unsubscribe removes the subscriber, it is safe to call this multiple
+// times for the same subscriber
+func (h *Hub) Unsubscribe(s *Subscriber) {
+	h.lock.Lock()
+	_, ok := h.subscribers[s.ID]
+	delete(h.subscribers, s.ID)
+	h.lock.Unlock()
+
+	s.close()
+
+	if !ok {
+		return
+	}
+
+	// No frames are pushed after removal, release the ones not consumed
+	for drained := false; !drained; {
+		select {
+		case f := <-s.frames:
+			f.Release()
+		default:
+			drained = true
+		}
+	}
+
+	if h.OnChange != nil {
+		h.OnChange(s, false)
+	}
+}
+
+// Done is closed as soon as the subscriber was removed
+func (s *Subscriber) Done() <-chan struct{} { return s.done }
+
+// Frames yields the frames sent to the subscriber, each of them must be
+// released after use
+func (s *Subscriber) Frames() <-chan *Frame { return s.frames }
+
+func (s *Subscriber) close() { s.doneOnce.Do(func() { close(s.done) }) }
+
+// dropOldest removes the oldest queued frame if more than one frame is
+// queued and reports whether a frame was dropped
+func (s *Subscriber) dropOldest() bool {
+	if len(s.frames) < 2 {
+		return false
+	}
+
+	select {
+	case f := <-s.frames:
+		f.Release()
+		return true
+	default:
+		return false
+	}
+}
+
+// push enqueues the frame, dropping the oldest queued frame if the
+// subscriber did not keep up
+func (s *Subscriber) push(f *Frame) {
+	select {
+	case <-s.done:
+		f.Release()
+		return
+	default:
+	}
+
+	for {
+		select {
+		case s.frames <- f:
+			return
+		default:
+		}
+
+		select {
+		case old := <-s.frames:
+			// Oldest frame dropped, try again
+			old.Release()
+		default:
+		}
+	}
+}
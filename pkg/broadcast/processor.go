@@ -0,0 +1,92 @@
+package broadcast
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// FrameProcessor inspects, modifies or drops frames before they are
+	// handed to the subscribers. Process returns the frame to continue
+	// with: the given one, a replacement with its own reference (frames
+	// must not be modified in place, see WrapFrame) or nil to drop the
+	// frame. The reference to the given frame stays with the caller.
+	FrameProcessor interface {
+		Process(ctx context.Context, f *Frame) (*Frame, error)
+	}
+
+	// FrameProcessorFunc adapts a function to the FrameProcessor
+	FrameProcessorFunc func(ctx context.Context, f *Frame) (*Frame, error)
+
+	// Chain runs its processors in the order they were registered, the
+	// zero value is an empty chain
+	Chain struct {
+		lock       sync.RWMutex
+		processors []namedProcessor
+	}
+
+	namedProcessor struct {
+		name string
+		p    FrameProcessor
+	}
+)
+
+// Process calls the function
+func (fn FrameProcessorFunc) Process(ctx context.Context, f *Frame) (*Frame, error) {
+	return fn(ctx, f)
+}
+
+// Names returns the names of the registered processors in order
+func (c *Chain) Names() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	names := make([]string, len(c.processors))
+	for i, np := range c.processors {
+		names[i] = np.name
+	}
+	return names
+}
+
+// Register appends the processor to the chain
+func (c *Chain) Register(name string, p FrameProcessor) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.processors = append(c.processors, namedProcessor{name: name, p: p})
+}
+
+// Process passes the frame through all processors and returns the
+// resulting frame with its own reference or nil if it was dropped.
+// The reference to the given frame stays with the caller.
+func (c *Chain) Process(ctx context.Context, f *Frame) (*Frame, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	cur := f.Retain()
+	for _, np := range c.processors {
+		out, err := np.p.Process(ctx, cur)
+		if err != nil {
+			// Processors may return the given frame or a replacement
+			// along with the error, neither is handed out
+			cur.Release()
+			if out != nil && out != cur {
+				out.Release()
+			}
+			return nil, errors.Wrapf(err, "Frame processor %q failed", np.name)
+		}
+
+		if out != cur {
+			cur.Release()
+		}
+
+		if out == nil {
+			return nil, nil
+		}
+		cur = out
+	}
+
+	return cur, nil
+}
@@ -0,0 +1,68 @@
+package broadcast
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestChainProcessReleasesFrames(t *testing.T) {
+	var replacement *Frame
+
+	for name, fn := range map[string]FrameProcessorFunc{
+		"keep": func(_ context.Context, f *Frame) (*Frame, error) { return f, nil },
+		"drop": func(_ context.Context, f *Frame) (*Frame, error) { return nil, nil },
+		"replace": func(_ context.Context, f *Frame) (*Frame, error) {
+			replacement = NewFrame(len(f.Data))
+			return replacement, nil
+		},
+		"error": func(_ context.Context, f *Frame) (*Frame, error) { return nil, errors.New("failed") },
+		"error with input": func(_ context.Context, f *Frame) (*Frame, error) {
+			return f, errors.New("failed")
+		},
+		"error with replacement": func(_ context.Context, f *Frame) (*Frame, error) {
+			return NewFrame(len(f.Data)), errors.New("failed")
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			before := Memory()
+
+			var c Chain
+			c.Register(name, fn)
+
+			in := NewFrame(1024)
+			out, err := c.Process(context.Background(), in)
+
+			if out != nil {
+				out.Release()
+			}
+			if in.refs != 1 {
+				t.Errorf("caller reference to input frame not kept: %d references", in.refs)
+			}
+			in.Release()
+
+			if after := Memory(); after != before {
+				t.Errorf("frame memory leaked: %d bytes before, %d after", before, after)
+			}
+
+			switch name {
+			case "keep":
+				if out != in || err != nil {
+					t.Errorf("expected input frame, got %p (%v)", out, err)
+				}
+			case "replace":
+				if out != replacement || err != nil {
+					t.Errorf("expected replacement frame, got %p (%v)", out, err)
+				}
+			case "drop":
+				if out != nil || err != nil {
+					t.Errorf("expected dropped frame, got %p (%v)", out, err)
+				}
+			default:
+				if out != nil || err == nil {
+					t.Errorf("expected error without frame, got %p (%v)", out, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,820 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: camera.proto
+
+package camerapb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type StreamFramesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Width to downscale the frames to (0 for full size)
+	Width int32 `protobuf:"varint,1,opt,name=width,proto3" json:"width,omitempty"`
+	// Maximum frame rate to send at (0 for every frame)
+	Fps           float64 `protobuf:"fixed64,2,opt,name=fps,proto3" json:"fps,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamFramesRequest) Reset() {
+	*x = StreamFramesRequest{}
+	mi := &file_camera_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamFramesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamFramesRequest) ProtoMessage() {}
+
+func (x *StreamFramesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamFramesRequest.ProtoReflect.Descriptor instead.
+func (*StreamFramesRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StreamFramesRequest) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *StreamFramesRequest) GetFps() float64 {
+	if x != nil {
+		return x.Fps
+	}
+	return 0
+}
+
+type GetSnapshotRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Width to downscale the frame to (0 for full size)
+	Width         int32 `protobuf:"varint,1,opt,name=width,proto3" json:"width,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSnapshotRequest) Reset() {
+	*x = GetSnapshotRequest{}
+	mi := &file_camera_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSnapshotRequest) ProtoMessage() {}
+
+func (x *GetSnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSnapshotRequest.ProtoReflect.Descriptor instead.
+func (*GetSnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetSnapshotRequest) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+type Frame struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// JPEG encoded image
+	Jpeg []byte `protobuf:"bytes,1,opt,name=jpeg,proto3" json:"jpeg,omitempty"`
+	// Sequence number of the frame, gaps are dropped frames
+	Seq         uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	CaptureTime *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=capture_time,json=captureTime,proto3" json:"capture_time,omitempty"`
+	// Whether motion was active when the frame was sent
+	Motion        bool `protobuf:"varint,4,opt,name=motion,proto3" json:"motion,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Frame) Reset() {
+	*x = Frame{}
+	mi := &file_camera_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Frame) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Frame) ProtoMessage() {}
+
+func (x *Frame) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Frame.ProtoReflect.Descriptor instead.
+func (*Frame) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Frame) GetJpeg() []byte {
+	if x != nil {
+		return x.Jpeg
+	}
+	return nil
+}
+
+func (x *Frame) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Frame) GetCaptureTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CaptureTime
+	}
+	return nil
+}
+
+func (x *Frame) GetMotion() bool {
+	if x != nil {
+		return x.Motion
+	}
+	return false
+}
+
+type GetStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatusRequest) Reset() {
+	*x = GetStatusRequest{}
+	mi := &file_camera_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatusRequest) ProtoMessage() {}
+
+func (x *GetStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetStatusRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{3}
+}
+
+type Status struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Camera         string                 `protobuf:"bytes,1,opt,name=camera,proto3" json:"camera,omitempty"`
+	Capturing      bool                   `protobuf:"varint,2,opt,name=capturing,proto3" json:"capturing,omitempty"`
+	Clients        int32                  `protobuf:"varint,3,opt,name=clients,proto3" json:"clients,omitempty"`
+	FfmpegRestarts int64                  `protobuf:"varint,4,opt,name=ffmpeg_restarts,json=ffmpegRestarts,proto3" json:"ffmpeg_restarts,omitempty"`
+	Frames         int64                  `protobuf:"varint,5,opt,name=frames,proto3" json:"frames,omitempty"`
+	LastFrame      *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=last_frame,json=lastFrame,proto3" json:"last_frame,omitempty"`
+	Motion         bool                   `protobuf:"varint,7,opt,name=motion,proto3" json:"motion,omitempty"`
+	Privacy        bool                   `protobuf:"varint,8,opt,name=privacy,proto3" json:"privacy,omitempty"`
+	Quality        int32                  `protobuf:"varint,9,opt,name=quality,proto3" json:"quality,omitempty"`
+	Recording      bool                   `protobuf:"varint,10,opt,name=recording,proto3" json:"recording,omitempty"`
+	Version        string                 `protobuf:"bytes,11,opt,name=version,proto3" json:"version,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Status) Reset() {
+	*x = Status{}
+	mi := &file_camera_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Status) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Status) ProtoMessage() {}
+
+func (x *Status) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Status.ProtoReflect.Descriptor instead.
+func (*Status) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Status) GetCamera() string {
+	if x != nil {
+		return x.Camera
+	}
+	return ""
+}
+
+func (x *Status) GetCapturing() bool {
+	if x != nil {
+		return x.Capturing
+	}
+	return false
+}
+
+func (x *Status) GetClients() int32 {
+	if x != nil {
+		return x.Clients
+	}
+	return 0
+}
+
+func (x *Status) GetFfmpegRestarts() int64 {
+	if x != nil {
+		return x.FfmpegRestarts
+	}
+	return 0
+}
+
+func (x *Status) GetFrames() int64 {
+	if x != nil {
+		return x.Frames
+	}
+	return 0
+}
+
+func (x *Status) GetLastFrame() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastFrame
+	}
+	return nil
+}
+
+func (x *Status) GetMotion() bool {
+	if x != nil {
+		return x.Motion
+	}
+	return false
+}
+
+func (x *Status) GetPrivacy() bool {
+	if x != nil {
+		return x.Privacy
+	}
+	return false
+}
+
+func (x *Status) GetQuality() int32 {
+	if x != nil {
+		return x.Quality
+	}
+	return 0
+}
+
+func (x *Status) GetRecording() bool {
+	if x != nil {
+		return x.Recording
+	}
+	return false
+}
+
+func (x *Status) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+type Control struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Control name in the format used by v4l2-ctl
+	Key           string           `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Name          string           `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Type          string           `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	Value         int32            `protobuf:"varint,4,opt,name=value,proto3" json:"value,omitempty"`
+	Default       int32            `protobuf:"varint,5,opt,name=default,proto3" json:"default,omitempty"`
+	Min           int32            `protobuf:"varint,6,opt,name=min,proto3" json:"min,omitempty"`
+	Max           int32            `protobuf:"varint,7,opt,name=max,proto3" json:"max,omitempty"`
+	Step          int32            `protobuf:"varint,8,opt,name=step,proto3" json:"step,omitempty"`
+	Flags         []string         `protobuf:"bytes,9,rep,name=flags,proto3" json:"flags,omitempty"`
+	Menu          map[int32]string `protobuf:"bytes,10,rep,name=menu,proto3" json:"menu,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Control) Reset() {
+	*x = Control{}
+	mi := &file_camera_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Control) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Control) ProtoMessage() {}
+
+func (x *Control) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Control.ProtoReflect.Descriptor instead.
+func (*Control) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Control) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Control) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Control) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Control) GetValue() int32 {
+	if x != nil {
+		return x.Value
+	}
+	return 0
+}
+
+func (x *Control) GetDefault() int32 {
+	if x != nil {
+		return x.Default
+	}
+	return 0
+}
+
+func (x *Control) GetMin() int32 {
+	if x != nil {
+		return x.Min
+	}
+	return 0
+}
+
+func (x *Control) GetMax() int32 {
+	if x != nil {
+		return x.Max
+	}
+	return 0
+}
+
+func (x *Control) GetStep() int32 {
+	if x != nil {
+		return x.Step
+	}
+	return 0
+}
+
+func (x *Control) GetFlags() []string {
+	if x != nil {
+		return x.Flags
+	}
+	return nil
+}
+
+func (x *Control) GetMenu() map[int32]string {
+	if x != nil {
+		return x.Menu
+	}
+	return nil
+}
+
+type ListControlsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListControlsRequest) Reset() {
+	*x = ListControlsRequest{}
+	mi := &file_camera_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListControlsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListControlsRequest) ProtoMessage() {}
+
+func (x *ListControlsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListControlsRequest.ProtoReflect.Descriptor instead.
+func (*ListControlsRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{6}
+}
+
+type ListControlsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Controls      []*Control             `protobuf:"bytes,1,rep,name=controls,proto3" json:"controls,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListControlsResponse) Reset() {
+	*x = ListControlsResponse{}
+	mi := &file_camera_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListControlsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListControlsResponse) ProtoMessage() {}
+
+func (x *ListControlsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListControlsResponse.ProtoReflect.Descriptor instead.
+func (*ListControlsResponse) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListControlsResponse) GetControls() []*Control {
+	if x != nil {
+		return x.Controls
+	}
+	return nil
+}
+
+type SetControlsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Values        map[string]int32       `protobuf:"bytes,1,rep,name=values,proto3" json:"values,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetControlsRequest) Reset() {
+	*x = SetControlsRequest{}
+	mi := &file_camera_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetControlsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetControlsRequest) ProtoMessage() {}
+
+func (x *SetControlsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetControlsRequest.ProtoReflect.Descriptor instead.
+func (*SetControlsRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *SetControlsRequest) GetValues() map[string]int32 {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+type SetPrivacyRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Enabled       bool                   `protobuf:"varint,1,opt,name=enabled,proto3" json:"enabled,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetPrivacyRequest) Reset() {
+	*x = SetPrivacyRequest{}
+	mi := &file_camera_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetPrivacyRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetPrivacyRequest) ProtoMessage() {}
+
+func (x *SetPrivacyRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetPrivacyRequest.ProtoReflect.Descriptor instead.
+func (*SetPrivacyRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *SetPrivacyRequest) GetEnabled() bool {
+	if x != nil {
+		return x.Enabled
+	}
+	return false
+}
+
+type RestartCaptureRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestartCaptureRequest) Reset() {
+	*x = RestartCaptureRequest{}
+	mi := &file_camera_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestartCaptureRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestartCaptureRequest) ProtoMessage() {}
+
+func (x *RestartCaptureRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_camera_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestartCaptureRequest.ProtoReflect.Descriptor instead.
+func (*RestartCaptureRequest) Descriptor() ([]byte, []int) {
+	return file_camera_proto_rawDescGZIP(), []int{10}
+}
+
+var File_camera_proto protoreflect.FileDescriptor
+
+const file_camera_proto_rawDesc = "" +
+	"\n" +
+	"\fcamera.proto\x12\fcam2mjpeg.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"=\n" +
+	"\x13StreamFramesRequest\x12\x14\n" +
+	"\x05width\x18\x01 \x01(\x05R\x05width\x12\x10\n" +
+	"\x03fps\x18\x02 \x01(\x01R\x03fps\"*\n" +
+	"\x12GetSnapshotRequest\x12\x14\n" +
+	"\x05width\x18\x01 \x01(\x05R\x05width\"\x84\x01\n" +
+	"\x05Frame\x12\x12\n" +
+	"\x04jpeg\x18\x01 \x01(\fR\x04jpeg\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\x12=\n" +
+	"\fcapture_time\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\vcaptureTime\x12\x16\n" +
+	"\x06motion\x18\x04 \x01(\bR\x06motion\"\x12\n" +
+	"\x10GetStatusRequest\"\xd8\x02\n" +
+	"\x06Status\x12\x16\n" +
+	"\x06camera\x18\x01 \x01(\tR\x06camera\x12\x1c\n" +
+	"\tcapturing\x18\x02 \x01(\bR\tcapturing\x12\x18\n" +
+	"\aclients\x18\x03 \x01(\x05R\aclients\x12'\n" +
+	"\x0fffmpeg_restarts\x18\x04 \x01(\x03R\x0effmpegRestarts\x12\x16\n" +
+	"\x06frames\x18\x05 \x01(\x03R\x06frames\x129\n" +
+	"\n" +
+	"last_frame\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tlastFrame\x12\x16\n" +
+	"\x06motion\x18\a \x01(\bR\x06motion\x12\x18\n" +
+	"\aprivacy\x18\b \x01(\bR\aprivacy\x12\x18\n" +
+	"\aquality\x18\t \x01(\x05R\aquality\x12\x1c\n" +
+	"\trecording\x18\n" +
+	" \x01(\bR\trecording\x12\x18\n" +
+	"\aversion\x18\v \x01(\tR\aversion\"\xaf\x02\n" +
+	"\aControl\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x14\n" +
+	"\x05value\x18\x04 \x01(\x05R\x05value\x12\x18\n" +
+	"\adefault\x18\x05 \x01(\x05R\adefault\x12\x10\n" +
+	"\x03min\x18\x06 \x01(\x05R\x03min\x12\x10\n" +
+	"\x03max\x18\a \x01(\x05R\x03max\x12\x12\n" +
+	"\x04step\x18\b \x01(\x05R\x04step\x12\x14\n" +
+	"\x05flags\x18\t \x03(\tR\x05flags\x123\n" +
+	"\x04menu\x18\n" +
+	" \x03(\v2\x1f.cam2mjpeg.v1.Control.MenuEntryR\x04menu\x1a7\n" +
+	"\tMenuEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\x05R\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x15\n" +
+	"\x13ListControlsRequest\"I\n" +
+	"\x14ListControlsResponse\x121\n" +
+	"\bcontrols\x18\x01 \x03(\v2\x15.cam2mjpeg.v1.ControlR\bcontrols\"\x95\x01\n" +
+	"\x12SetControlsRequest\x12D\n" +
+	"\x06values\x18\x01 \x03(\v2,.cam2mjpeg.v1.SetControlsRequest.ValuesEntryR\x06values\x1a9\n" +
+	"\vValuesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x05R\x05value:\x028\x01\"-\n" +
+	"\x11SetPrivacyRequest\x12\x18\n" +
+	"\aenabled\x18\x01 \x01(\bR\aenabled\"\x17\n" +
+	"\x15RestartCaptureRequest2\x99\x04\n" +
+	"\x06Camera\x12H\n" +
+	"\fStreamFrames\x12!.cam2mjpeg.v1.StreamFramesRequest\x1a\x13.cam2mjpeg.v1.Frame0\x01\x12D\n" +
+	"\vGetSnapshot\x12 .cam2mjpeg.v1.GetSnapshotRequest\x1a\x13.cam2mjpeg.v1.Frame\x12A\n" +
+	"\tGetStatus\x12\x1e.cam2mjpeg.v1.GetStatusRequest\x1a\x14.cam2mjpeg.v1.Status\x12U\n" +
+	"\fListControls\x12!.cam2mjpeg.v1.ListControlsRequest\x1a\".cam2mjpeg.v1.ListControlsResponse\x12S\n" +
+	"\vSetControls\x12 .cam2mjpeg.v1.SetControlsRequest\x1a\".cam2mjpeg.v1.ListControlsResponse\x12C\n" +
+	"\n" +
+	"SetPrivacy\x12\x1f.cam2mjpeg.v1.SetPrivacyRequest\x1a\x14.cam2mjpeg.v1.Status\x12K\n" +
+	"\x0eRestartCapture\x12#.cam2mjpeg.v1.RestartCaptureRequest\x1a\x14.cam2mjpeg.v1.StatusB+Z)github.com/Luzifer/cam2mjpeg/pkg/camerapbb\x06proto3"
+
+var (
+	file_camera_proto_rawDescOnce sync.Once
+	file_camera_proto_rawDescData []byte
+)
+
+func file_camera_proto_rawDescGZIP() []byte {
+	file_camera_proto_rawDescOnce.Do(func() {
+		file_camera_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_camera_proto_rawDesc), len(file_camera_proto_rawDesc)))
+	})
+	return file_camera_proto_rawDescData
+}
+
+var file_camera_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_camera_proto_goTypes = []any{
+	(*StreamFramesRequest)(nil),   // 0: cam2mjpeg.v1.StreamFramesRequest
+	(*GetSnapshotRequest)(nil),    // 1: cam2mjpeg.v1.GetSnapshotRequest
+	(*Frame)(nil),                 // 2: cam2mjpeg.v1.Frame
+	(*GetStatusRequest)(nil),      // 3: cam2mjpeg.v1.GetStatusRequest
+	(*Status)(nil),                // 4: cam2mjpeg.v1.Status
+	(*Control)(nil),               // 5: cam2mjpeg.v1.Control
+	(*ListControlsRequest)(nil),   // 6: cam2mjpeg.v1.ListControlsRequest
+	(*ListControlsResponse)(nil),  // 7: cam2mjpeg.v1.ListControlsResponse
+	(*SetControlsRequest)(nil),    // 8: cam2mjpeg.v1.SetControlsRequest
+	(*SetPrivacyRequest)(nil),     // 9: cam2mjpeg.v1.SetPrivacyRequest
+	(*RestartCaptureRequest)(nil), // 10: cam2mjpeg.v1.RestartCaptureRequest
+	nil,                           // 11: cam2mjpeg.v1.Control.MenuEntry
+	nil,                           // 12: cam2mjpeg.v1.SetControlsRequest.ValuesEntry
+	(*timestamppb.Timestamp)(nil), // 13: google.protobuf.Timestamp
+}
+var file_camera_proto_depIdxs = []int32{
+	13, // 0: cam2mjpeg.v1.Frame.capture_time:type_name -> google.protobuf.Timestamp
+	13, // 1: cam2mjpeg.v1.Status.last_frame:type_name -> google.protobuf.Timestamp
+	11, // 2: cam2mjpeg.v1.Control.menu:type_name -> cam2mjpeg.v1.Control.MenuEntry
+	5,  // 3: cam2mjpeg.v1.ListControlsResponse.controls:type_name -> cam2mjpeg.v1.Control
+	12, // 4: cam2mjpeg.v1.SetControlsRequest.values:type_name -> cam2mjpeg.v1.SetControlsRequest.ValuesEntry
+	0,  // 5: cam2mjpeg.v1.Camera.StreamFrames:input_type -> cam2mjpeg.v1.StreamFramesRequest
+	1,  // 6: cam2mjpeg.v1.Camera.GetSnapshot:input_type -> cam2mjpeg.v1.GetSnapshotRequest
+	3,  // 7: cam2mjpeg.v1.Camera.GetStatus:input_type -> cam2mjpeg.v1.GetStatusRequest
+	6,  // 8: cam2mjpeg.v1.Camera.ListControls:input_type -> cam2mjpeg.v1.ListControlsRequest
+	8,  // 9: cam2mjpeg.v1.Camera.SetControls:input_type -> cam2mjpeg.v1.SetControlsRequest
+	9,  // 10: cam2mjpeg.v1.Camera.SetPrivacy:input_type -> cam2mjpeg.v1.SetPrivacyRequest
+	10, // 11: cam2mjpeg.v1.Camera.RestartCapture:input_type -> cam2mjpeg.v1.RestartCaptureRequest
+	2,  // 12: cam2mjpeg.v1.Camera.StreamFrames:output_type -> cam2mjpeg.v1.Frame
+	2,  // 13: cam2mjpeg.v1.Camera.GetSnapshot:output_type -> cam2mjpeg.v1.Frame
+	4,  // 14: cam2mjpeg.v1.Camera.GetStatus:output_type -> cam2mjpeg.v1.Status
+	7,  // 15: cam2mjpeg.v1.Camera.ListControls:output_type -> cam2mjpeg.v1.ListControlsResponse
+	7,  // 16: cam2mjpeg.v1.Camera.SetControls:output_type -> cam2mjpeg.v1.ListControlsResponse
+	4,  // 17: cam2mjpeg.v1.Camera.SetPrivacy:output_type -> cam2mjpeg.v1.Status
+	4,  // 18: cam2mjpeg.v1.Camera.RestartCapture:output_type -> cam2mjpeg.v1.Status
+	12, // [12:19] is the sub-list for method output_type
+	5,  // [5:12] is the sub-list for method input_type
+	5,  // [5:5] is the sub-list for extension type_name
+	5,  // [5:5] is the sub-list for extension extendee
+	0,  // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_camera_proto_init() }
+func file_camera_proto_init() {
+	if File_camera_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_camera_proto_rawDesc), len(file_camera_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_camera_proto_goTypes,
+		DependencyIndexes: file_camera_proto_depIdxs,
+		MessageInfos:      file_camera_proto_msgTypes,
+	}.Build()
+	File_camera_proto = out.File
+	file_camera_proto_goTypes = nil
+	file_camera_proto_depIdxs = nil
+}
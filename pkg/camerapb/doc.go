@@ -0,0 +1,5 @@
+// Package camerapb contains the gRPC service of cam2mjpeg streaming
+// frames with their metadata and exposing the status and controls
+package camerapb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative camera.proto
@@ -0,0 +1,95 @@
+package capture
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/pkg/errors"
+)
+
+// stopTimeout is the time ffmpeg gets to exit cleanly before being
+// killed
+const stopTimeout = 5 * time.Second
+
+// Options describe the capture of a video4linux2 device
+type Options struct {
+	Device    string
+	FrameRate int
+	Height    int
+	// Input replaces the device input arguments (TestPatternInput, ...)
+	Input []string
+	// Quality is the MJPEG quality (2..31, lower is better)
+	Quality int
+	Width   int
+}
+
+// Args builds the ffmpeg arguments to capture with the options and to
+// write the MJPEG frames to stdout, extra output arguments are added
+// in front of the output
+func Args(o Options, extra ...string) []string {
+	args := o.Input
+	if args == nil {
+		args = []string{
+			"-f", "video4linux2",
+			"-input_format", "yuyv422",
+			"-s", fmt.Sprintf("%dx%d", o.Width, o.Height),
+			"-r", strconv.Itoa(o.FrameRate),
+			"-i", o.Device,
+		}
+	}
+
+	args = append(append([]string{}, args...),
+		"-fflags", "nobuffer",
+		"-c:v", "mjpeg",
+		"-q:v", strconv.Itoa(o.Quality),
+	)
+	args = append(args, extra...)
+
+	return append(args,
+		"-boundary_tag", "ffmpeg",
+		"-f", "image2pipe",
+		"-")
+}
+
+// TestPatternInput returns the input arguments for the ffmpeg test
+// pattern source
+func TestPatternInput(width, height, rate int) []string {
+	return []string{"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%dx%d:rate=%d", width, height, rate)}
+}
+
+// Stream spawns ffmpeg capturing with the options and passes the
+// frames, numbered from 1 and stamped with their capture time, to
+// onFrame until the context is cancelled or ffmpeg exits
+func Stream(ctx context.Context, o Options, onFrame func(*broadcast.Frame)) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", Args(o)...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = stopTimeout
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdout pipe")
+	}
+
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+
+	var seq uint64
+	err = Splitter{OnFrame: func(f *broadcast.Frame) {
+		seq++
+		f.Seq, f.Time = seq, time.Now()
+		onFrame(f)
+	}}.Run(out)
+	if werr := cmd.Wait(); ctx.Err() == nil && werr != nil {
+		return errors.Wrap(werr, "ffmpeg failed")
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
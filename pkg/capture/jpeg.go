@@ -0,0 +1,150 @@
+// Package capture spawns ffmpeg to capture a video device and splits
+// its MJPEG output into frames.
+package capture
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	markerSOI = 0xd8
+	markerEOI = 0xd9
+	markerSOS = 0xda
+	markerTEM = 0x01
+	markerRST = 0xd0 // RST0..RST7
+)
+
+// ErrIncomplete is returned while more data is required to find the
+// end of the frame
+var ErrIncomplete = errors.New("JPEG data incomplete")
+
+// Scanner walks the segments of the JPEG image at the start of
+// data to find its end. Segment payloads (APPn, COM, ...) are skipped
+// by their length so an EOI inside an embedded thumbnail does not end
+// the frame early. Scanning resumes where the last call stopped, so
+// data growing while the frame is read is only walked once.
+type Scanner struct {
+	// pos is the offset of the next marker to parse or (in entropy
+	// coded data) the next byte to check
+	pos     int
+	entropy bool
+}
+
+// FrameLength returns the number of bytes of the JPEG image at the
+// start of data up to and including its EOI marker
+func FrameLength(data []byte) (int, error) {
+	var s Scanner
+	return s.Scan(data)
+}
+
+// Reset prepares the scanner for the next frame
+func (s *Scanner) Reset() { *s = Scanner{} }
+
+// Scan continues walking the image, data must start with the same
+// frame on every call until the scanner is reset. It returns the number
+// of bytes up to and including the EOI marker or ErrIncomplete
+// when more data is required.
+func (s *Scanner) Scan(data []byte) (int, error) {
+	if s.pos == 0 {
+		if len(data) < 2 {
+			return 0, ErrIncomplete
+		}
+
+		if data[0] != 0xff || data[1] != markerSOI {
+			return 0, errors.New("Data does not start with SOI marker")
+		}
+		s.pos = 2
+	}
+
+	for {
+		if s.entropy {
+			// The scan header is followed by entropy-coded data which
+			// ends at the next "real" marker
+			pos, ok := skipEntropyCodedData(data, s.pos)
+			s.pos = pos
+			if !ok {
+				return 0, ErrIncomplete
+			}
+			s.entropy = false
+		}
+
+		// Parse into pos and only store it once the marker is complete
+		// to parse it again when more data arrived
+		pos := s.pos
+		if pos >= len(data) {
+			return 0, ErrIncomplete
+		}
+		if data[pos] != 0xff {
+			return 0, errors.Errorf("Expected marker at offset %d", pos)
+		}
+
+		// Markers may be preceded by any number of fill bytes
+		for pos < len(data) && data[pos] == 0xff {
+			pos++
+		}
+		if pos >= len(data) {
+			return 0, ErrIncomplete
+		}
+
+		marker := data[pos]
+		pos++
+
+		switch {
+		case marker == markerEOI:
+			return pos, nil
+
+		case marker == markerSOI, marker == 0x00:
+			return 0, errors.Errorf("Unexpected marker 0x%02x at offset %d", marker, pos-1)
+
+		case marker == markerTEM, isRestartMarker(marker):
+			// Standalone markers without payload
+			s.pos = pos
+			continue
+		}
+
+		if pos+2 > len(data) {
+			return 0, ErrIncomplete
+		}
+
+		segLen := int(data[pos])<<8 | int(data[pos+1])
+		if segLen < 2 {
+			return 0, errors.Errorf("Invalid segment length %d at offset %d", segLen, pos)
+		}
+
+		// The segment payload might not be read yet: continuing behind
+		// it is fine as the length is known
+		s.pos, s.entropy = pos+segLen, marker == markerSOS
+	}
+}
+
+// skipEntropyCodedData returns the offset of the first marker after pos
+// which is neither a stuffed 0x00 byte nor a restart marker
+func skipEntropyCodedData(data []byte, pos int) (int, bool) {
+	for pos+1 < len(data) {
+		// The last byte is checked once the byte after it is known
+		i := bytes.IndexByte(data[pos:len(data)-1], 0xff)
+		if i < 0 {
+			return len(data) - 1, false
+		}
+		pos += i
+
+		switch next := data[pos+1]; {
+		case next == 0x00, isRestartMarker(next):
+			// Stuffed byte or restart marker: still entropy-coded data
+			pos += 2
+
+		case next == 0xff:
+			// Fill byte, the marker follows
+			pos++
+
+		default:
+			return pos, true
+		}
+	}
+
+	return pos, false
+}
+
+func isRestartMarker(m byte) bool { return m >= markerRST && m <= markerRST+7 }
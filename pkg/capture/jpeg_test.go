@@ -0,0 +1,254 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/pkg/errors"
+)
+
+// chunkReader returns at most n bytes per read like a pipe does
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (c chunkReader) Read(p []byte) (int, error) {
+	return c.r.Read(p[:min(len(p), c.n)])
+}
+
+// testJPEG assembles a minimal JPEG with the given APP1 payload and
+// entropy-coded data
+func testJPEG(app1, entropy []byte) []byte {
+	img := []byte{0xff, markerSOI}
+	if app1 != nil {
+		img = append(img, 0xff, 0xe1, byte((len(app1)+2)>>8), byte(len(app1)+2))
+		img = append(img, app1...)
+	}
+	img = append(img, 0xff, 0xdb, 0x00, 0x43)
+	img = append(img, make([]byte, 0x41)...)
+	img = append(img, 0xff, markerSOS, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x3f, 0x00)
+	img = append(img, entropy...)
+	return append(img, 0xff, markerEOI)
+}
+
+// testEntropy returns entropy-coded data of the given size free of
+// markers
+func testEntropy(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 0xfe)
+	}
+	return data
+}
+
+func TestFrameLength(t *testing.T) {
+	thumbnail := append([]byte("Exif\x00\x00"), testJPEG(nil, testEntropy(16))...)
+
+	for name, img := range map[string][]byte{
+		"plain":     testJPEG(nil, testEntropy(64)),
+		"thumbnail": testJPEG(thumbnail, testEntropy(64)),
+		"stuffed":   testJPEG(nil, []byte{0x12, 0xff, 0x00, 0x34, 0xff, 0x00, 0xff, 0x00, 0x56}),
+		"restart":   testJPEG(nil, []byte{0x12, 0xff, 0xd0, 0x34, 0xff, 0xd7, 0x56}),
+		"fill":      testJPEG(nil, append(testEntropy(8), 0xff, 0xff)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Data following the frame must not be part of it
+			data := append(bytes.Clone(img), 0xff, markerSOI, 0xff)
+
+			n, err := FrameLength(data)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if n != len(img) {
+				t.Errorf("expected length %d, got %d", len(img), n)
+			}
+
+			for i := range len(img) {
+				if _, err := FrameLength(img[:i]); err != ErrIncomplete {
+					t.Fatalf("expected incomplete frame for %d of %d bytes, got %v", i, len(img), err)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameLengthInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"garbage":        {0x00, 0x01, 0xff, markerSOI},
+		"nested soi":     {0xff, markerSOI, 0xff, markerSOI},
+		"segment length": {0xff, markerSOI, 0xff, 0xe0, 0x00, 0x01},
+		"no marker":      {0xff, markerSOI, 0x12, 0x34},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := FrameLength(data); err == nil || err == ErrIncomplete {
+				t.Errorf("expected error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScannerChunked(t *testing.T) {
+	img := testJPEG(append([]byte("Exif\x00\x00"), testJPEG(nil, testEntropy(16))...), testEntropy(256))
+
+	for _, chunk := range []int{1, 2, 3, 7, 64} {
+		var (
+			s   Scanner
+			n   int
+			err = ErrIncomplete
+		)
+
+		// Pass the data as growing prefixes as the splitter does
+		for end := chunk; err == ErrIncomplete && end < len(img)+chunk; end += chunk {
+			n, err = s.Scan(img[:min(end, len(img))])
+		}
+
+		if err != nil || n != len(img) {
+			t.Errorf("chunk size %d: expected length %d, got %d (%v)", chunk, len(img), n, err)
+		}
+	}
+}
+
+func TestSplitter(t *testing.T) {
+	var (
+		thumb   = testJPEG(append([]byte("Exif\x00\x00"), testJPEG(nil, testEntropy(16))...), testEntropy(128))
+		stuffed = testJPEG(nil, []byte{0x12, 0xff, 0x00, 0x34, 0xff, 0xd3, 0x56})
+		plain   = testJPEG(nil, testEntropy(512))
+	)
+
+	var stream []byte
+	stream = append(stream, []byte("garbage\xff")...)
+	stream = append(stream, thumb...)
+	stream = append(stream, stuffed...)
+	// Truncated frame followed by the start of the next one
+	stream = append(stream, plain[:len(plain)/2]...)
+	stream = append(stream, plain...)
+	// Truncated frame at the end of the stream is never emitted
+	stream = append(stream, thumb[:len(thumb)-1]...)
+
+	for _, chunk := range []int{1, 5, 4096} {
+		var (
+			frames  [][]byte
+			skipped = map[error]int{}
+		)
+
+		err := Splitter{
+			OnFrame: func(f *broadcast.Frame) {
+				frames = append(frames, bytes.Clone(f.Data))
+				f.Release()
+			},
+			OnSkip: func(n int, reason error) {
+				switch reason {
+				case ErrFrameTooLarge, ErrOutsideFrame:
+					skipped[reason] += n
+				default:
+					skipped[nil] += n
+				}
+			},
+		}.Run(chunkReader{bytes.NewReader(stream), chunk})
+
+		if errors.Cause(err) != io.EOF {
+			t.Errorf("chunk size %d: expected EOF, got %v", chunk, err)
+		}
+
+		expected := [][]byte{thumb, stuffed, plain}
+		if len(frames) != len(expected) {
+			t.Fatalf("chunk size %d: expected %d frames, got %d", chunk, len(expected), len(frames))
+		}
+		for i := range expected {
+			if !bytes.Equal(frames[i], expected[i]) {
+				t.Errorf("chunk size %d: frame %d differs", chunk, i)
+			}
+		}
+
+		// The garbage and the rest of the truncated frame behind its SOI
+		if n := skipped[ErrOutsideFrame]; n != len("garbage\xff")+len(plain)/2-2 {
+			t.Errorf("chunk size %d: unexpected %d bytes skipped outside frames", chunk, n)
+		}
+		if skipped[nil] != 2 {
+			t.Errorf("chunk size %d: expected SOI of truncated frame skipped, got %d bytes", chunk, skipped[nil])
+		}
+	}
+}
+
+func TestSplitterFrameTooLarge(t *testing.T) {
+	var (
+		large = testJPEG(nil, testEntropy(4096))
+		small = testJPEG(nil, testEntropy(64))
+	)
+
+	var frames int
+	Splitter{
+		MaxFrameSize: 1024,
+		OnFrame: func(f *broadcast.Frame) {
+			frames++
+			f.Release()
+		},
+	}.Run(bytes.NewReader(append(append(large, small...), small...)))
+
+	if frames != 2 {
+		t.Errorf("expected 2 frames, got %d", frames)
+	}
+}
+
+// indexSplit is the former splitting loop searching for the EOI marker
+// with bytes.Index, kept as baseline for BenchmarkSplitter
+func indexSplit(r io.Reader, onFrame func([]byte)) error {
+	var (
+		br, bw    int
+		buf       = make([]byte, InitialBufferSize)
+		endOfJPEG = []byte{0xff, markerEOI}
+	)
+
+	for {
+		if br > 0 {
+			copy(buf, buf[br:bw])
+			bw -= br
+			br = 0
+		}
+
+		n, err := r.Read(buf[bw:])
+		if err != nil {
+			return err
+		}
+		bw += n
+
+		for eoj := bytes.Index(buf[br:bw], endOfJPEG); eoj >= 0; eoj = bytes.Index(buf[br:bw], endOfJPEG) {
+			eoj += len(endOfJPEG)
+			img := make([]byte, eoj)
+			copy(img, buf[br:br+eoj])
+			br += eoj
+
+			if bytes.HasPrefix(img, beginOfJPEG) {
+				onFrame(img)
+			}
+		}
+	}
+}
+
+// benchmarkStream returns 100 frames of 64KiB entropy-coded data
+func benchmarkStream() []byte {
+	img := testJPEG(nil, testEntropy(64*1024))
+	return bytes.Repeat(img, 100)
+}
+
+func BenchmarkSplitter(b *testing.B) {
+	stream := benchmarkStream()
+	b.SetBytes(int64(len(stream)))
+
+	for b.Loop() {
+		Splitter{OnFrame: func(f *broadcast.Frame) { f.Release() }}.
+			Run(chunkReader{bytes.NewReader(stream), 64 * 1024})
+	}
+}
+
+func BenchmarkSplitterIndex(b *testing.B) {
+	stream := benchmarkStream()
+	b.SetBytes(int64(len(stream)))
+
+	for b.Loop() {
+		indexSplit(chunkReader{bytes.NewReader(stream), 64 * 1024}, func([]byte) {})
+	}
+}
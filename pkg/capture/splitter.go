@@ -0,0 +1,157 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxFrameSize is used when the splitter has no maximum
+	DefaultMaxFrameSize = 32 * 1024 * 1024
+	// InitialBufferSize is the size of the read buffer, it grows when
+	// frames are bigger
+	InitialBufferSize = 1024 * 1024
+)
+
+var (
+	// ErrFrameTooLarge is passed to OnSkip when a frame exceeded the
+	// maximum frame size
+	ErrFrameTooLarge = errors.New("Frame exceeds maximum frame size")
+	// ErrOutsideFrame is passed to OnSkip for data found between frames
+	ErrOutsideFrame = errors.New("Data outside JPEG frame")
+
+	beginOfJPEG = []byte{0xff, markerSOI}
+)
+
+// Splitter extracts the JPEG frames from a MJPEG byte stream
+type Splitter struct {
+	// MaxFrameSize caps the read buffer, bigger frames are skipped
+	MaxFrameSize int
+	// OnFrame receives every valid frame passing on the reference
+	OnFrame func(*broadcast.Frame)
+	// OnSkip is called when data is skipped to resynchronize with the
+	// reason (ErrFrameTooLarge, ErrOutsideFrame or the JPEG error)
+	OnSkip func(n int, reason error)
+}
+
+// Run reads the stream and passes the frames to OnFrame until reading
+// fails
+func (s Splitter) Run(r io.Reader) error {
+	maxSize := s.MaxFrameSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxFrameSize
+	}
+
+	skip := func(n int, reason error) {
+		if s.OnSkip != nil {
+			s.OnSkip(n, reason)
+		}
+	}
+
+	var (
+		br, bw int
+		buf    = make([]byte, min(InitialBufferSize, maxSize))
+		// scan holds the progress of validating the frame at br
+		scan Scanner
+	)
+
+	for {
+		if bw == len(buf) && br > 0 {
+			// Make room by sliding the remains to the beginning, the
+			// scanner progress is relative to the frame start
+			copy(buf, buf[br:bw])
+			bw -= br
+			br = 0
+		}
+
+		if bw == len(buf) {
+			if len(buf) < maxSize {
+				// Frame does not fit into the buffer, give it more room
+				nb := make([]byte, min(len(buf)*2, maxSize))
+				copy(nb, buf[:bw])
+				buf = nb
+			} else {
+				// Frame exceeds the maximum size: drop everything up to
+				// the start of the next frame to get back in sync
+				n := bw
+				if soi := bytes.Index(buf[1:bw], beginOfJPEG); soi >= 0 {
+					n = soi + 1
+				}
+				skip(n, ErrFrameTooLarge)
+
+				copy(buf, buf[n:bw])
+				bw -= n
+				scan.Reset()
+			}
+		}
+
+		// Fill buffer
+		n, err := r.Read(buf[bw:])
+		if err != nil {
+			return errors.Wrap(err, "Unable to read from output")
+		}
+		bw += n
+
+		if n == 0 {
+			// Nothing read, try again
+			continue
+		}
+
+		// Extract as many images as possible before next read
+		for br < bw {
+			if !bytes.HasPrefix(buf[br:bw], beginOfJPEG) {
+				// Not at the start of a frame: skip to the next SOI
+				soi := bytes.Index(buf[br:bw], beginOfJPEG)
+				if soi < 0 {
+					soi = bw - br
+					if buf[bw-1] == 0xff {
+						// Might be the first byte of the next SOI
+						soi--
+					}
+				}
+
+				if soi == 0 {
+					// Wait for more data
+					break
+				}
+
+				skip(soi, ErrOutsideFrame)
+				br += soi
+				continue
+			}
+
+			size, err := scan.Scan(buf[br:bw])
+			if err == ErrIncomplete {
+				// Wait for more data
+				break
+			}
+			scan.Reset()
+
+			if err != nil {
+				// Skip the SOI to resynchronize on the next frame
+				skip(len(beginOfJPEG), err)
+				br += len(beginOfJPEG)
+				continue
+			}
+
+			img := broadcast.NewFrame(size)
+			copy(img.Data, buf[br:br+size])
+			br += size
+
+			if s.OnFrame != nil {
+				s.OnFrame(img)
+			} else {
+				img.Release()
+			}
+		}
+
+		if br == bw {
+			// Everything was consumed: continue at the start of the
+			// buffer without moving data
+			br, bw = 0, 0
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package client connects to a cam2mjpeg server and receives the
+// frames of its MJPEG stream
+package client
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxFrameSize      = 32 * 1024 * 1024
+	defaultMaxReconnectDelay = 30 * time.Second
+	defaultReconnectDelay    = time.Second
+	defaultStreamPath        = "/mjpeg"
+)
+
+type (
+	// Client receives frames from a cam2mjpeg server, the zero values
+	// of the optional fields select the defaults
+	Client struct {
+		// BaseURL of the server (e.g. http://camera:3000)
+		BaseURL string
+		// HTTPClient to connect with (default: http.DefaultClient), it
+		// must not have a timeout as streams are read indefinitely
+		HTTPClient *http.Client
+		// MaxFrameSize rejects bigger frames (default: 32MiB)
+		MaxFrameSize int
+		// OnError is called with the errors of failed connections before
+		// reconnecting (optional)
+		OnError func(error)
+		// ReconnectDelay is the delay before the first reconnect, it is
+		// doubled up to MaxReconnectDelay while connections keep failing
+		ReconnectDelay    time.Duration
+		MaxReconnectDelay time.Duration
+		// StreamPath is the path of the stream (default: /mjpeg)
+		StreamPath string
+		// Token is sent as bearer token if set
+		Token string
+	}
+
+	// Frame is a JPEG received from the stream. Seq and CaptureTime are
+	// only set when the server sends latency headers.
+	Frame struct {
+		CaptureTime time.Time
+		Data        []byte
+		Received    time.Time
+		Seq         uint64
+	}
+)
+
+// New creates a client for the server at the base URL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// Decode decodes the JPEG of the frame
+func (f Frame) Decode() (image.Image, error) {
+	img, err := jpeg.Decode(bytes.NewReader(f.Data))
+	return img, errors.Wrap(err, "Unable to decode frame")
+}
+
+// Frames streams the frames into the returned channel, reconnecting
+// when the connection fails, until the context is cancelled. The
+// channel is closed afterwards.
+func (c *Client) Frames(ctx context.Context) <-chan Frame {
+	out := make(chan Frame)
+
+	go func() {
+		defer close(out)
+
+		delay := c.reconnectDelay()
+		for {
+			connected := false
+			err := c.Stream(ctx, func(f Frame) error {
+				connected = true
+				select {
+				case out <- f:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if ctx.Err() != nil {
+				return
+			}
+
+			if c.OnError != nil {
+				c.OnError(err)
+			}
+
+			if connected {
+				// The connection worked before, start over with the backoff
+				delay = c.reconnectDelay()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			if delay *= 2; delay > c.maxReconnectDelay() {
+				delay = c.maxReconnectDelay()
+			}
+		}
+	}()
+
+	return out
+}
+
+// Snapshot fetches a single JPEG from the server
+func (c *Client) Snapshot(ctx context.Context) ([]byte, error) {
+	resp, err := c.get(ctx, "/snapshot.jpg")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(c.maxFrameSize())+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read snapshot")
+	}
+	if len(data) > c.maxFrameSize() {
+		return nil, errors.New("Snapshot exceeds maximum frame size")
+	}
+
+	return data, nil
+}
+
+// Stream connects once and calls fn for every frame until the stream
+// ends, the context is cancelled or fn returns an error
+func (c *Client) Stream(ctx context.Context, fn func(Frame) error) error {
+	path := c.StreamPath
+	if path == "" {
+		path = defaultStreamPath
+	}
+
+	resp, err := c.get(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return errors.Errorf("Unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("Stream ended")
+			}
+			return errors.Wrap(err, "Unable to read part")
+		}
+
+		// Closing the part waits for the next boundary, NextPart discards
+		// the rest of the part after the frame was handled
+		f, err := c.readFrame(part)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(f); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(c.BaseURL, "/")+path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to execute request")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("Unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) readFrame(part *multipart.Part) (Frame, error) {
+	f := Frame{Received: time.Now()}
+
+	if v := part.Header.Get("X-Frame-Seq"); v != "" {
+		f.Seq, _ = strconv.ParseUint(v, 10, 64)
+	}
+	if v := part.Header.Get("X-Capture-Time"); v != "" {
+		if ts, err := strconv.ParseFloat(v, 64); err == nil {
+			f.CaptureTime = time.UnixMicro(int64(ts * 1e6))
+		}
+	}
+
+	if l, err := strconv.Atoi(part.Header.Get("Content-Length")); err == nil && l > 0 {
+		if l > c.maxFrameSize() {
+			return f, errors.New("Frame exceeds maximum frame size")
+		}
+
+		// The part only ends with the boundary of the next frame, reading
+		// the announced length does not wait for it
+		f.Data = make([]byte, l)
+		_, err = io.ReadFull(part, f.Data)
+		return f, errors.Wrap(err, "Unable to read frame")
+	}
+
+	buf := new(bytes.Buffer)
+	n, err := buf.ReadFrom(io.LimitReader(part, int64(c.maxFrameSize())+1))
+	if err != nil {
+		return f, errors.Wrap(err, "Unable to read frame")
+	}
+	if n > int64(c.maxFrameSize()) {
+		return f, errors.New("Frame exceeds maximum frame size")
+	}
+
+	f.Data = buf.Bytes()
+	return f, nil
+}
+
+func (c *Client) maxFrameSize() int {
+	if c.MaxFrameSize > 0 {
+		return c.MaxFrameSize
+	}
+	return defaultMaxFrameSize
+}
+
+func (c *Client) maxReconnectDelay() time.Duration {
+	if c.MaxReconnectDelay > 0 {
+		return c.MaxReconnectDelay
+	}
+	return defaultMaxReconnectDelay
+}
+
+func (c *Client) reconnectDelay() time.Duration {
+	if c.ReconnectDelay > 0 {
+		return c.ReconnectDelay
+	}
+	return defaultReconnectDelay
+}
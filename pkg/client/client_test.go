@@ -0,0 +1,182 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/Luzifer/cam2mjpeg/pkg/httpserv"
+)
+
+// newTestServer serves the hub with the latency headers of cam2mjpeg
+// and checks the bearer token
+func newTestServer(hub *broadcast.Hub, token string) *httptest.Server {
+	h := httpserv.NewHandler(hub, httpserv.Options{Hooks: httpserv.Hooks{
+		OnPart: func(_ *http.Request, f *broadcast.Frame, part *httpserv.Part) error {
+			part.Header.Add("X-Frame-Seq", strconv.FormatUint(f.Seq, 10))
+			part.Header.Add("X-Capture-Time", fmt.Sprintf("%.6f", float64(f.Time.UnixMicro())/1e6))
+			return nil
+		},
+	}})
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+// sendFrames pushes frames of the given sizes to the hub once a client
+// subscribed until the context is cancelled
+func sendFrames(ctx context.Context, hub *broadcast.Hub, captured time.Time, sizes ...int) {
+	for hub.Count() == 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	for i, size := range sizes {
+		f := broadcast.NewFrame(size)
+		copy(f.Data, strings.Repeat(strconv.Itoa(i), size))
+		f.Seq, f.Time = uint64(i+1), captured
+		hub.Send(f, nil)
+		f.Release()
+	}
+}
+
+func TestStream(t *testing.T) {
+	var (
+		hub      = broadcast.NewHub()
+		captured = time.UnixMicro(time.Now().UnixMicro())
+		srv      = newTestServer(hub, "secret")
+	)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go sendFrames(ctx, hub, captured, 3, 5)
+
+	c := New(srv.URL)
+	c.Token = "secret"
+
+	var frames []Frame
+	err := c.Stream(ctx, func(f Frame) error {
+		if frames = append(frames, f); len(frames) == 2 {
+			return context.Canceled
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected stream to end with error of callback, got %v", err)
+	}
+
+	for i, expected := range []string{"000", "11111"} {
+		f := frames[i]
+		if string(f.Data) != expected {
+			t.Errorf("frame %d: expected data %q, got %q", i, expected, f.Data)
+		}
+		if f.Seq != uint64(i+1) {
+			t.Errorf("frame %d: expected seq %d, got %d", i, i+1, f.Seq)
+		}
+		if !f.CaptureTime.Equal(captured) {
+			t.Errorf("frame %d: expected capture time %s, got %s", i, captured, f.CaptureTime)
+		}
+		if f.Received.IsZero() {
+			t.Errorf("frame %d: expected receive time", i)
+		}
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	hub := broadcast.NewHub()
+	srv := newTestServer(hub, "secret")
+	defer srv.Close()
+
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+	}))
+	defer plain.Close()
+
+	for name, tc := range map[string]struct {
+		client   *Client
+		expected string
+	}{
+		"content type":   {&Client{BaseURL: plain.URL}, `Unexpected content type "image/jpeg"`},
+		"frame size":     {&Client{BaseURL: srv.URL, MaxFrameSize: 4, Token: "secret"}, "Frame exceeds maximum frame size"},
+		"missing token":  {&Client{BaseURL: srv.URL}, "Unexpected HTTP status 401"},
+		"unknown stream": {&Client{BaseURL: srv.URL, StreamPath: "/unknown", Token: "secret"}, "Unexpected HTTP status 404"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			go sendFrames(ctx, hub, time.Now(), 5)
+
+			err := tc.client.Stream(ctx, func(Frame) error { return nil })
+			if err == nil || err.Error() != tc.expected {
+				t.Errorf("expected error %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	hub := broadcast.NewHub()
+	srv := newTestServer(hub, "secret")
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go sendFrames(ctx, hub, time.Now(), 4)
+
+	data, err := (&Client{BaseURL: srv.URL + "/", Token: "secret"}).Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("unable to fetch snapshot: %s", err)
+	}
+	if string(data) != "0000" {
+		t.Errorf("expected snapshot %q, got %q", "0000", data)
+	}
+}
+
+func TestFramesReconnect(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// Every connection ends after a single frame
+		mw := httpserv.NewMJPEGWriter(w, w)
+		httpserv.WritePart(mw, []byte(strconv.Itoa(int(requests.Add(1)))), nil)
+		mw.Close()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var errs []error
+	c := &Client{BaseURL: srv.URL, OnError: func(err error) { errs = append(errs, err) }, ReconnectDelay: time.Millisecond}
+
+	frames := c.Frames(ctx)
+	for _, expected := range []string{"1", "2", "3"} {
+		if f := <-frames; string(f.Data) != expected {
+			t.Errorf("expected frame %q, got %q", expected, f.Data)
+		}
+	}
+	cancel()
+
+	// The channel is closed after the context was cancelled
+	for range frames {
+	}
+
+	if len(errs) < 2 || errs[0].Error() != "Stream ended" {
+		t.Errorf("expected the ended streams to be reported, got %v", errs)
+	}
+}
@@ -0,0 +1,44 @@
+package httpserv
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+)
+
+const (
+	defaultMJPEGPath    = "/mjpeg"
+	defaultSnapshotPath = "/snapshot.jpg"
+)
+
+// Options configure the handler created by NewHandler, the zero value
+// serves the default paths without hooks and write timeout
+type Options struct {
+	Hooks Hooks
+	// MJPEGPath is the path of the stream (default: /mjpeg)
+	MJPEGPath string
+	// SnapshotPath is the path of the snapshot (default: /snapshot.jpg)
+	SnapshotPath string
+	WriteTimeout time.Duration
+}
+
+// NewHandler returns a handler serving the stream and the snapshot of
+// the source to be mounted onto an existing mux (use http.StripPrefix
+// to mount it below a path). Requests to other paths are answered with
+// 404 Not Found.
+func NewHandler(source *broadcast.Hub, opts Options) http.Handler {
+	mjpegPath, snapshotPath := opts.MJPEGPath, opts.SnapshotPath
+	if mjpegPath == "" {
+		mjpegPath = defaultMJPEGPath
+	}
+	if snapshotPath == "" {
+		snapshotPath = defaultSnapshotPath
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(mjpegPath, &MJPEGHandler{Hooks: opts.Hooks, Hub: source, WriteTimeout: opts.WriteTimeout})
+	mux.Handle(snapshotPath, &SnapshotHandler{Hooks: opts.Hooks, Hub: source})
+
+	return mux
+}
@@ -0,0 +1,95 @@
+package httpserv
+
+import (
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+)
+
+type (
+	// Hooks customize the handlers, all of them are optional and are
+	// called from the handling goroutine of the request
+	Hooks struct {
+		// OnSubscribe is called after the subscriber was registered and
+		// before the response is started so headers can still be set.
+		// Returning an error rejects the request with 403 Forbidden.
+		OnSubscribe func(res http.ResponseWriter, r *http.Request, sub *broadcast.Subscriber) error
+		// OnPart is called for every frame before it is written and may
+		// replace the JPEG (watermarks, ...), add headers or skip the
+		// frame. Returning an error ends the stream.
+		OnPart func(r *http.Request, f *broadcast.Frame, part *Part) error
+		// OnWrite is called after a part of the MJPEG stream was written
+		OnWrite func(r *http.Request, f *broadcast.Frame, stats PartStats)
+		// OnClose is called after the response ended
+		OnClose func(r *http.Request, sub *broadcast.Subscriber, stats StreamStats)
+		// Replay returns frames to play back at capture speed before
+		// the live frames of the MJPEG stream, live frames not newer
+		// than the last of them are skipped. The handler releases them.
+		Replay func(r *http.Request, sub *broadcast.Subscriber) []*broadcast.Frame
+	}
+
+	// Part is the response to a frame about to be written. JPEG refers
+	// to the frame data which must not be modified, replacements have
+	// to be assigned instead.
+	Part struct {
+		// Header are the MIME headers of the part or the response
+		// headers for snapshots
+		Header textproto.MIMEHeader
+		JPEG   []byte
+		// Skip drops the frame instead of writing it
+		Skip bool
+	}
+
+	// PartStats describe a written part
+	PartStats struct {
+		Bytes    int
+		Duration time.Duration
+		Err      error
+		Start    time.Time
+	}
+
+	// StreamStats describe a finished response
+	StreamStats struct {
+		Bytes    int64
+		Duration time.Duration
+		// Err is the write error ending the stream, nil if the client
+		// disconnected or the hub removed the subscriber
+		Err    error
+		Frames int64
+	}
+)
+
+func (h Hooks) close(r *http.Request, sub *broadcast.Subscriber, stats StreamStats) {
+	if h.OnClose != nil {
+		h.OnClose(r, sub, stats)
+	}
+}
+
+func (h Hooks) write(r *http.Request, f *broadcast.Frame, stats PartStats) {
+	if h.OnWrite != nil {
+		h.OnWrite(r, f, stats)
+	}
+}
+
+// part prepares the part of the frame passing it through OnPart
+func (h Hooks) part(r *http.Request, f *broadcast.Frame) (*Part, error) {
+	p := &Part{Header: make(textproto.MIMEHeader), JPEG: f.Data}
+	if h.OnPart == nil {
+		return p, nil
+	}
+	return p, h.OnPart(r, f, p)
+}
+
+func (h Hooks) subscribe(res http.ResponseWriter, r *http.Request, sub *broadcast.Subscriber) bool {
+	if h.OnSubscribe == nil {
+		return true
+	}
+
+	if err := h.OnSubscribe(res, r, sub); err != nil {
+		http.Error(res, "403 "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
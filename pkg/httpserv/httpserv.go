@@ -0,0 +1,263 @@
+// Package httpserv serves the frames of a broadcast hub as MJPEG
+// stream and JPEG snapshots
+package httpserv
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+)
+
+// Boundary separates the parts of the MJPEG streams
+const Boundary = "--boundary"
+
+type deadlineWriter struct {
+	rc      *http.ResponseController
+	timeout time.Duration
+	w       io.Writer
+}
+
+// DeadlineWriter returns a writer extending the write deadline of the
+// connection before every write so clients not reading for the
+// timeout are disconnected instead of blocking the handler
+func DeadlineWriter(res http.ResponseWriter, timeout time.Duration) io.Writer {
+	if timeout <= 0 {
+		return res
+	}
+	return deadlineWriter{rc: http.NewResponseController(res), timeout: timeout, w: res}
+}
+
+func (d deadlineWriter) Write(p []byte) (int, error) {
+	// Connections not supporting deadlines are streamed to regardless
+	_ = d.rc.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.w.Write(p)
+}
+
+// NewMJPEGWriter sets the headers of a MJPEG response and returns the
+// writer to send the frames to w with
+func NewMJPEGWriter(res http.ResponseWriter, w io.Writer) *multipart.Writer {
+	mimeWriter := multipart.NewWriter(w)
+	mimeWriter.SetBoundary(Boundary)
+
+	res.Header().Add("Connection", "close")
+	res.Header().Add("Cache-Control", "no-store, no-cache")
+	res.Header().Add("Content-Type", fmt.Sprintf("multipart/x-mixed-replace;boundary=%s", mimeWriter.Boundary()))
+
+	return mimeWriter
+}
+
+// WritePart writes the image as part of the MJPEG stream with the
+// extra part headers and returns the number of image bytes written
+func WritePart(mimeWriter *multipart.Writer, img []byte, extra textproto.MIMEHeader) (int, error) {
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Add("Content-Type", "image/jpeg")
+	partHeader.Add("Content-Length", strconv.Itoa(len(img)))
+	for k, v := range extra {
+		partHeader[k] = v
+	}
+
+	partWriter, err := mimeWriter.CreatePart(partHeader)
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to create mime part")
+	}
+
+	n, err := partWriter.Write(img)
+	return n, errors.Wrap(err, "Unable to write image")
+}
+
+type (
+	// MJPEGHandler streams the frames of the hub until the client
+	// disconnects or does not accept a frame within the write timeout
+	// (zero disables the timeout)
+	MJPEGHandler struct {
+		Hooks        Hooks
+		Hub          *broadcast.Hub
+		WriteTimeout time.Duration
+	}
+
+	// SnapshotHandler serves the next frame of the hub as JPEG
+	SnapshotHandler struct {
+		Hooks Hooks
+		Hub   *broadcast.Hub
+	}
+)
+
+// MJPEG returns a MJPEG handler without hooks
+func MJPEG(hub *broadcast.Hub, writeTimeout time.Duration) http.Handler {
+	return &MJPEGHandler{Hub: hub, WriteTimeout: writeTimeout}
+}
+
+// Snapshot returns a snapshot handler without hooks
+func Snapshot(hub *broadcast.Hub) http.Handler {
+	return &SnapshotHandler{Hub: hub}
+}
+
+func (m *MJPEGHandler) ServeHTTP(res http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(res, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	sub := m.Hub.Subscribe(uuid.Must(uuid.NewV4()).String(), false, false)
+	defer m.Hub.Unsubscribe(sub)
+
+	if !m.Hooks.subscribe(res, r, sub) {
+		return
+	}
+
+	stats := StreamStats{}
+	defer func(start time.Time) {
+		stats.Duration = time.Since(start)
+		m.Hooks.close(r, sub, stats)
+	}(time.Now())
+
+	mimeWriter := NewMJPEGWriter(res, DeadlineWriter(res, m.WriteTimeout))
+	defer mimeWriter.Close()
+	rc := http.NewResponseController(res)
+
+	var lastSeq uint64
+	if m.Hooks.Replay != nil {
+		var ok bool
+		if lastSeq, ok = m.replay(mimeWriter, rc, r, sub, m.Hooks.Replay(r, sub), &stats); !ok {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-sub.Done():
+			return
+
+		case img := <-sub.Frames():
+			if img.Seq <= lastSeq {
+				// Already sent as part of the replay
+				img.Release()
+				continue
+			}
+
+			err := m.writeFrame(mimeWriter, rc, r, img, &stats)
+			img.Release()
+
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// replay writes the frames at capture speed and returns the sequence
+// of the last one, false if the stream ended meanwhile
+func (m *MJPEGHandler) replay(mimeWriter *multipart.Writer, rc *http.ResponseController, r *http.Request, sub *broadcast.Subscriber, frames []*broadcast.Frame, stats *StreamStats) (uint64, bool) {
+	defer broadcast.ReleaseFrames(frames)
+
+	if len(frames) == 0 {
+		return 0, true
+	}
+
+	start := time.Now()
+	for _, f := range frames {
+		select {
+		case <-r.Context().Done():
+			return 0, false
+		case <-sub.Done():
+			return 0, false
+		case <-time.After(time.Until(start.Add(f.Time.Sub(frames[0].Time)))):
+		}
+
+		if err := m.writeFrame(mimeWriter, rc, r, f, stats); err != nil {
+			return 0, false
+		}
+	}
+
+	return frames[len(frames)-1].Seq, true
+}
+
+// writeFrame writes the frame as part, flushes it to the client and
+// updates the stats, the write error is stored in the stats
+func (m *MJPEGHandler) writeFrame(mimeWriter *multipart.Writer, rc *http.ResponseController, r *http.Request, img *broadcast.Frame, stats *StreamStats) error {
+	part, err := m.Hooks.part(r, img)
+	if err != nil || part.Skip {
+		stats.Err = err
+		return err
+	}
+
+	start := time.Now()
+	n, err := WritePart(mimeWriter, part.JPEG, part.Header)
+	if err == nil {
+		// The end of the part would stay buffered until the next frame,
+		// failing connections fail the next write
+		_ = rc.Flush()
+	}
+	m.Hooks.write(r, img, PartStats{Bytes: n, Duration: time.Since(start), Err: err, Start: start})
+
+	if n > 0 {
+		stats.Bytes += int64(n)
+		stats.Frames++
+	}
+	stats.Err = err
+	return err
+}
+
+func (s *SnapshotHandler) ServeHTTP(res http.ResponseWriter, r *http.Request) {
+	sub := s.Hub.Subscribe(uuid.Must(uuid.NewV4()).String(), false, false)
+	defer s.Hub.Unsubscribe(sub)
+
+	if !s.Hooks.subscribe(res, r, sub) {
+		return
+	}
+
+	stats := StreamStats{}
+	defer func(start time.Time) {
+		stats.Duration = time.Since(start)
+		s.Hooks.close(r, sub, stats)
+	}(time.Now())
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-sub.Done():
+			http.Error(res, "503 Service Unavailable", http.StatusServiceUnavailable)
+			return
+
+		case f := <-sub.Frames():
+			part, err := s.Hooks.part(r, f)
+			if err == nil && part.Skip {
+				// Wait for a frame not being skipped
+				f.Release()
+				continue
+			}
+			if err != nil {
+				f.Release()
+				stats.Err = err
+				http.Error(res, "500 Unable to process frame", http.StatusInternalServerError)
+				return
+			}
+
+			for k, v := range part.Header {
+				res.Header()[k] = v
+			}
+			res.Header().Add("Cache-Control", "no-store, no-cache")
+			res.Header().Add("Connection", "close")
+			res.Header().Set("Content-Type", "image/jpeg")
+
+			n, err := res.Write(part.JPEG)
+			f.Release()
+
+			stats.Bytes, stats.Err, stats.Frames = int64(n), err, 1
+			return
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package httpserv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/pkg/errors"
+)
+
+// testFrame returns a frame with the given sequence number and data
+func testFrame(seq uint64, data string) *broadcast.Frame {
+	f := broadcast.NewFrame(len(data))
+	copy(f.Data, data)
+	f.Seq, f.Time = seq, time.Now()
+	return f
+}
+
+// send pushes the frames to the hub once the client subscribed, the
+// headers are written with the first frame so it runs in background
+func send(t *testing.T, hub *broadcast.Hub, frames ...*broadcast.Frame) {
+	for deadline := time.Now().Add(5 * time.Second); hub.Count() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Error("client did not subscribe")
+			return
+		}
+	}
+
+	for _, f := range frames {
+		hub.Send(f, nil)
+		f.Release()
+	}
+}
+
+// readParts returns the boundary of the MJPEG response and the first
+// n parts with their data
+func readParts(t *testing.T, resp *http.Response, n int) (string, []*multipart.Part, [][]byte) {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	var (
+		mr    = multipart.NewReader(resp.Body, params["boundary"])
+		parts []*multipart.Part
+		data  [][]byte
+	)
+	for range n {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("unable to read part: %s", err)
+		}
+
+		// The part only ends with the boundary of the next frame
+		size, _ := strconv.Atoi(p.Header.Get("Content-Length"))
+		d := make([]byte, size)
+		if _, err = io.ReadFull(p, d); err != nil {
+			t.Fatalf("unable to read part data: %s", err)
+		}
+
+		parts, data = append(parts, p), append(data, d)
+	}
+
+	return params["boundary"], parts, data
+}
+
+func TestMJPEGHandler(t *testing.T) {
+	var (
+		hub    = broadcast.NewHub()
+		closed = make(chan StreamStats, 1)
+		writes int
+	)
+
+	srv := httptest.NewServer(NewHandler(hub, Options{Hooks: Hooks{
+		OnPart: func(_ *http.Request, f *broadcast.Frame, part *Part) error {
+			part.Header.Set("X-Frame-Seq", strconv.FormatUint(f.Seq, 10))
+			part.Skip = f.Seq == 2
+			return nil
+		},
+		OnWrite: func(_ *http.Request, _ *broadcast.Frame, stats PartStats) {
+			if stats.Err == nil {
+				writes++
+			}
+		},
+		OnClose: func(_ *http.Request, _ *broadcast.Subscriber, stats StreamStats) { closed <- stats },
+	}}))
+	defer srv.Close()
+
+	go send(t, hub, testFrame(1, "first"), testFrame(2, "skipped"), testFrame(3, "third"))
+
+	resp, err := http.Get(srv.URL + "/mjpeg")
+	if err != nil {
+		t.Fatalf("unable to request stream: %s", err)
+	}
+
+	for key, expected := range map[string]string{
+		"Cache-Control": "no-store, no-cache",
+		"Content-Type":  "multipart/x-mixed-replace;boundary=" + Boundary,
+	} {
+		if v := resp.Header.Get(key); v != expected {
+			t.Errorf("expected %s header %q, got %q", key, expected, v)
+		}
+	}
+	if !resp.Close {
+		t.Error("expected connection to be closed after the stream")
+	}
+
+	boundary, parts, data := readParts(t, resp, 2)
+	if boundary != Boundary {
+		t.Errorf("expected boundary %q, got %q", Boundary, boundary)
+	}
+
+	for i, expected := range []struct{ data, seq string }{{"first", "1"}, {"third", "3"}} {
+		if string(data[i]) != expected.data {
+			t.Errorf("part %d: expected data %q, got %q", i, expected.data, data[i])
+		}
+		for key, value := range map[string]string{
+			"Content-Length": strconv.Itoa(len(expected.data)),
+			"Content-Type":   "image/jpeg",
+			"X-Frame-Seq":    expected.seq,
+		} {
+			if v := parts[i].Header.Get(key); v != value {
+				t.Errorf("part %d: expected %s header %q, got %q", i, key, value, v)
+			}
+		}
+	}
+
+	resp.Body.Close()
+
+	select {
+	case stats := <-closed:
+		if stats.Frames != 2 || stats.Bytes != int64(len("first")+len("third")) {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not closed")
+	}
+
+	if writes != 2 {
+		t.Errorf("expected 2 writes, got %d", writes)
+	}
+}
+
+func TestMJPEGHandlerReplay(t *testing.T) {
+	hub := broadcast.NewHub()
+
+	srv := httptest.NewServer(NewHandler(hub, Options{Hooks: Hooks{
+		Replay: func(*http.Request, *broadcast.Subscriber) []*broadcast.Frame {
+			return []*broadcast.Frame{testFrame(1, "replay 1"), testFrame(2, "replay 2")}
+		},
+	}}))
+	defer srv.Close()
+
+	// The frame already replayed must not be sent again
+	go send(t, hub, testFrame(2, "live 2"), testFrame(3, "live 3"))
+
+	resp, err := http.Get(srv.URL + "/mjpeg")
+	if err != nil {
+		t.Fatalf("unable to request stream: %s", err)
+	}
+	defer resp.Body.Close()
+
+	_, _, data := readParts(t, resp, 3)
+	for i, expected := range []string{"replay 1", "replay 2", "live 3"} {
+		if string(data[i]) != expected {
+			t.Errorf("part %d: expected %q, got %q", i, expected, data[i])
+		}
+	}
+}
+
+func TestNewHandlerRouting(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(broadcast.NewHub(), Options{MJPEGPath: "/stream", SnapshotPath: "/still.jpg"}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodPost, "/stream", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/mjpeg", http.StatusNotFound},
+		{http.MethodGet, "/snapshot.jpg", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+tc.path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unable to request %s: %s", tc.path, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.path, tc.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	hub := broadcast.NewHub()
+
+	srv := httptest.NewServer(NewHandler(hub, Options{Hooks: Hooks{
+		OnPart: func(_ *http.Request, f *broadcast.Frame, part *Part) error {
+			// Wait for the second frame
+			part.Skip = f.Seq < 2
+			part.Header.Set("X-Frame-Seq", strconv.FormatUint(f.Seq, 10))
+			return nil
+		},
+	}}))
+	defer srv.Close()
+
+	go send(t, hub, testFrame(1, "skipped"), testFrame(2, "snapshot"))
+
+	resp, err := http.Get(srv.URL + "/snapshot.jpg")
+	if err != nil {
+		t.Fatalf("unable to request snapshot: %s", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if string(data) != "snapshot" {
+		t.Errorf("expected snapshot data, got %q", data)
+	}
+
+	for key, expected := range map[string]string{
+		"Cache-Control": "no-store, no-cache",
+		"Content-Type":  "image/jpeg",
+		"X-Frame-Seq":   "2",
+	} {
+		if v := resp.Header.Get(key); v != expected {
+			t.Errorf("expected %s header %q, got %q", key, expected, v)
+		}
+	}
+}
+
+func TestDeadlineWriter(t *testing.T) {
+	rec := httptest.NewRecorder()
+	if w := DeadlineWriter(rec, 0); w != io.Writer(rec) {
+		t.Error("expected response writer without timeout")
+	}
+
+	writeErr := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		w := DeadlineWriter(res, 100*time.Millisecond)
+		chunk := bytes.Repeat([]byte{0xff}, 64*1024)
+
+		for {
+			if _, err := w.Write(chunk); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	// The client sends the request but never reads the response
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect: %s", err)
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	w.Flush()
+
+	select {
+	case err := <-writeErr:
+		if !os.IsTimeout(errors.Cause(err)) {
+			t.Errorf("expected timeout error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("write did not time out")
+	}
+}
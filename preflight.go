@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type preflightError struct {
+	Err  error
+	Hint string
+}
+
+func (p preflightError) Error() string { return p.Err.Error() }
+
+// withPreflightHint adds the remediation hint to the log entry in
+// case the error is a preflightError carrying one
+func withPreflightHint(entry *log.Entry, err error) *log.Entry {
+	if pe, ok := err.(preflightError); ok && pe.Hint != "" {
+		return entry.WithField("hint", pe.Hint)
+	}
+	return entry
+}
+
+// preflightCheck verifies ffmpeg and the capture device are usable
+// before spawning ffmpeg and returns a preflightError with a hint how
+// to fix the issue otherwise
+func preflightCheck(device string) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return preflightError{
+			Err:  errors.Wrap(err, "ffmpeg not found"),
+			Hint: "Install ffmpeg (i.e. apt install ffmpeg) and make sure it is available in $PATH",
+		}
+	}
+
+	fi, err := os.Stat(device)
+	switch {
+	case os.IsNotExist(err):
+		return preflightError{
+			Err:  errors.Errorf("Device %s does not exist", device),
+			Hint: "Check the camera is connected (ls -l /dev/video*) and pass the correct device using --input",
+		}
+
+	case os.IsPermission(err):
+		return preflightError{
+			Err:  errors.Wrapf(err, "Unable to access device %s", device),
+			Hint: "Make sure the directory containing the device is accessible for the current user",
+		}
+
+	case err != nil:
+		return preflightError{Err: errors.Wrapf(err, "Unable to stat device %s", device)}
+	}
+
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return preflightError{
+			Err:  errors.Errorf("%s is not a character device", device),
+			Hint: "Pass a video4linux2 device node (i.e. /dev/video0) using --input",
+		}
+	}
+
+	if runtime.GOOS != "linux" {
+		// Remaining checks use the video4linux2 API
+		return nil
+	}
+
+	if err = checkDeviceAccess(device); err != nil {
+		return preflightError{
+			Err:  errors.Wrapf(err, "Device %s is not read- / writable", device),
+			Hint: fmt.Sprintf("Add the user to the group owning the device (usually 'video': sudo usermod -aG video %s) and log in again", currentUser()),
+		}
+	}
+
+	dev, err := openV4L2Device(device)
+	if err != nil {
+		return preflightError{Err: errors.Wrapf(err, "Unable to open device %s", device)}
+	}
+	defer dev.Close()
+
+	info, err := dev.Info()
+	if err != nil {
+		return preflightError{
+			Err:  errors.Wrapf(err, "%s is no video4linux2 device", device),
+			Hint: "Pass a video4linux2 device node (i.e. /dev/video0) using --input",
+		}
+	}
+
+	if !info.CanCapture {
+		return preflightError{
+			Err:  errors.Errorf("Device %s (%s) does not support video capture", device, info.Card),
+			Hint: "Many cameras expose additional metadata nodes, try another /dev/video* node of the same camera",
+		}
+	}
+
+	busy, err := dev.IsBusy()
+	if err != nil {
+		// Some drivers do not support the check, let ffmpeg try
+		return nil
+	}
+
+	if busy {
+		return preflightError{
+			Err:  errors.Errorf("Device %s (%s) is busy", device, info.Card),
+			Hint: fmt.Sprintf("Another process is capturing from the device, find it using 'fuser %s' and stop it", device),
+		}
+	}
+
+	return nil
+}
+
+func currentUser() string {
+	if u := os.Getenv("USER"); u != "" {
+		return u
+	}
+	return "$USER"
+}
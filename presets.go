@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const presetExt = ".yaml"
+
+var presetNameFormat = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+type presetSaveRequest struct {
+	PTZ bool `json:"ptz"`
+}
+
+func presetPath(name string) (string, error) {
+	if !presetNameFormat.MatchString(name) {
+		return "", errors.Errorf("Invalid preset name %q", name)
+	}
+	return filepath.Join(cfg.PresetDir, name+presetExt), nil
+}
+
+// listPresets returns the names of the presets in the preset directory
+func listPresets() ([]string, error) {
+	entries, err := os.ReadDir(cfg.PresetDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "Unable to read preset directory")
+	}
+
+	names := []string{}
+	for _, e := range entries {
+		if name, ok := strings.CutSuffix(e.Name(), presetExt); ok && !e.IsDir() && presetNameFormat.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func loadPreset(name string) (map[string]int32, error) {
+	p, err := presetPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read preset")
+	}
+
+	var values map[string]int32
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse preset")
+	}
+
+	return values, nil
+}
+
+// applyPreset sets all controls stored in the named preset
+func applyPreset(name string) error {
+	values, err := loadPreset(name)
+	if err != nil {
+		return err
+	}
+
+	if err = setControls(values); err != nil {
+		return err
+	}
+
+	log.WithField("preset", name).Info("Control preset applied")
+	return nil
+}
+
+// savePreset stores the current values of all writable controls,
+// PTZ positions are only included when requested
+func savePreset(name string, withPTZ bool) (map[string]int32, error) {
+	p, err := presetPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := writableControlValues(withPTZ)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to encode preset")
+	}
+
+	if err = os.MkdirAll(cfg.PresetDir, 0o755); err != nil {
+		return nil, errors.Wrap(err, "Unable to create preset directory")
+	}
+
+	if err = os.WriteFile(p, data, 0o644); err != nil {
+		return nil, errors.Wrap(err, "Unable to write preset")
+	}
+
+	log.WithField("preset", name).Info("Control preset saved")
+	return values, nil
+}
+
+// writableControlValues returns the current values of all controls
+// which can be restored later on
+func writableControlValues(withPTZ bool) (map[string]int32, error) {
+	controls, err := listControls()
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]int32{}
+	for _, c := range controls {
+		if c.Type == "button" || slices.Contains(c.Flags, "read-only") || slices.Contains(c.Flags, "write-only") || slices.Contains(c.Flags, "inactive") {
+			continue
+		}
+		if !withPTZ && isPTZControl(c.Key) {
+			continue
+		}
+		values[c.Key] = c.Value
+	}
+
+	return values, nil
+}
+
+func isPTZControl(key string) bool {
+	return slices.Contains(slices.Collect(maps.Values(ptzAxes)), key)
+}
+
+func handlePresetList(w http.ResponseWriter, r *http.Request) {
+	names, err := listPresets()
+	if err != nil {
+		log.WithError(err).Error("Unable to list presets")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to list presets")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, names)
+}
+
+func handlePresetGet(w http.ResponseWriter, r *http.Request) {
+	values, err := loadPreset(r.PathValue("name"))
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			writeAPIError(w, http.StatusNotFound, "Preset not found")
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, values)
+}
+
+// handlePresetSave stores the current control values as preset
+func handlePresetSave(w http.ResponseWriter, r *http.Request) {
+	var req presetSaveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Unable to parse preset request")
+			return
+		}
+	}
+
+	values, err := savePreset(r.PathValue("name"), req.PTZ)
+	if err != nil {
+		log.WithError(err).Error("Unable to save preset")
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, values)
+}
+
+func handlePresetApply(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	values, err := loadPreset(name)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			writeAPIError(w, http.StatusNotFound, "Preset not found")
+			return
+		}
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = setControls(values); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	log.WithField("preset", name).Info("Control preset applied")
+	handleControlsGet(w, r)
+}
+
+func handlePresetDelete(w http.ResponseWriter, r *http.Request) {
+	p, err := presetPath(r.PathValue("name"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = os.Remove(p); err != nil {
+		if os.IsNotExist(err) {
+			writeAPIError(w, http.StatusNotFound, "Preset not found")
+			return
+		}
+		log.WithError(err).Error("Unable to delete preset")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to delete preset")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
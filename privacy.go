@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Luzifer/cam2mjpeg/pkg/broadcast"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	privacyEnabled int32
+
+	privacyImage     []byte
+	privacyImageLock = new(sync.Mutex)
+	privacyImageSize image.Point
+)
+
+type privacyResponse struct {
+	Enabled bool `json:"enabled"`
+}
+
+func isPrivacyEnabled() bool { return atomic.LoadInt32(&privacyEnabled) == 1 }
+
+// setPrivacy toggles privacy mode: frames are replaced by the privacy
+// image and recording is paused
+func setPrivacy(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+
+	if atomic.SwapInt32(&privacyEnabled, v) == v {
+		return
+	}
+
+	recordControl.SetPaused(enabled)
+	log.WithField("enabled", enabled).Info("Privacy mode changed")
+}
+
+// processPrivacy is the frame processor replacing the frames by the
+// privacy image while privacy mode is enabled
+func processPrivacy(_ context.Context, f *frame) (*frame, error) {
+	if !isPrivacyEnabled() {
+		return f, nil
+	}
+
+	img, err := getPrivacyImage()
+	if err != nil {
+		return nil, err
+	}
+	return broadcast.WrapFrame(img, f), nil
+}
+
+// getPrivacyImage returns the configured privacy image or a generated
+// dark gray image of the capture size
+func getPrivacyImage() ([]byte, error) {
+	privacyImageLock.Lock()
+	defer privacyImageLock.Unlock()
+
+	cfgLock.RLock()
+	size := image.Pt(cfg.Width, cfg.Height)
+	cfgLock.RUnlock()
+
+	if privacyImage != nil && (cfg.PrivacyImage != "" || privacyImageSize == size) {
+		return privacyImage, nil
+	}
+
+	if cfg.PrivacyImage != "" {
+		img, err := os.ReadFile(cfg.PrivacyImage)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read privacy image")
+		}
+
+		privacyImage = img
+		return privacyImage, nil
+	}
+
+	img := image.NewGray(image.Rectangle{Max: size})
+	for i := range img.Pix {
+		img.Pix[i] = 0x20
+	}
+
+	buf := new(bytes.Buffer)
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		return nil, errors.Wrap(err, "Unable to encode privacy image")
+	}
+
+	privacyImage, privacyImageSize = buf.Bytes(), size
+	return privacyImage, nil
+}
+
+func handlePrivacyGet(w http.ResponseWriter, r *http.Request) {
+	writeAPIResponse(w, http.StatusOK, privacyResponse{Enabled: isPrivacyEnabled()})
+}
+
+// handlePrivacySet accepts "on" / "off" as body or state parameter
+func handlePrivacySet(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Unable to read body")
+			return
+		}
+		state = strings.TrimSpace(string(body))
+	}
+
+	switch strings.ToLower(state) {
+	case "on", "true", "1":
+		setPrivacy(true)
+	case "off", "false", "0":
+		setPrivacy(false)
+	default:
+		writeAPIError(w, http.StatusBadRequest, "Expecting on or off")
+		return
+	}
+
+	handlePrivacyGet(w, r)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	profileDay      = "day"
+	profileNight    = "night"
+	profileInterval = 30 * time.Second
+)
+
+var (
+	controlProfiles    map[string]map[string]int32
+	activeProfile      string
+	activeProfileLock  = new(sync.RWMutex)
+	profileDayStart    time.Duration
+	profileNightStart  time.Duration
+	profileModeChecker = map[string]func(current string) string{
+		"luminance": profileByLuminance,
+		"manual":    func(current string) string { return current },
+		"time":      profileByTime,
+	}
+)
+
+type (
+	profileRequest struct {
+		Name string `json:"name"`
+	}
+
+	profileResponse struct {
+		Active    string   `json:"active"`
+		Available []string `json:"available"`
+		Mode      string   `json:"mode"`
+	}
+)
+
+// loadProfiles reads the control profiles (name to control keys and
+// values) from the profiles file
+func loadProfiles() error {
+	data, err := os.ReadFile(cfg.Profiles)
+	if err != nil {
+		return errors.Wrap(err, "Unable to read profiles")
+	}
+
+	if err = yaml.Unmarshal(data, &controlProfiles); err != nil {
+		return errors.Wrap(err, "Unable to parse profiles")
+	}
+
+	if _, ok := profileModeChecker[cfg.ProfileMode]; !ok {
+		return errors.Errorf("Unknown profile mode %q", cfg.ProfileMode)
+	}
+
+	if cfg.ProfileMode != "manual" {
+		for _, p := range []string{profileDay, profileNight} {
+			if _, ok := controlProfiles[p]; !ok {
+				return errors.Errorf("Profile mode %q requires a %q profile", cfg.ProfileMode, p)
+			}
+		}
+	}
+
+	if profileDayStart, err = parseTimeOfDay(cfg.ProfileDayStart); err != nil {
+		return errors.Wrap(err, "Invalid day start")
+	}
+
+	if profileNightStart, err = parseTimeOfDay(cfg.ProfileNightStart); err != nil {
+		return errors.Wrap(err, "Invalid night start")
+	}
+
+	return nil
+}
+
+// applyProfile sets the controls of the named profile
+func applyProfile(name string) error {
+	controls, ok := controlProfiles[name]
+	if !ok {
+		return errors.Errorf("Unknown profile %q", name)
+	}
+
+	if err := setControls(controls); err != nil {
+		return err
+	}
+
+	activeProfileLock.Lock()
+	activeProfile = name
+	activeProfileLock.Unlock()
+
+	log.WithField("profile", name).Info("Control profile applied")
+	return nil
+}
+
+func getActiveProfile() string {
+	activeProfileLock.RLock()
+	defer activeProfileLock.RUnlock()
+
+	return activeProfile
+}
+
+func profileByTime(string) string {
+	now := time.Now()
+	sinceMidnight := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+
+	day := sinceMidnight >= profileDayStart && sinceMidnight < profileNightStart
+	if profileNightStart < profileDayStart {
+		// Night starts after midnight
+		day = sinceMidnight >= profileDayStart || sinceMidnight < profileNightStart
+	}
+
+	if day {
+		return profileDay
+	}
+	return profileNight
+}
+
+// profileByLuminance switches with hysteresis: to night below the night
+// threshold and back to day above the day threshold
+func profileByLuminance(current string) string {
+	stats := getExposure()
+	switch {
+	case stats == nil:
+		return current
+	case stats.MeanLuminance < cfg.ProfileNightBelow:
+		return profileNight
+	case stats.MeanLuminance > cfg.ProfileDayAbove:
+		return profileDay
+	case current == "":
+		return profileDay
+	default:
+		return current
+	}
+}
+
+// runProfiles switches the profiles according to the profile mode until
+// the context is cancelled
+func runProfiles(ctx context.Context) {
+	t := time.NewTicker(profileInterval)
+	defer t.Stop()
+
+	for {
+		current := getActiveProfile()
+		if next := profileModeChecker[cfg.ProfileMode](current); next != "" && next != current {
+			if err := applyProfile(next); err != nil {
+				log.WithError(err).WithField("profile", next).Error("Unable to apply control profile")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func handleProfileGet(w http.ResponseWriter, r *http.Request) {
+	available := []string{}
+	for name := range controlProfiles {
+		available = append(available, name)
+	}
+	sort.Strings(available)
+
+	writeAPIResponse(w, http.StatusOK, profileResponse{
+		Active:    getActiveProfile(),
+		Available: available,
+		Mode:      cfg.ProfileMode,
+	})
+}
+
+// handleProfileSet applies the given profile, in automatic modes it
+// stays active until the next automatic switch
+func handleProfileSet(w http.ResponseWriter, r *http.Request) {
+	var req profileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Unable to parse profile request")
+		return
+	}
+
+	if err := applyProfile(req.Name); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	handleProfileGet(w, r)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ptzAxes lists the UVC absolute position control key for each axis
+var ptzAxes = map[string]string{
+	"pan":  "pan_absolute",
+	"tilt": "tilt_absolute",
+	"zoom": "zoom_absolute",
+}
+
+type (
+	ptzAxis struct {
+		Max   int32 `json:"max"`
+		Min   int32 `json:"min"`
+		Step  int32 `json:"step"`
+		Value int32 `json:"value"`
+	}
+
+	// ptzRequest moves the camera: absolute and relative modes take the
+	// target / offset of the given axes, home resets all axes to default
+	ptzRequest struct {
+		Mode string `json:"mode"`
+		Pan  *int32 `json:"pan,omitempty"`
+		Tilt *int32 `json:"tilt,omitempty"`
+		Zoom *int32 `json:"zoom,omitempty"`
+	}
+)
+
+// ptzState returns the PTZ axes supported by the camera
+func ptzState() (map[string]ptzAxis, map[string]cameraControl, error) {
+	controls, err := listControls()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byKey := map[string]cameraControl{}
+	for _, c := range controls {
+		byKey[c.Key] = c
+	}
+
+	axes := map[string]ptzAxis{}
+	for axis, key := range ptzAxes {
+		if c, ok := byKey[key]; ok {
+			axes[axis] = ptzAxis{Max: c.Max, Min: c.Min, Step: c.Step, Value: c.Value}
+		}
+	}
+
+	return axes, byKey, nil
+}
+
+func handlePTZGet(w http.ResponseWriter, r *http.Request) {
+	axes, _, err := ptzState()
+	if err != nil {
+		log.WithError(err).Error("Unable to query PTZ state")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to query PTZ state")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, axes)
+}
+
+func handlePTZMove(w http.ResponseWriter, r *http.Request) {
+	var req ptzRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Unable to parse PTZ request")
+		return
+	}
+
+	axes, controls, err := ptzState()
+	if err != nil {
+		log.WithError(err).Error("Unable to query PTZ state")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to query PTZ state")
+		return
+	}
+
+	if len(axes) == 0 {
+		writeAPIError(w, http.StatusNotFound, "Camera does not support PTZ")
+		return
+	}
+
+	values, err := req.targetValues(axes, controls)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err = setControls(values); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	handlePTZGet(w, r)
+}
+
+// targetValues converts the request into absolute control values
+// clamped to the supported range
+func (p ptzRequest) targetValues(axes map[string]ptzAxis, controls map[string]cameraControl) (map[string]int32, error) {
+	values := map[string]int32{}
+
+	requested := map[string]*int32{"pan": p.Pan, "tilt": p.Tilt, "zoom": p.Zoom}
+	for axis, v := range requested {
+		state, ok := axes[axis]
+
+		switch {
+		case p.Mode == "home" && ok:
+			values[ptzAxes[axis]] = controls[ptzAxes[axis]].Default
+			continue
+		case p.Mode == "home" || v == nil:
+			continue
+		case !ok:
+			return nil, errors.Errorf("Camera does not support %s", axis)
+		}
+
+		target := *v
+		switch p.Mode {
+		case "absolute":
+		case "relative":
+			target += state.Value
+		default:
+			return nil, errors.New("Mode must be one of absolute, relative or home")
+		}
+
+		if target < state.Min {
+			target = state.Min
+		}
+		if target > state.Max {
+			target = state.Max
+		}
+		values[ptzAxes[axis]] = target
+	}
+
+	return values, nil
+}
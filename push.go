@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const pushRestartDelay = 5 * time.Second
+
+// pushTarget describes an ingest the frames are pushed to
+type pushTarget struct {
+	// Format is the ffmpeg muxer to write to the URL with
+	Format string
+	Name   string
+	// SilentAudio adds a silent audio track for ingests requiring audio
+	SilentAudio bool
+	URL         string
+	// VideoArgs replace the H.264 encoding arguments if set
+	VideoArgs []string
+}
+
+// pushTargets returns the configured push targets
+func pushTargets() []pushTarget {
+	var targets []pushTarget
+
+	if cfg.PushRTMP != "" {
+		targets = append(targets, pushTarget{Format: "flv", Name: "rtmp", URL: cfg.PushRTMP})
+	}
+
+	if cfg.PushSRT != "" {
+		targets = append(targets, pushTarget{Format: "mpegts", Name: "srt", URL: srtURL(cfg.PushSRT, cfg.PushSRTLatency)})
+	}
+
+	if cfg.NDIName != "" {
+		targets = append(targets, ndiTarget(cfg.NDIName))
+	}
+
+	if cfg.Restream != "" {
+		// Validated at startup
+		ingest, service, _ := parseRestream(cfg.Restream)
+		targets = append(targets, pushTarget{Format: "flv", Name: service, SilentAudio: true, URL: ingest})
+	}
+
+	return targets
+}
+
+// runPush encodes the captured frames and pushes them to the target
+// until the context is cancelled, restarting ffmpeg if it fails
+func runPush(ctx context.Context, target pushTarget) {
+	logger := log.WithFields(log.Fields{
+		"camera": cfg.Device,
+		"ingest": pushRedactedURL(target.URL),
+	})
+
+	sub := frameBroadcaster.SubscribeInternal("push-" + target.Name)
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	logger.Info("Pushing stream")
+
+	for ctx.Err() == nil {
+		if err := pushStream(ctx, target, sub); err != nil && ctx.Err() == nil {
+			logger.WithError(err).Error("Stream push failed, restarting")
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(pushRestartDelay):
+			}
+		}
+	}
+}
+
+// pushArgs builds the ffmpeg arguments to encode the MJPEG frames read
+// from stdin and push them to the target
+func pushArgs(target pushTarget, fps int) []string {
+	args := []string{
+		"-hide_banner", "-nostats",
+		"-use_wallclock_as_timestamps", "1",
+		"-f", "mjpeg",
+		"-i", "pipe:0",
+	}
+
+	if target.SilentAudio {
+		args = append(args,
+			"-f", "lavfi",
+			"-i", "anullsrc=channel_layout=stereo:sample_rate=44100",
+			"-map", "0:v", "-map", "1:a",
+			"-c:a", "aac", "-b:a", "128k",
+			// The silent audio never ends: stop when the video pipe is closed
+			"-shortest",
+		)
+	} else {
+		args = append(args, "-an")
+	}
+
+	if target.VideoArgs != nil {
+		args = append(args, target.VideoArgs...)
+	} else {
+		args = append(args, pushEncoderArgs(pushEncoder())...)
+		args = append(args,
+			"-g", strconv.Itoa(2*fps),
+			"-b:v", cfg.PushBitrate,
+			"-maxrate", cfg.PushBitrate,
+			"-bufsize", cfg.PushBitrate,
+		)
+	}
+
+	return append(args,
+		"-r", strconv.Itoa(fps),
+		"-f", target.Format,
+		target.URL,
+	)
+}
+
+func pushStream(ctx context.Context, target pushTarget, sub *subscriber) error {
+	cmd := ffmpegCommand(context.Background(), pushArgs(target, cfgValue(&cfg.FrameRate))...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdin pipe")
+	}
+
+	stderr := ffmpegLogWriter("push-" + target.Name)
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	// A stalled ingest keeps ffmpeg from reading stdin and the write
+	// below from returning: kill ffmpeg if it does not stop in time
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-stopped:
+			return
+		case <-ctx.Done():
+		}
+
+		select {
+		case <-stopped:
+		case <-time.After(ffmpegStopTimeout):
+			cmd.Process.Kill()
+		}
+	}()
+
+	var writeErr error
+	for writeErr == nil {
+		select {
+		case <-ctx.Done():
+			writeErr = ctx.Err()
+
+		case err = <-exited:
+			return errors.Wrap(err, "ffmpeg exited")
+
+		case img := <-sub.Frames():
+			if _, err := stdin.Write(img.Data); err != nil {
+				writeErr = errors.Wrap(err, "Unable to write frame")
+			}
+			img.Release()
+		}
+	}
+
+	// Closing stdin makes ffmpeg flush and close the connection
+	stdin.Close()
+	select {
+	case err = <-exited:
+	case <-time.After(ffmpegStopTimeout):
+		cmd.Process.Kill()
+		err = <-exited
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "ffmpeg exited")
+	}
+	return writeErr
+}
+
+// pushRedactedURL removes the stream key, passphrase and credentials
+// from the ingest URL for logging, targets not being URLs (NDI source
+// names) are returned as they are
+func pushRedactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		return "invalid"
+	case u.Host == "":
+		return raw
+	default:
+		return u.Scheme + "://" + u.Host
+	}
+}
+
+// srtURL adds the latency (in microseconds as expected by ffmpeg) to
+// the SRT URL unless it already specifies one
+func srtURL(raw string, latency time.Duration) string {
+	u, err := url.Parse(raw)
+	if err != nil || latency <= 0 {
+		return raw
+	}
+
+	q := u.Query()
+	if q.Get("latency") == "" {
+		q.Set("latency", strconv.FormatInt(latency.Microseconds(), 10))
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// validatePushURL checks the push target has one of the schemes
+func validatePushURL(raw string, schemes ...string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.Wrap(err, "Unable to parse URL")
+	}
+
+	for _, s := range schemes {
+		if u.Scheme == s && u.Host != "" {
+			return nil
+		}
+	}
+	return errors.Errorf("URL must be %s://host...", schemes[0])
+}
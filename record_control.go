@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sources requesting a recording
+const (
+	recordSourceAPI        = "api"
+	recordSourceContinuous = "continuous"
+)
+
+// recordController runs the recorder as long as at least one source
+// requests a recording
+type recordController struct {
+	changed  chan struct{}
+	lock     sync.Mutex
+	paused   bool
+	requests map[string]time.Time
+}
+
+var recordControl = newRecordController()
+
+func newRecordController() *recordController {
+	return &recordController{
+		changed:  make(chan struct{}, 1),
+		requests: make(map[string]time.Time),
+	}
+}
+
+// Request starts the recording on behalf of the source until the given
+// time (zero time for no end), replacing earlier requests of the source
+func (r *recordController) Request(source string, until time.Time) {
+	r.lock.Lock()
+	r.requests[source] = until
+	r.lock.Unlock()
+
+	r.notify()
+}
+
+// Release withdraws the recording request of the source
+func (r *recordController) Release(source string) {
+	r.lock.Lock()
+	delete(r.requests, source)
+	r.lock.Unlock()
+
+	r.notify()
+}
+
+// SetPaused pauses the recording regardless of the requests (privacy
+// mode) or resumes it
+func (r *recordController) SetPaused(paused bool) {
+	r.lock.Lock()
+	r.paused = paused
+	r.lock.Unlock()
+
+	r.notify()
+}
+
+// Active returns whether recording is requested and the sorted list of
+// sources requesting it
+func (r *recordController) Active() (bool, []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	active, _ := r.expire(time.Now())
+
+	sources := []string{}
+	for s := range r.requests {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+
+	return active, sources
+}
+
+// Run starts and stops the recorder according to the requests until
+// the context is cancelled
+func (r *recordController) Run(ctx context.Context) {
+	// stopRecorder is set while the recorder is running
+	var stopRecorder func()
+
+	stop := func() {
+		if stopRecorder != nil {
+			stopRecorder()
+			stopRecorder = nil
+			r.publish("stop")
+		}
+	}
+	defer stop()
+
+	for {
+		r.lock.Lock()
+		active, next := r.expire(time.Now())
+		r.lock.Unlock()
+
+		switch {
+		case active && stopRecorder == nil:
+			rctx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				runRecorder(rctx)
+			}()
+
+			stopRecorder = func() {
+				cancel()
+				<-done
+			}
+			r.publish("start")
+
+		case !active:
+			stop()
+		}
+
+		var timer <-chan time.Time
+		if !next.IsZero() {
+			timer = time.After(time.Until(next))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.changed:
+		case <-timer:
+		}
+	}
+}
+
+// expire removes elapsed requests and returns whether recording is
+// requested and not paused and when the next request elapses (must be
+// called with lock held)
+func (r *recordController) expire(now time.Time) (active bool, next time.Time) {
+	for s, until := range r.requests {
+		if until.IsZero() {
+			continue
+		}
+
+		if !until.After(now) {
+			delete(r.requests, s)
+			continue
+		}
+
+		if next.IsZero() || until.Before(next) {
+			next = until
+		}
+	}
+
+	return len(r.requests) > 0 && !r.paused, next
+}
+
+// publish sends the recording state change to the event bus
+func (r *recordController) publish(event string) {
+	_, sources := r.Active()
+	events.Publish(eventRecording, recordingEvent{Camera: cfg.Device, Event: event, Sources: sources})
+}
+
+func (r *recordController) notify() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const recorderRestartDelay = 5 * time.Second
+
+// recordingContainers maps the container names accepted on the
+// commandline to ffmpeg muxer names
+var recordingContainers = map[string]string{
+	"avi": "avi",
+	"mkv": "matroska",
+	"mp4": "mp4",
+}
+
+// recordingAudioCodecs contains the audio codec to use for each of the
+// recording containers when recording audio
+var recordingAudioCodecs = map[string]string{
+	"avi": "libmp3lame",
+	"mkv": "aac",
+	"mp4": "aac",
+}
+
+// runRecorder writes all captured frames into rolling segments until
+// the context is cancelled, restarting ffmpeg if it fails
+func runRecorder(ctx context.Context) {
+	logger := log.WithFields(log.Fields{
+		"camera": cfg.Device,
+		"dir":    cfg.RecordDir,
+	})
+
+	sub := frameBroadcaster.SubscribeInternal("recorder")
+	defer frameBroadcaster.Unsubscribe(sub)
+
+	logger.Info("Recording started")
+
+	for ctx.Err() == nil {
+		if err := recordSegments(ctx, sub); err != nil && ctx.Err() == nil {
+			logger.WithError(err).Error("Recording failed, restarting recorder")
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(recorderRestartDelay):
+			}
+		}
+	}
+
+	logger.Info("Recording stopped")
+}
+
+// recordArgs builds the ffmpeg arguments to write the MJPEG frames
+// read from stdin into segments, the segment list is written to stdout
+func recordArgs() []string {
+	args := []string{
+		"-hide_banner", "-nostats",
+		"-use_wallclock_as_timestamps", "1",
+		"-f", "mjpeg",
+		"-i", "pipe:0",
+	}
+
+	if cfg.AudioDevice != "" {
+		args = append(args,
+			"-thread_queue_size", "1024",
+			"-f", cfg.AudioFormat,
+			"-i", cfg.AudioDevice,
+			"-map", "0:v", "-map", "1:a",
+			"-c:a", recordingAudioCodecs[cfg.RecordContainer],
+			// Audio never ends by itself: stop when the video pipe is closed
+			"-shortest",
+		)
+	} else {
+		args = append(args, "-an")
+	}
+
+	return append(args,
+		"-c:v", "copy",
+		"-f", "segment",
+		"-segment_format", recordingContainers[cfg.RecordContainer],
+		"-segment_time", fmt.Sprintf("%.3f", cfg.RecordSegment.Seconds()),
+		"-segment_list", "pipe:1",
+		"-segment_list_type", "flat",
+		"-reset_timestamps", "1",
+		"-strftime", "1",
+		filepath.Join(cfg.RecordDir, "%Y-%m-%d_%H-%M-%S."+cfg.RecordContainer),
+	)
+}
+
+func recordSegments(ctx context.Context, sub *subscriber) error {
+	if err := os.MkdirAll(cfg.RecordDir, 0o755); err != nil {
+		return errors.Wrap(err, "Unable to create recording directory")
+	}
+
+	cmd := ffmpegCommand(context.Background(), recordArgs()...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdin pipe")
+	}
+
+	// The segment list written to stdout contains every finished segment
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create stdout pipe")
+	}
+
+	stderr := ffmpegLogWriter("recorder")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+
+	listDone := make(chan struct{})
+	go func() {
+		defer close(listDone)
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if name := strings.TrimSpace(scanner.Text()); name != "" {
+				segmentCompleted(filepath.Join(cfg.RecordDir, name))
+			}
+		}
+	}()
+
+	var writeErr error
+	for writeErr == nil {
+		select {
+		case <-ctx.Done():
+			writeErr = ctx.Err()
+
+		case img := <-sub.Frames():
+			if _, err := stdin.Write(img.Data); err != nil {
+				writeErr = errors.Wrap(err, "Unable to write frame")
+			}
+			img.Release()
+		}
+	}
+
+	// Closing stdin makes ffmpeg finish the current segment
+	stdin.Close()
+	select {
+	case <-listDone:
+	case <-time.After(ffmpegStopTimeout):
+		cmd.Process.Kill()
+		<-listDone
+	}
+
+	if err = cmd.Wait(); err != nil && ctx.Err() == nil {
+		return errors.Wrap(err, "ffmpeg exited")
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	return writeErr
+}
+
+// segmentCompleted is called for every recording segment ffmpeg
+// finished writing
+func segmentCompleted(path string) {
+	log.WithFields(log.Fields{
+		"camera": cfg.Device,
+		"path":   path,
+	}).Info("Recording segment completed")
+
+	queueUpload("recording", path)
+}
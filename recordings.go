@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	recordingFrameTimeout   = 10 * time.Second
+	recordingSegmentFormat  = "2006-01-02_15-04-05"
+	recordingThumbnailWidth = 320
+	recordingTimelineRange  = 24 * time.Hour
+)
+
+// recordingKinds maps the kinds of stored files to their directories
+var recordingKinds = map[string]func() string{
+	"clip":      func() string { return cfg.MotionClipDir },
+	"record":    func() string { return cfg.RecordDir },
+	"scene":     func() string { return cfg.SceneDir },
+	"snapshot":  func() string { return cfg.SnapshotDir },
+	"timelapse": func() string { return cfg.TimelapseDir },
+}
+
+type (
+	recordingFile struct {
+		Kind      string    `json:"kind"`
+		Modified  time.Time `json:"modified"`
+		Name      string    `json:"name"`
+		Size      int64     `json:"size"`
+		Thumbnail string    `json:"thumbnail"`
+		URL       string    `json:"url"`
+		Video     bool      `json:"video"`
+	}
+
+	recordingsResponse struct {
+		Files []recordingFile `json:"files"`
+		Kinds []string        `json:"kinds"`
+	}
+
+	timelineSegment struct {
+		End   time.Time `json:"end"`
+		Name  string    `json:"name"`
+		Start time.Time `json:"start"`
+		URL   string    `json:"url"`
+	}
+
+	timelineResponse struct {
+		From     time.Time         `json:"from"`
+		Motion   []motionInterval  `json:"motion"`
+		Segments []timelineSegment `json:"segments"`
+		To       time.Time         `json:"to"`
+	}
+)
+
+// enabledRecordingKinds returns the sorted kinds having a directory
+func enabledRecordingKinds() []string {
+	var kinds []string
+	for k, dir := range recordingKinds {
+		if dir() != "" {
+			kinds = append(kinds, k)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// listRecordings returns the stored files of the given kinds, newest
+// first. Hidden files (temporary files being written) are skipped.
+func listRecordings(kinds []string) ([]recordingFile, error) {
+	files := []recordingFile{}
+
+	for _, kind := range kinds {
+		dir := recordingKinds[kind]()
+
+		err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			if strings.HasPrefix(d.Name(), ".") && p != dir {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				// File removed while walking
+				return nil
+			}
+
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+
+			u := "api/v1/recordings/" + kind + "/" + filepath.ToSlash(rel)
+			files = append(files, recordingFile{
+				Kind:      kind,
+				Modified:  info.ModTime(),
+				Name:      filepath.ToSlash(rel),
+				Size:      info.Size(),
+				Thumbnail: u + "?thumbnail=1",
+				URL:       u,
+				Video:     !isJPEGFile(p),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to list directory %q", dir)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Modified.After(files[j].Modified) })
+	return files, nil
+}
+
+func isJPEGFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+// handleRecordingsList lists the stored files, optionally limited by
+// the kind and limit parameters
+func handleRecordingsList(w http.ResponseWriter, r *http.Request) {
+	kinds := enabledRecordingKinds()
+	if k := r.URL.Query().Get("kind"); k != "" {
+		if dir, ok := recordingKinds[k]; !ok || dir() == "" {
+			writeAPIError(w, http.StatusBadRequest, "Unknown or disabled kind")
+			return
+		}
+		kinds = []string{k}
+	}
+
+	files, err := listRecordings(kinds)
+	if err != nil {
+		log.WithError(err).Error("Unable to list recordings")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to list recordings")
+		return
+	}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		files = files[:min(limit, len(files))]
+	}
+
+	writeAPIResponse(w, http.StatusOK, recordingsResponse{Files: files, Kinds: enabledRecordingKinds()})
+}
+
+// handleRecordingFile serves a stored file or (with the thumbnail
+// parameter) a downscaled JPEG preview of it
+func handleRecordingFile(w http.ResponseWriter, r *http.Request) {
+	getDir, ok := recordingKinds[r.PathValue("kind")]
+	if !ok || getDir() == "" {
+		writeAPIError(w, http.StatusNotFound, "Unknown or disabled kind")
+		return
+	}
+
+	root, err := os.OpenRoot(getDir())
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	defer root.Close()
+
+	name := r.PathValue("name")
+	f, err := root.Open(filepath.FromSlash(name))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeAPIError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	p := filepath.Join(getDir(), filepath.FromSlash(name))
+	q := r.URL.Query()
+
+	switch {
+	case q.Get("play") != "":
+		offset, err := strconv.ParseFloat(q.Get("play"), 64)
+		if err != nil || offset < 0 || isJPEGFile(name) {
+			writeAPIError(w, http.StatusBadRequest, "Invalid play offset")
+			return
+		}
+		playRecording(w, r, p, offset)
+
+	case q.Get("frame") != "" || q.Get("thumbnail") != "":
+		var (
+			offset float64
+			width  int
+		)
+
+		if q.Get("thumbnail") != "" {
+			width = recordingThumbnailWidth
+		} else if offset, err = strconv.ParseFloat(q.Get("frame"), 64); err != nil || offset < 0 {
+			writeAPIError(w, http.StatusBadRequest, "Invalid frame offset")
+			return
+		}
+
+		img, err := recordingFrame(r.Context(), p, offset, width)
+		if err != nil {
+			log.WithError(err).WithField("name", name).Error("Unable to extract frame")
+			writeAPIError(w, http.StatusInternalServerError, "Unable to extract frame")
+			return
+		}
+
+		// Stored files are not changed after being completed
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write(img)
+
+	default:
+		http.ServeContent(w, r, path.Base(name), info.ModTime(), f)
+	}
+}
+
+// recordingFrame returns the frame at the offset (in seconds) of the
+// video or the JPEG file, downscaled to the width if it is positive
+func recordingFrame(ctx context.Context, p string, offset float64, width int) ([]byte, error) {
+	if isJPEGFile(p) {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read file")
+		}
+		if width <= 0 {
+			return data, nil
+		}
+		return downscaleJPEG(data, width)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, recordingFrameTimeout)
+	defer cancel()
+
+	args := []string{
+		"-loglevel", "error",
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64),
+		"-i", p,
+		"-frames:v", "1",
+	}
+	if width > 0 {
+		args = append(args, "-vf", "scale="+strconv.Itoa(width)+":-2")
+	}
+	args = append(args, "-c:v", "mjpeg", "-f", "image2", "-")
+
+	stderr := new(bytes.Buffer)
+	cmd := ffmpegCommand(ctx, args...)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Unable to extract frame: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// playRecording streams the video from the offset (in seconds) at its
+// original speed as MJPEG as browsers are unable to play the recorded
+// MJPEG video streams
+func playRecording(w http.ResponseWriter, r *http.Request, p string, offset float64) {
+	cmd := ffmpegCommand(r.Context(),
+		"-loglevel", "error",
+		"-re",
+		"-ss", strconv.FormatFloat(offset, 'f', 3, 64),
+		"-i", p,
+		"-an",
+		"-c:v", "copy",
+		"-f", "mpjpeg",
+		"-boundary_tag", "ffmpeg",
+		"-")
+	cmd.Stdout = clientWriter(w)
+
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace;boundary=ffmpeg")
+
+	if err := cmd.Run(); err != nil && r.Context().Err() == nil {
+		log.WithError(err).WithField("path", p).Error("Unable to play recording")
+	}
+}
+
+// recordingTimeline returns the recorded segments and motion intervals
+// overlapping from - to. Segments start at the time in their name and
+// end at their last modification.
+func recordingTimeline(from, to time.Time) (timelineResponse, error) {
+	res := timelineResponse{From: from, Motion: motionIntervals(from, to), Segments: []timelineSegment{}, To: to}
+
+	files, err := listRecordings([]string{"record"})
+	if err != nil {
+		return res, err
+	}
+
+	for _, f := range files {
+		base := strings.TrimSuffix(path.Base(f.Name), path.Ext(f.Name))
+		start, err := time.ParseInLocation(recordingSegmentFormat, base, time.Local)
+		if err != nil || start.After(to) || f.Modified.Before(from) {
+			continue
+		}
+
+		res.Segments = append(res.Segments, timelineSegment{End: f.Modified, Name: f.Name, Start: start, URL: f.URL})
+	}
+
+	sort.Slice(res.Segments, func(i, j int) bool { return res.Segments[i].Start.Before(res.Segments[j].Start) })
+	return res, nil
+}
+
+// handleRecordingsTimeline returns the timeline of the last day or the
+// range given by the from / to parameters (RFC3339)
+func handleRecordingsTimeline(w http.ResponseWriter, r *http.Request) {
+	to := time.Now()
+	from := to.Add(-recordingTimelineRange)
+
+	for param, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(param); v != "" {
+			var err error
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				writeAPIError(w, http.StatusBadRequest, "Invalid "+param+" (expecting RFC3339)")
+				return
+			}
+		}
+	}
+
+	timeline, err := recordingTimeline(from, to)
+	if err != nil {
+		log.WithError(err).Error("Unable to build recordings timeline")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to build recordings timeline")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusOK, timeline)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultReplaySeconds = 10
+
+// frameRing keeps the frames of the last buffer duration, holding a
+// reference to each of them until it is dropped
+type frameRing struct {
+	frames []*frame
+	lock   sync.RWMutex
+	size   time.Duration
+}
+
+func newFrameRing(size time.Duration) *frameRing {
+	return &frameRing{size: size}
+}
+
+// Add appends the frame and drops frames captured more than the ring
+// size before it
+func (f *frameRing) Add(data *frame) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.frames = append(f.frames, data.Retain())
+
+	var drop int
+	for drop < len(f.frames) && data.Time.Sub(f.frames[drop].Time) > f.size {
+		drop++
+	}
+
+	if drop > 0 {
+		// Clear references to let the GC collect the dropped frames
+		for i := 0; i < drop; i++ {
+			f.frames[i].Release()
+			f.frames[i] = nil
+		}
+		f.frames = f.frames[drop:]
+	}
+}
+
+// DropOldest removes the oldest frame if more than one frame is
+// buffered and reports whether a frame was dropped
+func (f *frameRing) DropOldest() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if len(f.frames) < 2 {
+		return false
+	}
+
+	f.frames[0].Release()
+	f.frames[0] = nil
+	f.frames = f.frames[1:]
+	return true
+}
+
+// Since returns all buffered frames captured at or after t, they have
+// to be released using broadcast.ReleaseFrames
+func (f *frameRing) Since(t time.Time) []*frame {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	for i, fr := range f.frames {
+		if !fr.Time.Before(t) {
+			out := make([]*frame, len(f.frames)-i)
+			for j, bf := range f.frames[i:] {
+				out[j] = bf.Retain()
+			}
+			return out
+		}
+	}
+
+	return nil
+}
+
+// newReplayHandler returns the handler streaming the buffered frames
+// before continuing with the live frames
+func newReplayHandler() http.Handler {
+	hooks := mjpegHooks
+	hooks.Replay = replayFrames
+	stream := newMJPEGHandler(frameBroadcaster.Hub, "/replay", hooks)
+
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if frameBroadcaster.ring == nil {
+			http.Error(res, "404 Replay buffer disabled", http.StatusNotFound)
+			return
+		}
+
+		if _, err := replaySeconds(r); err != nil {
+			http.Error(res, "400 Invalid seconds", http.StatusBadRequest)
+			return
+		}
+
+		stream.ServeHTTP(res, r)
+	})
+}
+
+// replaySeconds returns the seconds to replay requested by the client
+func replaySeconds(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("seconds")
+	if v == "" {
+		return defaultReplaySeconds, nil
+	}
+
+	seconds, err := strconv.Atoi(v)
+	if err == nil && seconds < 1 {
+		err = errors.New("seconds must be positive")
+	}
+	return seconds, err
+}
+
+// replayFrames is the replay hook returning the buffered frames of the
+// requested seconds
+func replayFrames(r *http.Request, _ *subscriber) []*frame {
+	if isPrivacyEnabled() {
+		// Do not reveal the frames captured before privacy mode started
+		return nil
+	}
+
+	// Validated before serving the stream
+	seconds, _ := replaySeconds(r)
+	replay := frameBroadcaster.ring.Since(time.Now().Add(-time.Duration(seconds) * time.Second))
+	if len(replay) > 0 {
+		r.Context().Value(mjpegRequestKey{}).(*mjpegRequest).replayed = replay[len(replay)-1].Seq
+	}
+	return replay
+}
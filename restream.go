@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const pushEncoderProbeTimeout = 10 * time.Second
+
+var (
+	// restreamIngests maps the services accepted by --restream to the
+	// RTMP ingest the stream key is appended to
+	restreamIngests = map[string]string{
+		"twitch":  "rtmp://live.twitch.tv/app/",
+		"youtube": "rtmp://a.rtmp.youtube.com/live2/",
+	}
+
+	// pushEncoders contains the H.264 encoders in order of preference
+	// for --push-encoder=auto with their encoder specific arguments
+	pushEncoders = []struct {
+		Args []string
+		Name string
+	}{
+		{Name: "h264_v4l2m2m", Args: []string{"-pix_fmt", "yuv420p"}},
+		{Name: "h264_nvenc", Args: []string{"-pix_fmt", "yuv420p"}},
+		{Name: "h264_qsv", Args: []string{"-pix_fmt", "nv12", "-preset", "veryfast"}},
+		{Name: "libx264", Args: []string{"-pix_fmt", "yuv420p", "-preset", "veryfast", "-tune", "zerolatency"}},
+	}
+
+	pushEncoderOnce     sync.Once
+	pushEncoderSelected string
+)
+
+// parseRestream splits the --restream value into the ingest URL and
+// the service name
+func parseRestream(value string) (ingest, service string, err error) {
+	service, key, ok := strings.Cut(value, ":")
+	if !ok || key == "" {
+		return "", "", errors.New("Expecting service:streamkey")
+	}
+
+	prefix, ok := restreamIngests[service]
+	if !ok {
+		return "", "", errors.Errorf("Unknown service %q (youtube, twitch)", service)
+	}
+
+	return prefix + key, service, nil
+}
+
+// pushEncoder returns the H.264 encoder to push with, on auto the first
+// encoder able to encode a test frame is used
+func pushEncoder() string {
+	if cfg.PushEncoder != "auto" {
+		return cfg.PushEncoder
+	}
+
+	pushEncoderOnce.Do(func() {
+		for _, enc := range pushEncoders {
+			if enc.Name == "libx264" || probePushEncoder(enc.Name) == nil {
+				pushEncoderSelected = enc.Name
+				break
+			}
+		}
+		log.WithField("encoder", pushEncoderSelected).Info("Selected H.264 encoder for pushing")
+	})
+
+	return pushEncoderSelected
+}
+
+// pushEncoderArgs returns the arguments to select and tune the encoder,
+// unknown encoders get yuv420p input as most of them support it
+func pushEncoderArgs(encoder string) []string {
+	args := []string{"-c:v", encoder}
+	for _, enc := range pushEncoders {
+		if enc.Name == encoder {
+			return append(args, enc.Args...)
+		}
+	}
+	return append(args, "-pix_fmt", "yuv420p")
+}
+
+// probePushEncoder checks whether ffmpeg is able to use the encoder as
+// hardware encoders might be compiled in but not usable on the host
+func probePushEncoder(encoder string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), pushEncoderProbeTimeout)
+	defer cancel()
+
+	args := []string{
+		"-hide_banner", "-nostats", "-loglevel", "error",
+		"-f", "lavfi", "-i", "color=size=320x240:rate=1",
+		"-frames:v", "1",
+	}
+	args = append(args, pushEncoderArgs(encoder)...)
+
+	out, err := ffmpegCommand(ctx, append(args, "-f", "null", "-")...).CombinedOutput()
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"encoder": encoder,
+			"output":  strings.TrimSpace(string(out)),
+		}).Debug("H.264 encoder not usable")
+		return err
+	}
+
+	return nil
+}
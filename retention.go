@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	retentionInterval = time.Minute
+	// Files modified recently might still be written to (the current
+	// recording segment) and are never pruned
+	retentionMinAge = time.Minute
+)
+
+var (
+	maxDiskUsage int64
+	retentionAge time.Duration
+)
+
+type retentionFile struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+}
+
+// retentionDirs lists all directories the retention policy applies to
+func retentionDirs() []string {
+	var dirs []string
+	for _, d := range []string{cfg.MotionClipDir, cfg.RecordDir, cfg.SceneDir, cfg.SnapshotDir, cfg.TimelapseDir} {
+		if d != "" {
+			dirs = append(dirs, d)
+		}
+	}
+	return dirs
+}
+
+// runRetention periodically prunes files from the storage directories
+// until the context is cancelled
+func runRetention(ctx context.Context) {
+	t := time.NewTicker(retentionInterval)
+	defer t.Stop()
+
+	for {
+		if err := pruneStorage(time.Now()); err != nil {
+			log.WithError(err).Error("Unable to apply retention policy")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// pruneStorage removes files older than the retention age and afterwards
+// the oldest files until the total size is below the disk limit
+func pruneStorage(now time.Time) error {
+	var (
+		files []retentionFile
+		total int64
+	)
+
+	for _, dir := range retentionDirs() {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				// File removed while walking
+				return nil
+			}
+
+			files = append(files, retentionFile{Path: path, ModTime: info.ModTime(), Size: info.Size()})
+			total += info.Size()
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Unable to list directory %q", dir)
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime.Before(files[j].ModTime) })
+
+	var removed int
+	for _, f := range files {
+		if now.Sub(f.ModTime) < retentionMinAge {
+			// Sorted by time: all remaining files are recent too
+			break
+		}
+
+		expired := retentionAge > 0 && now.Sub(f.ModTime) > retentionAge
+		overLimit := maxDiskUsage > 0 && total > maxDiskUsage
+
+		if !expired && !overLimit {
+			break
+		}
+
+		if err := os.Remove(f.Path); err != nil && !os.IsNotExist(err) {
+			log.WithError(err).WithField("path", f.Path).Error("Unable to remove file")
+			continue
+		}
+
+		total -= f.Size
+		removed++
+		log.WithFields(log.Fields{
+			"expired": expired,
+			"path":    f.Path,
+		}).Debug("Pruned file")
+	}
+
+	if removed > 0 {
+		log.WithFields(log.Fields{
+			"files":     removed,
+			"remaining": total,
+		}).Info("Applied retention policy")
+	}
+
+	return nil
+}
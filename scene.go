@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// scenePixelThreshold is the luminance change of a pixel to count as
+// changed when comparing scenes
+const scenePixelThreshold = 25
+
+// runSceneArchive stores a frame whenever the scene changed more than
+// the configured fraction since the last stored frame until the
+// context is cancelled
+func runSceneArchive(ctx context.Context) {
+	logger := log.WithFields(log.Fields{
+		"camera": cfg.Device,
+		"dir":    cfg.SceneDir,
+	})
+
+	if err := os.MkdirAll(cfg.SceneDir, 0o755); err != nil {
+		logger.WithError(err).Error("Unable to create scene directory, scene archive disabled")
+		return
+	}
+
+	t := time.NewTicker(cfg.SceneInterval)
+	defer t.Stop()
+
+	var (
+		last   *grayFrame
+		pixels []int
+	)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		imgCtx, cancel := context.WithTimeout(ctx, timelapseGrabTimeout)
+		img, err := frameBroadcaster.NextFrame(imgCtx)
+		cancel()
+		if err != nil {
+			logger.WithError(err).Error("Unable to grab scene frame")
+			continue
+		}
+
+		frame, err := decodeGrayFrame(img.Data, motionAnalysisWidth)
+		if err != nil {
+			img.Release()
+			logger.WithError(err).Debug("Unable to decode scene frame")
+			continue
+		}
+
+		if last != nil && last.Width == frame.Width && last.Height == frame.Height {
+			if area := changedArea(last, frame, pixels, scenePixelThreshold); area < cfg.SceneThreshold {
+				img.Release()
+				continue
+			}
+		}
+
+		path := filepath.Join(cfg.SceneDir, img.Time.Format(timelapseFileFormat)+".jpg")
+		err = os.WriteFile(path, img.Data, 0o644)
+		img.Release()
+		if err != nil {
+			logger.WithError(err).Error("Unable to write scene frame")
+			continue
+		}
+
+		if last == nil || len(pixels) != len(frame.Pix) {
+			pixels = make([]int, len(frame.Pix))
+			for i := range pixels {
+				pixels[i] = i
+			}
+		}
+		last = frame
+
+		logger.WithField("path", path).Debug("Scene change stored")
+		queueUpload("scene", path)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	recordSourceSchedule = "schedule"
+	scheduleInterval     = 30 * time.Second
+)
+
+var (
+	recordSchedule []scheduleWindow
+
+	weekdayNames = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+)
+
+// scheduleWindow is a daily time window on the given weekdays, windows
+// ending before they start span midnight and belong to their start day
+type scheduleWindow struct {
+	Days     [7]bool
+	Start    time.Duration
+	End      time.Duration
+	Original string
+}
+
+// parseScheduleWindow parses windows like "mon-fri 08:00-18:00",
+// "sat,sun 10:00-14:00", "* 22:00-06:00" or "08:00-18:00" (every day)
+func parseScheduleWindow(in string) (scheduleWindow, error) {
+	w := scheduleWindow{Original: in}
+
+	fields := strings.Fields(strings.ToLower(in))
+	switch len(fields) {
+	case 1:
+		fields = append([]string{"*"}, fields...)
+	case 2:
+	default:
+		return w, errors.Errorf("Invalid schedule %q", in)
+	}
+
+	if err := w.parseDays(fields[0]); err != nil {
+		return w, errors.Wrapf(err, "Invalid schedule %q", in)
+	}
+
+	times := strings.SplitN(fields[1], "-", 2)
+	if len(times) != 2 {
+		return w, errors.Errorf("Invalid schedule %q: expecting time range HH:MM-HH:MM", in)
+	}
+
+	var err error
+	if w.Start, err = parseTimeOfDay(times[0]); err != nil {
+		return w, errors.Wrapf(err, "Invalid schedule %q", in)
+	}
+	if w.End, err = parseTimeOfDay(times[1]); err != nil {
+		return w, errors.Wrapf(err, "Invalid schedule %q", in)
+	}
+
+	if w.Start == w.End {
+		return w, errors.Errorf("Invalid schedule %q: empty time range", in)
+	}
+
+	return w, nil
+}
+
+func (s *scheduleWindow) parseDays(in string) error {
+	if in == "*" {
+		for i := range s.Days {
+			s.Days[i] = true
+		}
+		return nil
+	}
+
+	for _, part := range strings.Split(in, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		from, ok := weekdayNames[bounds[0]]
+		if !ok {
+			return errors.Errorf("Unknown weekday %q", bounds[0])
+		}
+
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdayNames[bounds[1]]; !ok {
+				return errors.Errorf("Unknown weekday %q", bounds[1])
+			}
+		}
+
+		// Ranges may wrap around the week (fri-mon)
+		for d := from; ; d = (d + 1) % 7 {
+			s.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Until returns the end of the window containing t or false if t is not
+// within the window
+func (s scheduleWindow) Until(t time.Time) (time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	sinceMidnight := t.Sub(midnight)
+
+	if s.Start < s.End {
+		if s.Days[t.Weekday()] && sinceMidnight >= s.Start && sinceMidnight < s.End {
+			return midnight.Add(s.End), true
+		}
+		return time.Time{}, false
+	}
+
+	// Window spans midnight: either started today or yesterday
+	if s.Days[t.Weekday()] && sinceMidnight >= s.Start {
+		return midnight.AddDate(0, 0, 1).Add(s.End), true
+	}
+
+	if s.Days[(t.Weekday()+6)%7] && sinceMidnight < s.End {
+		return midnight.Add(s.End), true
+	}
+
+	return time.Time{}, false
+}
+
+func parseTimeOfDay(in string) (time.Duration, error) {
+	parts := strings.SplitN(in, ":", 2)
+	if len(parts) != 2 {
+		return 0, errors.Errorf("Invalid time %q", in)
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, errors.Errorf("Invalid hour in %q", in)
+	}
+
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, errors.Errorf("Invalid minute in %q", in)
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// runSchedule requests recordings while inside any of the configured
+// schedule windows until the context is cancelled
+func runSchedule(ctx context.Context) {
+	t := time.NewTicker(scheduleInterval)
+	defer t.Stop()
+
+	var active bool
+	for {
+		var (
+			now    = time.Now()
+			inside bool
+			until  time.Time
+		)
+
+		for _, w := range recordSchedule {
+			if u, ok := w.Until(now); ok && u.After(until) {
+				inside, until = true, u
+			}
+		}
+
+		switch {
+		case inside:
+			recordControl.Request(recordSourceSchedule, until)
+		case active:
+			recordControl.Release(recordSourceSchedule)
+		}
+
+		if inside != active {
+			log.WithFields(log.Fields{
+				"active": inside,
+				"until":  until,
+			}).Info("Recording schedule changed")
+			active = inside
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const (
+	recordSourceScript = "script"
+
+	scriptEventTick = "tick"
+	scriptHandler   = "on_event"
+	scriptTimeout   = 30 * time.Second
+)
+
+// script is a loaded Starlark script handling the events of the bus
+// and the schedule ticks in its on_event function
+type script struct {
+	handler     starlark.Value
+	predeclared starlark.StringDict
+}
+
+// loadScript executes the Starlark script at the given path, actions
+// called by the script are executed within the context
+func loadScript(ctx context.Context, filename string) (*script, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read script")
+	}
+
+	s := &script{predeclared: starlark.StringDict{
+		"camera": starlark.String(cfg.Device),
+		"json":   starjson.Module,
+		// state is not frozen after loading so handlers can keep values
+		// between events
+		"state": starlark.NewDict(0),
+	}}
+	for name, fn := range map[string]func(string, starlark.Tuple, []starlark.Tuple) (hookAction, error){
+		"record":       scriptRecordArgs,
+		"record_stop":  scriptNoArgs,
+		"set_controls": scriptControlsArgs,
+		"snapshot":     scriptSnapshotArgs,
+		"webhook":      scriptWebhookArgs,
+	} {
+		s.predeclared[name] = starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			act, err := fn(b.Name(), args, kwargs)
+			if err != nil {
+				return nil, err
+			}
+			act.Action = b.Name()
+
+			return starlark.None, execHookAction(ctx, recordSourceScript, act, nil)
+		})
+	}
+
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, newScriptThread("load"), filename, src, s.predeclared)
+	if err != nil {
+		return nil, errors.Wrap(scriptError(err), "Unable to execute script")
+	}
+
+	if s.handler = globals[scriptHandler]; s.handler == nil {
+		return nil, errors.Errorf("Script does not define %s(event)", scriptHandler)
+	}
+	if _, ok := s.handler.(starlark.Callable); !ok {
+		return nil, errors.Errorf("Script %s is not a function", scriptHandler)
+	}
+
+	return s, nil
+}
+
+// run passes every event of the bus and a tick event every script tick
+// interval to the handler of the script until the context is cancelled
+func (s *script) run(ctx context.Context) {
+	c, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	var tick <-chan time.Time
+	if cfg.ScriptTick > 0 {
+		t := time.NewTicker(cfg.ScriptTick)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	for {
+		var evt busEvent
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-tick:
+			evt = busEvent{Time: now, Type: scriptEventTick}
+
+		case e, ok := <-c:
+			if !ok {
+				return
+			}
+			evt = e
+		}
+
+		if err := s.handle(ctx, evt); err != nil {
+			log.WithError(err).WithField("type", evt.Type).Error("Script failed to handle event")
+		}
+	}
+}
+
+// handle calls the handler with the event as it is sent to the
+// clients of /api/v1/events
+func (s *script) handle(ctx context.Context, evt busEvent) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal event")
+	}
+
+	thread := newScriptThread(evt.Type)
+	ctx, cancel := context.WithTimeout(ctx, scriptTimeout)
+	defer cancel()
+	defer context.AfterFunc(ctx, func() { thread.Cancel("timeout") })()
+
+	arg, err := starlark.Call(thread, starjson.Module.Members["decode"], starlark.Tuple{starlark.String(data)}, nil)
+	if err != nil {
+		return errors.Wrap(err, "Unable to convert event")
+	}
+
+	_, err = starlark.Call(thread, s.handler, starlark.Tuple{arg}, nil)
+	return scriptError(err)
+}
+
+func newScriptThread(name string) *starlark.Thread {
+	return &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.WithField("component", "script").Info(msg)
+		},
+	}
+}
+
+// scriptError adds the Starlark backtrace to evaluation errors
+func scriptError(err error) error {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+func scriptNoArgs(name string, args starlark.Tuple, kwargs []starlark.Tuple) (hookAction, error) {
+	return hookAction{}, starlark.UnpackArgs(name, args, kwargs)
+}
+
+func scriptRecordArgs(name string, args starlark.Tuple, kwargs []starlark.Tuple) (hookAction, error) {
+	var act hookAction
+	err := starlark.UnpackArgs(name, args, kwargs, "duration?", &act.Duration)
+	return act, err
+}
+
+// scriptControlsArgs accepts the controls as dict and / or keyword
+// arguments
+func scriptControlsArgs(name string, args starlark.Tuple, kwargs []starlark.Tuple) (hookAction, error) {
+	var controls *starlark.Dict
+	if err := starlark.UnpackPositionalArgs(name, args, nil, 0, &controls); err != nil {
+		return hookAction{}, err
+	}
+
+	act := hookAction{Controls: map[string]int32{}}
+	var items []starlark.Tuple
+	if controls != nil {
+		items = controls.Items()
+	}
+	for _, kv := range append(items, kwargs...) {
+		control, ok := starlark.AsString(kv[0])
+		if !ok {
+			return act, errors.Errorf("Control name %s is not a string", kv[0])
+		}
+
+		var value int32
+		if err := starlark.AsInt(kv[1], &value); err != nil {
+			return act, errors.Wrapf(err, "Invalid value of control %s", control)
+		}
+		act.Controls[control] = value
+	}
+
+	return act, nil
+}
+
+func scriptSnapshotArgs(name string, args starlark.Tuple, kwargs []starlark.Tuple) (hookAction, error) {
+	var act hookAction
+	err := starlark.UnpackArgs(name, args, kwargs, "label?", &act.Label)
+	return act, err
+}
+
+func scriptWebhookArgs(name string, args starlark.Tuple, kwargs []starlark.Tuple) (hookAction, error) {
+	var (
+		act     hookAction
+		payload starlark.Value = starlark.None
+	)
+	if err := starlark.UnpackArgs(name, args, kwargs, "url", &act.URL, "payload?", &payload); err != nil {
+		return act, err
+	}
+
+	data, err := starlark.Call(&starlark.Thread{}, starjson.Module.Members["encode"], starlark.Tuple{payload}, nil)
+	if err != nil {
+		return act, errors.Wrap(err, "Unable to encode payload")
+	}
+	act.Payload = json.RawMessage(data.(starlark.String).GoString())
+
+	return act, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const snapshotGrabTimeout = 10 * time.Second
+
+var (
+	snapshotFilename *template.Template
+	snapshotLabelRe  = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+// snapshotFilenameVars holds the variables available in the snapshot
+// filename template
+type snapshotFilenameVars struct {
+	Camera   string
+	Hostname string
+	Label    string
+	Time     time.Time
+}
+
+type snapshotResponse struct {
+	Path string `json:"path"`
+	URL  string `json:"url"`
+}
+
+// handleSnapshotSave stores the next frame into the snapshot directory
+// and returns path and URL of the stored file
+func handleSnapshotSave(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), snapshotGrabTimeout)
+	defer cancel()
+
+	img, err := frameBroadcaster.NextFrame(ctx)
+	if err != nil {
+		log.WithError(err).Error("Unable to grab snapshot frame")
+		writeAPIError(w, http.StatusServiceUnavailable, "No frame available")
+		return
+	}
+	defer img.Release()
+
+	resp, err := saveSnapshot(img.Data, r.FormValue("label"), img.Time)
+	if err != nil {
+		log.WithError(err).Error("Unable to store snapshot")
+		writeAPIError(w, http.StatusInternalServerError, "Unable to store snapshot")
+		return
+	}
+
+	writeAPIResponse(w, http.StatusCreated, resp)
+}
+
+// saveSnapshot stores the frame into the snapshot directory and queues
+// it for upload
+func saveSnapshot(img []byte, label string, t time.Time) (snapshotResponse, error) {
+	name, err := snapshotName(label, t)
+	if err != nil {
+		return snapshotResponse{}, errors.Wrap(err, "Unable to render snapshot filename")
+	}
+
+	p := filepath.Join(cfg.SnapshotDir, filepath.FromSlash(name))
+	if err = os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return snapshotResponse{}, errors.Wrap(err, "Unable to create snapshot directory")
+	}
+
+	if err = os.WriteFile(p, img, 0o644); err != nil {
+		return snapshotResponse{}, errors.Wrap(err, "Unable to write snapshot")
+	}
+
+	log.WithField("path", p).Info("Snapshot stored")
+	queueUpload("snapshot", p)
+
+	return snapshotResponse{
+		Path: p,
+		URL:  publicURL((&url.URL{Path: "/snapshots/" + name}).EscapedPath()),
+	}, nil
+}
+
+// snapshotName renders the filename template and ensures the result
+// stays within the snapshot directory
+func snapshotName(label string, t time.Time) (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", errors.Wrap(err, "Unable to determine hostname")
+	}
+
+	buf := new(bytes.Buffer)
+	if err = snapshotFilename.Execute(buf, snapshotFilenameVars{
+		Camera:   strings.Trim(strings.ReplaceAll(cfg.Device, "/", "_"), "_"),
+		Hostname: hostname,
+		Label:    snapshotLabelRe.ReplaceAllString(label, "_"),
+		Time:     t,
+	}); err != nil {
+		return "", errors.Wrap(err, "Unable to execute template")
+	}
+
+	name := path.Clean("/" + strings.TrimSpace(buf.String()))[1:]
+	if name == "" {
+		return "", errors.New("Template rendered empty filename")
+	}
+
+	return name, nil
+}
+
+// publicURL prefixes the path with the configured public URL
+func publicURL(path string) string {
+	return strings.TrimRight(cfgValue(&cfg.PublicURL), "/") + path
+}
+
+// downscaleJPEG resizes the JPEG to the given width keeping the aspect
+// ratio by averaging blocks of pixels, smaller images are returned as is
+func downscaleJPEG(data []byte, width int) ([]byte, error) {
+	src, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to decode frame")
+	}
+
+	bounds := src.Bounds()
+	if bounds.Dx() <= width {
+		return data, nil
+	}
+
+	var (
+		height = max(bounds.Dy()*width/bounds.Dx(), 1)
+		rect   = image.Rect(0, 0, width, height)
+		dst    image.Image
+	)
+
+	// Fast path: JPEGs usually decode to YCbCr, its planes are scaled
+	// separately keeping the chroma subsampling
+	switch s := src.(type) {
+	case *image.YCbCr:
+		d := image.NewYCbCr(rect, s.SubsampleRatio)
+		scalePlane(
+			imagePlane{d.Y, d.YStride, width, height},
+			imagePlane{s.Y[s.YOffset(bounds.Min.X, bounds.Min.Y):], s.YStride, bounds.Dx(), bounds.Dy()},
+		)
+
+		var (
+			dw, dh = chromaSize(width, height, s.SubsampleRatio)
+			sw, sh = chromaSize(bounds.Dx(), bounds.Dy(), s.SubsampleRatio)
+			offset = s.COffset(bounds.Min.X, bounds.Min.Y)
+		)
+		scalePlane(imagePlane{d.Cb, d.CStride, dw, dh}, imagePlane{s.Cb[offset:], s.CStride, sw, sh})
+		scalePlane(imagePlane{d.Cr, d.CStride, dw, dh}, imagePlane{s.Cr[offset:], s.CStride, sw, sh})
+		dst = d
+
+	case *image.Gray:
+		d := image.NewGray(rect)
+		scalePlane(
+			imagePlane{d.Pix, d.Stride, width, height},
+			imagePlane{s.Pix[s.PixOffset(bounds.Min.X, bounds.Min.Y):], s.Stride, bounds.Dx(), bounds.Dy()},
+		)
+		dst = d
+
+	default:
+		// CMYK JPEGs are rare, take the generic way
+		d := image.NewRGBA(rect)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				d.Set(x, y, src.At(bounds.Min.X+x*bounds.Dx()/width, bounds.Min.Y+y*bounds.Dy()/height))
+			}
+		}
+		dst = d
+	}
+
+	buf := new(bytes.Buffer)
+	if err = jpeg.Encode(buf, dst, nil); err != nil {
+		return nil, errors.Wrap(err, "Unable to encode frame")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// imagePlane is a single channel of an image
+type imagePlane struct {
+	Pix           []uint8
+	Stride        int
+	Width, Height int
+}
+
+// scalePlane fills dst with the averages of the blocks of src it
+// covers, dst must not be larger than src
+func scalePlane(dst, src imagePlane) {
+	for y := 0; y < dst.Height; y++ {
+		y0 := y * src.Height / dst.Height
+		y1 := max((y+1)*src.Height/dst.Height, y0+1)
+
+		for x := 0; x < dst.Width; x++ {
+			x0 := x * src.Width / dst.Width
+			x1 := max((x+1)*src.Width/dst.Width, x0+1)
+
+			var sum, n int
+			for sy := y0; sy < y1; sy++ {
+				for _, v := range src.Pix[sy*src.Stride+x0 : sy*src.Stride+x1] {
+					sum += int(v)
+				}
+				n += x1 - x0
+			}
+
+			dst.Pix[y*dst.Stride+x] = uint8(sum / n)
+		}
+	}
+}
+
+// chromaSize returns the size of the chroma planes of a YCbCr image
+// of the given size
+func chromaSize(w, h int, ratio image.YCbCrSubsampleRatio) (int, int) {
+	switch ratio {
+	case image.YCbCrSubsampleRatio422:
+		return (w + 1) / 2, h
+	case image.YCbCrSubsampleRatio420:
+		return (w + 1) / 2, (h + 1) / 2
+	case image.YCbCrSubsampleRatio440:
+		return w, (h + 1) / 2
+	case image.YCbCrSubsampleRatio411:
+		return (w + 3) / 4, h
+	case image.YCbCrSubsampleRatio410:
+		return (w + 3) / 4, (h + 1) / 2
+	default:
+		return w, h
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ssdpAddr           = "239.255.255.250:1900"
+	ssdpDescriptionURL = "/upnp/description.xml"
+	ssdpDeviceType     = "urn:schemas-upnp-org:device:Basic:1"
+	ssdpMaxAge         = 30 * time.Minute
+	ssdpNotifyInterval = ssdpMaxAge / 2
+)
+
+// ssdpTargets returns the search targets answered for, including the
+// unique device name of the camera
+func ssdpTargets() []string {
+	return []string{"upnp:rootdevice", "uuid:" + cameraUUID(), ssdpDeviceType}
+}
+
+// ssdpUSN returns the unique service name for the search target
+func ssdpUSN(target string) string {
+	udn := "uuid:" + cameraUUID()
+	if target == udn {
+		return udn
+	}
+	return udn + "::" + target
+}
+
+// runSSDP answers M-SEARCH requests and periodically announces the
+// camera until the context is cancelled
+func runSSDP(ctx context.Context) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		log.WithError(err).Error("Unable to resolve SSDP address")
+		return
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.WithError(err).Error("Unable to listen for SSDP searches, SSDP disabled")
+		return
+	}
+	defer conn.Close()
+
+	go ssdpNotifyLoop(ctx, group)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.WithField("addr", ssdpAddr).Info("Answering SSDP searches")
+
+	buf := make([]byte, 2048)
+	for {
+		n, sender, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("Unable to read SSDP search")
+			}
+			return
+		}
+
+		req := string(buf[:n])
+		if !strings.HasPrefix(req, "M-SEARCH * HTTP/1.1") || ssdpHeader(req, "MAN") != `"ssdp:discover"` {
+			continue
+		}
+
+		st := ssdpHeader(req, "ST")
+		for _, target := range ssdpTargets() {
+			if st != "ssdp:all" && st != target {
+				continue
+			}
+
+			msg, err := ssdpMessage(sender, "HTTP/1.1 200 OK", map[string]string{
+				"EXT": "",
+				"ST":  target,
+				"USN": ssdpUSN(target),
+			})
+			if err != nil {
+				log.WithError(err).Debug("Unable to build SSDP response")
+				continue
+			}
+
+			if _, err = conn.WriteToUDP(msg, sender); err != nil {
+				log.WithError(err).WithField("to", sender.String()).Debug("Unable to send SSDP response")
+			}
+		}
+	}
+}
+
+// ssdpNotifyLoop sends alive notifications to the multicast group and
+// a byebye when the context is cancelled
+func ssdpNotifyLoop(ctx context.Context, group *net.UDPAddr) {
+	send := func(nts string) {
+		conn, err := net.DialUDP("udp4", nil, group)
+		if err != nil {
+			log.WithError(err).Debug("Unable to send SSDP notification")
+			return
+		}
+		defer conn.Close()
+
+		for _, target := range ssdpTargets() {
+			msg, err := ssdpMessage(group, "NOTIFY * HTTP/1.1", map[string]string{
+				"HOST": ssdpAddr,
+				"NT":   target,
+				"NTS":  nts,
+				"USN":  ssdpUSN(target),
+			})
+			if err != nil {
+				log.WithError(err).Debug("Unable to build SSDP notification")
+				return
+			}
+			conn.Write(msg)
+		}
+	}
+
+	t := time.NewTicker(ssdpNotifyInterval)
+	defer t.Stop()
+
+	for {
+		send("ssdp:alive")
+
+		select {
+		case <-ctx.Done():
+			send("ssdp:byebye")
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// ssdpMessage formats a SSDP message with the location of the device
+// description reachable by the peer
+func ssdpMessage(peer *net.UDPAddr, status string, headers map[string]string) ([]byte, error) {
+	base, err := discoveryBaseURL(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	b.WriteString(status + "\r\n")
+	fmt.Fprintf(&b, "CACHE-CONTROL: max-age=%d\r\n", int(ssdpMaxAge.Seconds()))
+	fmt.Fprintf(&b, "LOCATION: %s%s\r\n", base, ssdpDescriptionURL)
+	fmt.Fprintf(&b, "SERVER: Linux/1.0 UPnP/1.0 cam2mjpeg/%s\r\n", version)
+	for _, k := range []string{"EXT", "HOST", "NT", "NTS", "ST", "USN"} {
+		if v, ok := headers[k]; ok {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+		}
+	}
+	b.WriteString("\r\n")
+
+	return []byte(b.String()), nil
+}
+
+// ssdpHeader extracts a header value from a SSDP request
+func ssdpHeader(req, name string) string {
+	for _, line := range strings.Split(req, "\r\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.TrimSpace(k), name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// handleSSDPDescription serves the UPnP device description
+func handleSSDPDescription(w http.ResponseWriter, r *http.Request) {
+	base := "http://" + r.Host
+	if u := cfgValue(&cfg.PublicURL); u != "" {
+		base = strings.TrimRight(u, "/")
+	}
+
+	hostname, _ := os.Hostname()
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+		`<root xmlns="urn:schemas-upnp-org:device-1-0"><specVersion><major>1</major><minor>0</minor></specVersion>`+
+		`<URLBase>%s</URLBase><device><deviceType>%s</deviceType><friendlyName>%s</friendlyName>`+
+		`<manufacturer>cam2mjpeg</manufacturer><modelName>%s</modelName><modelNumber>%s</modelNumber>`+
+		`<UDN>uuid:%s</UDN><presentationURL>%s/mjpeg</presentationURL></device></root>`,
+		xmlEscape(base), ssdpDeviceType, xmlEscape("cam2mjpeg ("+hostname+")"),
+		xmlEscape(cameraModel()), xmlEscape(version), cameraUUID(), xmlEscape(base))
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// sdNotify sends the state to the systemd notification socket, it is
+// a no-op when not started by systemd with notify support
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	if path[0] == '@' {
+		// Abstract namespace socket
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return errors.Wrap(err, "Unable to connect to notify socket")
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return errors.Wrap(err, "Unable to write to notify socket")
+}
+
+// sdWatchdogInterval returns the watchdog timeout configured for this
+// process by systemd or zero when the watchdog is disabled
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// runSystemdNotify reports readiness as soon as frames are flowing
+// (immediately in on-demand mode) and sends watchdog heartbeats while
+// frames are produced until the context is cancelled
+func runSystemdNotify(ctx context.Context) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+
+	timeout := sdWatchdogInterval()
+	log.WithField("watchdog", timeout).Debug("Reporting status to systemd")
+
+	t := time.NewTicker(time.Second)
+	if timeout > 0 {
+		t.Reset(timeout / 2)
+	}
+	defer t.Stop()
+
+	var ready bool
+	for {
+		if !ready && (cfg.OnDemand || !lastFrameTime().IsZero()) {
+			if err := sdNotify("READY=1\nSTATUS=Streaming " + cfg.Device); err != nil {
+				log.WithError(err).Error("Unable to notify systemd about readiness")
+			}
+			ready = true
+		}
+
+		if ready && timeout > 0 && framesFlowing(timeout) {
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				log.WithError(err).Error("Unable to send systemd watchdog heartbeat")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// framesFlowing reports whether a frame was produced within the timeout
+// or the capture was stopped for lack of viewers. A freshly
+// (re-)started ffmpeg gets the full timeout for its first frame.
+func framesFlowing(timeout time.Duration) bool {
+	if cfg.OnDemand && !isCapturing() {
+		return true
+	}
+
+	last, ref := lastFrameTime(), captureStartTime()
+	if last.After(ref) {
+		ref = last
+	}
+
+	return time.Since(ref) < timeout
+}
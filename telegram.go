@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const telegramTimeout = 30 * time.Second
+
+var telegramAPIURL = "https://api.telegram.org"
+
+type telegramNotifyRequest struct {
+	Caption string `json:"caption"`
+}
+
+// sendTelegramPhoto sends the JPEG with the caption to the configured
+// chat using the Bot API
+func sendTelegramPhoto(caption string, jpg []byte) error {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	for k, v := range map[string]string{"caption": caption, "chat_id": cfg.TelegramChatID} {
+		if err := mw.WriteField(k, v); err != nil {
+			return errors.Wrapf(err, "Unable to write %s field", k)
+		}
+	}
+
+	part, err := mw.CreateFormFile("photo", "snapshot.jpg")
+	if err != nil {
+		return errors.Wrap(err, "Unable to create photo part")
+	}
+
+	if _, err = part.Write(jpg); err != nil {
+		return errors.Wrap(err, "Unable to write photo part")
+	}
+
+	if err = mw.Close(); err != nil {
+		return errors.Wrap(err, "Unable to finish multipart body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), telegramTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/bot%s/sendPhoto", telegramAPIURL, cfg.TelegramToken), body)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create request")
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("User-Agent", "cam2mjpeg/"+version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			// Do not leak the bot token contained in the URL into the logs
+			err = ue.Err
+		}
+		return errors.Wrap(err, "Unable to execute request")
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Description string `json:"description"`
+		OK          bool   `json:"ok"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return errors.Wrapf(err, "Unable to decode response (HTTP status %d)", resp.StatusCode)
+	}
+
+	if !result.OK {
+		return errors.Errorf("Telegram rejected the message: %s", result.Description)
+	}
+
+	return nil
+}
+
+// notifyTelegramMotion is the motion event listener sending the frame
+// the motion started with
+func notifyTelegramMotion(evt motionEvent) {
+	if evt.Type != motionEventStart {
+		return
+	}
+
+	caption := fmt.Sprintf("Motion detected on %s at %s", cfg.Device, evt.Time.Format("2006-01-02 15:04:05"))
+	if len(evt.Zones) > 0 {
+		caption += fmt.Sprintf(" (zones: %v)", evt.Zones)
+	}
+
+	go func() {
+		if err := sendTelegramPhoto(caption, evt.Frame); err != nil {
+			log.WithError(err).Error("Unable to send Telegram motion notification")
+		}
+	}()
+}
+
+// handleTelegramNotify sends the current frame to the configured chat
+func handleTelegramNotify(w http.ResponseWriter, r *http.Request) {
+	var req telegramNotifyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Unable to parse notification request")
+			return
+		}
+	}
+
+	if req.Caption == "" {
+		req.Caption = fmt.Sprintf("Snapshot of %s at %s", cfg.Device, time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), snapshotGrabTimeout)
+	defer cancel()
+
+	img, err := frameBroadcaster.NextFrame(ctx)
+	if err != nil {
+		writeAPIError(w, http.StatusServiceUnavailable, "No frame available")
+		return
+	}
+	defer img.Release()
+
+	if err = sendTelegramPhoto(req.Caption, img.Data); err != nil {
+		log.WithError(err).Error("Unable to send Telegram notification")
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
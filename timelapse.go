@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTimelapseFPS  = 25
+	timelapseFileFormat  = "2006-01-02_15-04-05"
+	timelapseGrabTimeout = 30 * time.Second
+)
+
+var timelapseRenderLock = new(sync.Mutex)
+
+// runTimelapse stores one frame per configured interval into the
+// timelapse directory until the context is cancelled
+func runTimelapse(ctx context.Context) {
+	logger := log.WithFields(log.Fields{
+		"camera": cfg.Device,
+		"dir":    cfg.TimelapseDir,
+	})
+
+	if err := os.MkdirAll(cfg.TimelapseDir, 0o755); err != nil {
+		logger.WithError(err).Error("Unable to create timelapse directory, timelapse disabled")
+		return
+	}
+
+	t := time.NewTicker(cfg.TimelapseInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		imgCtx, cancel := context.WithTimeout(ctx, timelapseGrabTimeout)
+		img, err := frameBroadcaster.NextFrame(imgCtx)
+		cancel()
+		if err != nil {
+			logger.WithError(err).Error("Unable to grab timelapse frame")
+			continue
+		}
+
+		path := filepath.Join(cfg.TimelapseDir, img.Time.Format(timelapseFileFormat)+".jpg")
+		err = os.WriteFile(path, img.Data, 0o644)
+		img.Release()
+		if err != nil {
+			logger.WithError(err).Error("Unable to write timelapse frame")
+			continue
+		}
+
+		logger.WithField("path", path).Debug("Timelapse frame stored")
+	}
+}
+
+// handleTimelapseRender renders the stored timelapse frames (optionally
+// limited by from / to timestamps) into a MP4 video
+func handleTimelapseRender(w http.ResponseWriter, r *http.Request) {
+	var (
+		err      error
+		fps      = defaultTimelapseFPS
+		from, to time.Time
+	)
+
+	if v := r.URL.Query().Get("fps"); v != "" {
+		if fps, err = strconv.Atoi(v); err != nil || fps < 1 || fps > 120 {
+			http.Error(w, "400 Invalid fps", http.StatusBadRequest)
+			return
+		}
+	}
+
+	for param, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := r.URL.Query().Get(param); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "400 Invalid "+param+" (expecting RFC3339)", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	frames, err := listTimelapseFrames(from, to)
+	if err != nil {
+		log.WithError(err).Error("Unable to list timelapse frames")
+		http.Error(w, "500 Unable to list timelapse frames", http.StatusInternalServerError)
+		return
+	}
+
+	if len(frames) == 0 {
+		http.Error(w, "404 No timelapse frames found", http.StatusNotFound)
+		return
+	}
+
+	// Rendering is expensive: do not render multiple videos in parallel
+	timelapseRenderLock.Lock()
+	defer timelapseRenderLock.Unlock()
+
+	video, err := renderTimelapse(r.Context(), frames, fps)
+	if err != nil {
+		log.WithError(err).Error("Unable to render timelapse")
+		http.Error(w, "500 Unable to render timelapse", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(video.Name())
+	defer video.Close()
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Disposition", `inline; filename="timelapse.mp4"`)
+	http.ServeContent(w, r, "timelapse.mp4", time.Now(), video)
+}
+
+// listTimelapseFrames returns the sorted paths of all timelapse frames
+// taken between from and to (zero times are ignored)
+func listTimelapseFrames(from, to time.Time) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(cfg.TimelapseDir, "*.jpg"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to list directory")
+	}
+
+	var frames []string
+	for _, m := range matches {
+		t, err := time.ParseInLocation(timelapseFileFormat, strings.TrimSuffix(filepath.Base(m), ".jpg"), time.Local)
+		if err != nil {
+			// Not one of our files
+			continue
+		}
+
+		if (!from.IsZero() && t.Before(from)) || (!to.IsZero() && t.After(to)) {
+			continue
+		}
+
+		frames = append(frames, m)
+	}
+
+	sort.Strings(frames)
+	return frames, nil
+}
+
+func renderTimelapse(ctx context.Context, frames []string, fps int) (*os.File, error) {
+	out, err := os.CreateTemp("", "cam2mjpeg-timelapse-*.mp4")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create temporary file")
+	}
+	out.Close()
+
+	cmd := ffmpegCommand(ctx,
+		"-hide_banner", "-nostats", "-y",
+		"-f", "image2pipe",
+		"-framerate", strconv.Itoa(fps),
+		"-i", "pipe:0",
+		"-c:v", "libx264",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		out.Name(),
+	)
+
+	stderr := ffmpegLogWriter("timelapse")
+	defer stderr.Close()
+	cmd.Stderr = stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		os.Remove(out.Name())
+		return nil, errors.Wrap(err, "Unable to create stdin pipe")
+	}
+
+	if err = cmd.Start(); err != nil {
+		os.Remove(out.Name())
+		return nil, errors.Wrap(err, "Unable to spawn ffmpeg")
+	}
+
+	writeErr := func() error {
+		defer stdin.Close()
+
+		for _, frame := range frames {
+			data, err := os.ReadFile(frame)
+			if err != nil {
+				return errors.Wrap(err, "Unable to read frame")
+			}
+
+			if _, err = stdin.Write(data); err != nil {
+				return errors.Wrap(err, "Unable to write frame")
+			}
+		}
+
+		return nil
+	}()
+
+	if err = cmd.Wait(); err != nil || writeErr != nil {
+		os.Remove(out.Name())
+		if writeErr != nil {
+			return nil, writeErr
+		}
+		return nil, errors.Wrap(err, "ffmpeg failed")
+	}
+
+	f, err := os.Open(out.Name())
+	if err != nil {
+		os.Remove(out.Name())
+		return nil, errors.Wrap(err, "Unable to open rendered video")
+	}
+
+	return f, nil
+}
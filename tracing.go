@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const telemetryScope = "github.com/Luzifer/cam2mjpeg"
+
+var (
+	tracer = otel.Tracer(telemetryScope)
+	meter  = otel.Meter(telemetryScope)
+
+	telemetry struct {
+		BroadcastDuration metric.Float64Histogram
+		Clients           metric.Int64UpDownCounter
+		FrameLatency      metric.Float64Histogram
+		FrameSize         metric.Int64Histogram
+		Frames            metric.Int64Counter
+		Restarts          metric.Int64Counter
+		WriteDuration     metric.Float64Histogram
+	}
+)
+
+func init() {
+	var err error
+
+	if telemetry.BroadcastDuration, err = meter.Float64Histogram("cam2mjpeg.frame.broadcast.duration",
+		metric.WithDescription("Time spent handing a frame to all subscribers"), metric.WithUnit("s")); err != nil {
+		log.WithError(err).Fatal("Unable to create broadcast duration instrument")
+	}
+
+	if telemetry.Clients, err = meter.Int64UpDownCounter("cam2mjpeg.clients",
+		metric.WithDescription("Number of currently connected clients")); err != nil {
+		log.WithError(err).Fatal("Unable to create clients instrument")
+	}
+
+	if telemetry.FrameLatency, err = meter.Float64Histogram("cam2mjpeg.frame.latency",
+		metric.WithDescription("Time from extracting a frame until it reached the stage (broadcast, write)"), metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5)); err != nil {
+		log.WithError(err).Fatal("Unable to create frame latency instrument")
+	}
+
+	if telemetry.FrameSize, err = meter.Int64Histogram("cam2mjpeg.frame.size",
+		metric.WithDescription("Size of extracted JPEG frames"), metric.WithUnit("By")); err != nil {
+		log.WithError(err).Fatal("Unable to create frame size instrument")
+	}
+
+	if telemetry.Frames, err = meter.Int64Counter("cam2mjpeg.frames",
+		metric.WithDescription("Number of frames extracted from ffmpeg output")); err != nil {
+		log.WithError(err).Fatal("Unable to create frames instrument")
+	}
+
+	if telemetry.Restarts, err = meter.Int64Counter("cam2mjpeg.ffmpeg.restarts",
+		metric.WithDescription("Number of ffmpeg restarts")); err != nil {
+		log.WithError(err).Fatal("Unable to create restarts instrument")
+	}
+
+	if telemetry.WriteDuration, err = meter.Float64Histogram("cam2mjpeg.frame.write.duration",
+		metric.WithDescription("Time spent writing a frame to a client"), metric.WithUnit("s")); err != nil {
+		log.WithError(err).Fatal("Unable to create write duration instrument")
+	}
+}
+
+// setupTelemetry configures OTLP exporters for traces and metrics and
+// the Prometheus metrics handler and returns a function flushing and
+// stopping them
+func setupTelemetry(ctx context.Context) (func(context.Context) error, error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "cam2mjpeg"),
+		attribute.String("service.version", version),
+		attribute.String("camera", cfg.Device),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create telemetry resource")
+	}
+
+	var (
+		metricOpts = []sdkmetric.Option{sdkmetric.WithResource(res)}
+		tp         *sdktrace.TracerProvider
+	)
+
+	if cfg.EnableMetrics {
+		promExporter, err := prometheus.New()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create Prometheus exporter")
+		}
+		metricOpts = append(metricOpts, sdkmetric.WithReader(promExporter))
+	}
+
+	if cfg.OTLPEndpoint != "" {
+		u, err := url.Parse(cfg.OTLPEndpoint)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to parse OTLP endpoint")
+		}
+
+		traceOpts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+		otlpMetricOpts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(u.Host)}
+		if u.Scheme == "http" {
+			traceOpts = append(traceOpts, otlptracehttp.WithInsecure())
+			otlpMetricOpts = append(otlpMetricOpts, otlpmetrichttp.WithInsecure())
+		}
+
+		traceExporter, err := otlptracehttp.New(ctx, traceOpts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create trace exporter")
+		}
+
+		metricExporter, err := otlpmetrichttp.New(ctx, otlpMetricOpts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create metric exporter")
+		}
+		metricOpts = append(metricOpts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)))
+
+		tp = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(traceExporter),
+			sdktrace.WithResource(res),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.OTLPSampleRatio))),
+		)
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	}
+
+	mp := sdkmetric.NewMeterProvider(metricOpts...)
+	otel.SetMeterProvider(mp)
+
+	return func(ctx context.Context) error {
+		if tp != nil {
+			if err := tp.Shutdown(ctx); err != nil {
+				return errors.Wrap(err, "Unable to shut down tracer provider")
+			}
+		}
+		return errors.Wrap(mp.Shutdown(ctx), "Unable to shut down meter provider")
+	}, nil
+}
+
+func tracingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+			))
+		defer span.End()
+
+		w := &responseRecorder{ResponseWriter: res}
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", w.status),
+			attribute.Int64("http.response.body.size", w.bytes),
+		)
+		if w.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(w.status))
+		}
+	})
+}
+
+func observeDuration(ctx context.Context, h metric.Float64Histogram, start time.Time) {
+	h.Record(ctx, time.Since(start).Seconds())
+}
+
+// observeFrameLatency records the time between capturing a frame and
+// it reaching the given stage
+func observeFrameLatency(ctx context.Context, stage string, captured, reached time.Time) {
+	telemetry.FrameLatency.Record(ctx, reached.Sub(captured).Seconds(), metric.WithAttributes(attribute.String("stage", stage)))
+}
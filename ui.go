@@ -0,0 +1,184 @@
+package main
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	//go:embed web
+	webFiles embed.FS
+
+	webTemplates = template.Must(template.ParseFS(webFiles, "web/*.html"))
+)
+
+// loadUIOverrides replaces the embedded templates by the templates of
+// the same name found in the UI directory
+func loadUIOverrides() error {
+	overrides, err := filepath.Glob(filepath.Join(cfg.UIDir, "*.html"))
+	if err != nil {
+		return errors.Wrap(err, "Unable to list UI templates")
+	}
+
+	if len(overrides) == 0 {
+		return nil
+	}
+
+	t, err := webTemplates.Clone()
+	if err != nil {
+		return errors.Wrap(err, "Unable to clone templates")
+	}
+
+	if webTemplates, err = t.ParseFiles(overrides...); err != nil {
+		return errors.Wrap(err, "Unable to parse UI templates")
+	}
+
+	log.WithField("templates", len(overrides)).Info("Loaded UI template overrides")
+	return nil
+}
+
+type adminPage struct {
+	Camera  string
+	Metrics bool
+	Version string
+}
+
+type viewerPage struct {
+	Camera    string
+	FrameRate int
+	Height    int
+	PTZ       bool
+	Version   string
+	Width     int
+}
+
+// renderPage executes the named embedded template into the response
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Cache-Control", "no-store, no-cache")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := webTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.WithError(err).WithField("page", name).Error("Unable to render page")
+	}
+}
+
+func handleEmbed(w http.ResponseWriter, r *http.Request) {
+	cfgLock.RLock()
+	page := viewerPage{Camera: cfg.Device, Height: cfg.Height, Width: cfg.Width}
+	cfgLock.RUnlock()
+
+	// Explicitly allow being framed by other sites
+	w.Header().Set("Content-Security-Policy", "frame-ancestors *")
+	renderPage(w, "embed.html", page)
+}
+
+func handleViewer(w http.ResponseWriter, r *http.Request) {
+	cfgLock.RLock()
+	page := viewerPage{
+		Camera:    cfg.Device,
+		FrameRate: cfg.FrameRate,
+		Height:    cfg.Height,
+		Version:   version,
+		Width:     cfg.Width,
+	}
+	cfgLock.RUnlock()
+
+	// PTZ API is only reachable from the page when served on the same
+	// listener
+	if cfg.AdminListen == "" {
+		if axes, _, err := ptzState(); err == nil && len(axes) > 0 {
+			page.PTZ = true
+		}
+	}
+
+	renderPage(w, "viewer.html", page)
+}
+
+type (
+	dashboardPage struct {
+		Tiles   []dashboardTile
+		Version string
+	}
+
+	dashboardTile struct {
+		Stream string
+		Title  string
+	}
+)
+
+// dashboardTiles returns the local camera followed by the cameras of
+// the config file and the ones configured through --dashboard-camera
+func dashboardTiles() ([]dashboardTile, error) {
+	tiles := []dashboardTile{{Stream: "mjpeg", Title: cfg.Device}}
+
+	cfgLock.RLock()
+	for _, c := range fileSections.Cameras {
+		tiles = append(tiles, c.dashboardTile())
+	}
+	cfgLock.RUnlock()
+
+	for _, entry := range cfg.DashboardCamera {
+		title, stream, ok := strings.Cut(entry, "=")
+		if !ok {
+			stream = entry
+		}
+
+		u, err := url.Parse(stream)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Errorf("Dashboard camera %q needs a HTTP(S) stream URL", entry)
+		}
+
+		if !ok {
+			title = u.Host
+		}
+
+		tiles = append(tiles, dashboardTile{Stream: stream, Title: title})
+	}
+
+	return tiles, nil
+}
+
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	tiles, err := dashboardTiles()
+	if err != nil {
+		// Validated at startup
+		http.Error(w, "500 Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	renderPage(w, "dashboard.html", dashboardPage{Tiles: tiles, Version: version})
+}
+
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	// Changes are refused without token on the main listeners
+	if cfgValue(&cfg.APIToken) == "" && cfg.AdminListen == "" {
+		http.Error(w, "403 Forbidden: admin page requires --api-token or --admin-listen", http.StatusForbidden)
+		return
+	}
+
+	renderPage(w, "admin.html", adminPage{Camera: cfg.Device, Version: version})
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, "stats.html", adminPage{Camera: cfg.Device, Metrics: cfg.EnableMetrics, Version: version})
+}
+
+func handleRecordingsPage(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, "recordings.html", adminPage{Camera: cfg.Device, Version: version})
+}
+
+func handleMobileViewer(w http.ResponseWriter, r *http.Request) {
+	renderPage(w, "mobile.html", viewerPage{
+		Camera:    cfg.Device,
+		FrameRate: cfg.LowStreamFPS,
+		Version:   version,
+		Width:     cfg.LowStreamWidth,
+	})
+}
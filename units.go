@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var byteSizeUnits = []struct {
+	Suffix string
+	Factor int64
+}{
+	// Longest suffixes first as "B" is a suffix of all others
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// parseByteSize parses human readable sizes like "10MB", "512KiB"
+// or plain byte counts
+func parseByteSize(in string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(in))
+	if s == "" {
+		return 0, errors.New("Empty size")
+	}
+
+	factor := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(s, u.Suffix) {
+			factor = u.Factor
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.Suffix))
+			break
+		}
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, errors.Errorf("Invalid size %q", in)
+	}
+
+	return int64(v * float64(factor)), nil
+}
+
+// parseRetention parses durations like "12h" and additionally
+// supports days ("7d") and weeks ("2w") as unit
+func parseRetention(in string) (time.Duration, error) {
+	s := strings.ToLower(strings.TrimSpace(in))
+
+	for suffix, factor := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if !strings.HasSuffix(s, suffix) {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+		if err != nil || v < 0 {
+			return 0, errors.Errorf("Invalid duration %q", in)
+		}
+		return time.Duration(v * float64(factor)), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, errors.Errorf("Invalid duration %q", in)
+	}
+	return d, nil
+}
+
+// formatByteSize renders the size with the largest binary unit keeping
+// the value at or above one
+func formatByteSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	v, i := float64(size), 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+
+	return strconv.FormatFloat(v, 'f', 1, 64) + units[i]
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	uploadQueueSize     = 100
+	uploadRetryDelayMin = 5 * time.Second
+	uploadRetryDelayMax = 5 * time.Minute
+)
+
+var (
+	uploadJobs     = make(chan uploadJob, uploadQueueSize)
+	uploadPrefix   *template.Template
+	uploadTarget   uploader
+	uploadHostname string
+)
+
+// uploader is implemented by all remote storage targets files can be
+// shipped to
+type uploader interface {
+	Upload(ctx context.Context, localPath, remotePath string) error
+	String() string
+}
+
+type uploadJob struct {
+	Kind      string
+	LocalPath string
+	Time      time.Time
+}
+
+// uploadPrefixVars holds the variables available in the upload prefix
+// template
+type uploadPrefixVars struct {
+	Camera   string
+	Filename string
+	Hostname string
+	Kind     string
+	Time     time.Time
+}
+
+// setupUpload parses the upload URL and prefix template into the
+// upload target
+func setupUpload() error {
+	if cfg.UploadURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(cfg.UploadURL)
+	if err != nil {
+		return errors.Wrap(err, "Unable to parse upload URL")
+	}
+
+	switch u.Scheme {
+	case "s3", "s3+http":
+		if uploadTarget, err = newS3Uploader(u); err != nil {
+			return errors.Wrap(err, "Unable to create S3 uploader")
+		}
+
+	case "sftp":
+		if uploadTarget, err = newSFTPUploader(u); err != nil {
+			return errors.Wrap(err, "Unable to create SFTP uploader")
+		}
+
+	case "webdav", "webdavs":
+		if uploadTarget, err = newWebDAVUploader(u); err != nil {
+			return errors.Wrap(err, "Unable to create WebDAV uploader")
+		}
+
+	default:
+		return errors.Errorf("Unsupported upload scheme %q", u.Scheme)
+	}
+
+	if uploadPrefix, err = template.New("prefix").Parse(cfg.UploadPrefix); err != nil {
+		return errors.Wrap(err, "Unable to parse upload prefix")
+	}
+
+	if uploadHostname, err = os.Hostname(); err != nil {
+		return errors.Wrap(err, "Unable to determine hostname")
+	}
+
+	return nil
+}
+
+// queueUpload schedules the file for upload to the configured target
+// and is a no-op if no target is configured
+func queueUpload(kind, localPath string) {
+	if uploadTarget == nil {
+		return
+	}
+
+	select {
+	case uploadJobs <- uploadJob{Kind: kind, LocalPath: localPath, Time: time.Now()}:
+	default:
+		log.WithFields(log.Fields{
+			"kind": kind,
+			"path": localPath,
+		}).Error("Upload queue full, file will not be uploaded")
+	}
+}
+
+// runUploader processes the upload queue until the context is cancelled
+func runUploader(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-uploadJobs:
+			uploadWithRetry(ctx, job)
+		}
+	}
+}
+
+func uploadWithRetry(ctx context.Context, job uploadJob) {
+	logger := log.WithFields(log.Fields{
+		"kind":   job.Kind,
+		"path":   job.LocalPath,
+		"target": uploadTarget.String(),
+	})
+
+	remotePath, err := uploadRemotePath(job)
+	if err != nil {
+		logger.WithError(err).Error("Unable to render upload path")
+		return
+	}
+	logger = logger.WithField("remote", remotePath)
+
+	delay := uploadRetryDelayMin
+	for attempt := 1; ; attempt++ {
+		err := uploadTarget.Upload(ctx, job.LocalPath, remotePath)
+		if err == nil {
+			logger.Info("File uploaded")
+			return
+		}
+
+		if os.IsNotExist(errors.Cause(err)) {
+			logger.WithError(err).Error("File vanished before upload")
+			return
+		}
+
+		if attempt > cfg.UploadRetries {
+			logger.WithError(err).Error("Upload failed, giving up")
+			return
+		}
+
+		logger.WithError(err).WithFields(log.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("Upload failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jitter(delay)):
+		}
+
+		if delay *= 2; delay > uploadRetryDelayMax {
+			delay = uploadRetryDelayMax
+		}
+	}
+}
+
+func uploadRemotePath(job uploadJob) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := uploadPrefix.Execute(buf, uploadPrefixVars{
+		Camera:   strings.Trim(strings.ReplaceAll(cfg.Device, "/", "_"), "_"),
+		Filename: filepath.Base(job.LocalPath),
+		Hostname: uploadHostname,
+		Kind:     job.Kind,
+		Time:     job.Time,
+	}); err != nil {
+		return "", errors.Wrap(err, "Unable to execute prefix template")
+	}
+
+	return strings.TrimLeft(path.Join(buf.String(), filepath.Base(job.LocalPath)), "/"), nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+type s3Uploader struct {
+	bucket string
+	client *minio.Client
+	prefix string
+}
+
+// newS3Uploader creates an uploader from an URL like
+// s3://key:secret@endpoint/bucket/prefix?region=eu-central-1 (the scheme
+// s3+http disables TLS, credentials fall back to the AWS environment)
+func newS3Uploader(u *url.URL) (*s3Uploader, error) {
+	parts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, errors.New("No bucket given in upload URL")
+	}
+
+	var creds *credentials.Credentials
+	if secret, ok := u.User.Password(); ok {
+		creds = credentials.NewStaticV4(u.User.Username(), secret, "")
+	} else {
+		creds = credentials.NewEnvAWS()
+	}
+
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds:  creds,
+		Region: u.Query().Get("region"),
+		Secure: u.Scheme != "s3+http",
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create client")
+	}
+
+	up := &s3Uploader{bucket: parts[0], client: client}
+	if len(parts) > 1 {
+		up.prefix = parts[1]
+	}
+
+	return up, nil
+}
+
+func (s *s3Uploader) Upload(ctx context.Context, localPath, remotePath string) error {
+	if _, err := os.Stat(localPath); err != nil {
+		return errors.Wrap(err, "Unable to access file")
+	}
+
+	_, err := s.client.FPutObject(ctx, s.bucket, path.Join(s.prefix, remotePath), localPath, minio.PutObjectOptions{
+		ContentType: mime.TypeByExtension(path.Ext(localPath)),
+	})
+	return errors.Wrap(err, "Unable to put object")
+}
+
+func (s *s3Uploader) String() string {
+	return "s3://" + s.client.EndpointURL().Host + "/" + path.Join(s.bucket, s.prefix)
+}
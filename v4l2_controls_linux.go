@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Control related subset of the V4L2 API from linux/videodev2.h
+const (
+	v4l2CtrlFlagDisabled  = 0x0001
+	v4l2CtrlFlagGrabbed   = 0x0002
+	v4l2CtrlFlagReadOnly  = 0x0004
+	v4l2CtrlFlagInactive  = 0x0010
+	v4l2CtrlFlagWriteOnly = 0x0040
+	v4l2CtrlFlagNextCtrl  = 0x80000000
+
+	vidiocGCtrl     = 0xc008561b // _IOWR('V', 27, struct v4l2_control)
+	vidiocSCtrl     = 0xc008561c // _IOWR('V', 28, struct v4l2_control)
+	vidiocQueryCtrl = 0xc0445624 // _IOWR('V', 36, struct v4l2_queryctrl)
+	vidiocQueryMenu = 0xc02c5625 // _IOWR('V', 37, struct v4l2_querymenu)
+)
+
+var v4l2CtrlTypes = map[uint32]string{
+	1: "int",
+	2: "bool",
+	3: "menu",
+	4: "button",
+	8: "bitmask",
+	9: "intmenu",
+}
+
+type (
+	v4l2QueryCtrl struct {
+		ID           uint32
+		Type         uint32
+		Name         [32]byte
+		Minimum      int32
+		Maximum      int32
+		Step         int32
+		DefaultValue int32
+		Flags        uint32
+		Reserved     [2]uint32
+	}
+
+	v4l2Control struct {
+		ID    uint32
+		Value int32
+	}
+
+	// v4l2QueryMenu carries either the name or (for integer menus) the
+	// int64 value of the item in Name
+	v4l2QueryMenu struct {
+		ID       uint32
+		Index    uint32
+		Name     [32]byte
+		Reserved uint32
+	}
+)
+
+// Controls enumerates all user accessible controls and their values
+func (v *v4l2Device) Controls() ([]cameraControl, error) {
+	var (
+		controls []cameraControl
+		id       uint32
+	)
+
+	for {
+		qc := v4l2QueryCtrl{ID: id | v4l2CtrlFlagNextCtrl}
+		if err := v.ioctl(vidiocQueryCtrl, unsafe.Pointer(&qc)); err != nil {
+			if err == unix.EINVAL {
+				// No further controls
+				return controls, nil
+			}
+			return nil, errors.Wrap(err, "Unable to query controls")
+		}
+		id = qc.ID
+
+		typ, ok := v4l2CtrlTypes[qc.Type]
+		if !ok || qc.Flags&v4l2CtrlFlagDisabled != 0 {
+			// Control classes, 64 bit and compound controls are not supported
+			continue
+		}
+
+		ctrl := cameraControl{
+			Default: qc.DefaultValue,
+			ID:      qc.ID,
+			Max:     qc.Maximum,
+			Min:     qc.Minimum,
+			Name:    cString(qc.Name[:]),
+			Step:    qc.Step,
+			Type:    typ,
+		}
+		ctrl.Key = controlKey(ctrl.Name)
+
+		for flag, name := range map[uint32]string{
+			v4l2CtrlFlagGrabbed:   "grabbed",
+			v4l2CtrlFlagInactive:  "inactive",
+			v4l2CtrlFlagReadOnly:  "read-only",
+			v4l2CtrlFlagWriteOnly: "write-only",
+		} {
+			if qc.Flags&flag != 0 {
+				ctrl.Flags = append(ctrl.Flags, name)
+			}
+		}
+
+		if qc.Type == 3 || qc.Type == 9 {
+			ctrl.Menu = v.controlMenu(qc)
+		}
+
+		if qc.Type != 4 && qc.Flags&v4l2CtrlFlagWriteOnly == 0 {
+			c := v4l2Control{ID: qc.ID}
+			if err := v.ioctl(vidiocGCtrl, unsafe.Pointer(&c)); err == nil {
+				ctrl.Value = c.Value
+			}
+		}
+
+		controls = append(controls, ctrl)
+	}
+}
+
+// SetControl sets the value of the control with the given ID
+func (v *v4l2Device) SetControl(id uint32, value int32) error {
+	c := v4l2Control{ID: id, Value: value}
+	return errors.Wrap(v.ioctl(vidiocSCtrl, unsafe.Pointer(&c)), "Unable to set control")
+}
+
+func (v *v4l2Device) controlMenu(qc v4l2QueryCtrl) map[int32]string {
+	menu := map[int32]string{}
+	for i := qc.Minimum; i <= qc.Maximum; i++ {
+		qm := v4l2QueryMenu{ID: qc.ID, Index: uint32(i)}
+		if err := v.ioctl(vidiocQueryMenu, unsafe.Pointer(&qm)); err != nil {
+			// Menus may have gaps
+			continue
+		}
+
+		if qc.Type == 9 {
+			menu[i] = strconv.FormatInt(int64(binary.NativeEndian.Uint64(qm.Name[:8])), 10)
+		} else {
+			menu[i] = cString(qm.Name[:])
+		}
+	}
+	return menu
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Format enumeration subset of the V4L2 API from linux/videodev2.h
+const (
+	v4l2FmtFlagCompressed = 0x0001
+
+	v4l2FrmTypeDiscrete = 1
+
+	vidiocEnumFmt            = 0xc0405602 // _IOWR('V', 2, struct v4l2_fmtdesc)
+	vidiocEnumFrameSizes     = 0xc02c564a // _IOWR('V', 74, struct v4l2_frmsizeenum)
+	vidiocEnumFrameIntervals = 0xc034564b // _IOWR('V', 75, struct v4l2_frmivalenum)
+)
+
+type (
+	v4l2FmtDesc struct {
+		Index       uint32
+		Type        uint32
+		Flags       uint32
+		Description [32]byte
+		PixelFormat uint32
+		MbusCode    uint32
+		Reserved    [3]uint32
+	}
+
+	// v4l2FrmSizeEnum holds either the discrete size (first two values)
+	// or the stepwise range (min / max / step of width, then height)
+	v4l2FrmSizeEnum struct {
+		Index       uint32
+		PixelFormat uint32
+		Type        uint32
+		Size        [6]uint32
+		Reserved    [2]uint32
+	}
+
+	// v4l2FrmIvalEnum holds either the discrete interval (first fraction)
+	// or the stepwise range (min, max and step fractions)
+	v4l2FrmIvalEnum struct {
+		Index       uint32
+		PixelFormat uint32
+		Width       uint32
+		Height      uint32
+		Type        uint32
+		Interval    [6]uint32
+		Reserved    [2]uint32
+	}
+)
+
+// Formats enumerates the capture formats with their frame sizes and
+// intervals
+func (v *v4l2Device) Formats() ([]cameraFormat, error) {
+	var formats []cameraFormat
+
+	for i := uint32(0); ; i++ {
+		fd := v4l2FmtDesc{Index: i, Type: v4l2BufTypeVideoCapture}
+		if err := v.ioctl(vidiocEnumFmt, unsafe.Pointer(&fd)); err != nil {
+			if err == unix.EINVAL {
+				return formats, nil
+			}
+			return nil, errors.Wrap(err, "Unable to enumerate formats")
+		}
+
+		f := cameraFormat{
+			Compressed:  fd.Flags&v4l2FmtFlagCompressed != 0,
+			Description: cString(fd.Description[:]),
+			FourCC:      fourCC(fd.PixelFormat),
+		}
+
+		var err error
+		if f.Sizes, f.Stepwise, err = v.frameSizes(fd.PixelFormat); err != nil {
+			return nil, err
+		}
+
+		formats = append(formats, f)
+	}
+}
+
+func (v *v4l2Device) frameSizes(pixFmt uint32) ([]cameraFrameSize, *cameraStepwiseSize, error) {
+	var sizes []cameraFrameSize
+
+	for i := uint32(0); ; i++ {
+		fs := v4l2FrmSizeEnum{Index: i, PixelFormat: pixFmt}
+		if err := v.ioctl(vidiocEnumFrameSizes, unsafe.Pointer(&fs)); err != nil {
+			if err == unix.EINVAL {
+				return sizes, nil, nil
+			}
+			return nil, nil, errors.Wrap(err, "Unable to enumerate frame sizes")
+		}
+
+		if fs.Type != v4l2FrmTypeDiscrete {
+			// Stepwise and continuous sizes are reported as single range
+			return nil, &cameraStepwiseSize{
+				MaxHeight:  fs.Size[4],
+				MaxWidth:   fs.Size[1],
+				MinHeight:  fs.Size[3],
+				MinWidth:   fs.Size[0],
+				StepHeight: fs.Size[5],
+				StepWidth:  fs.Size[2],
+			}, nil
+		}
+
+		size := cameraFrameSize{Height: fs.Size[1], Width: fs.Size[0]}
+
+		var err error
+		if size.Intervals, err = v.frameIntervals(pixFmt, size.Width, size.Height); err != nil {
+			return nil, nil, err
+		}
+
+		sizes = append(sizes, size)
+	}
+}
+
+func (v *v4l2Device) frameIntervals(pixFmt, width, height uint32) ([]cameraFrameInterval, error) {
+	var intervals []cameraFrameInterval
+
+	for i := uint32(0); ; i++ {
+		fi := v4l2FrmIvalEnum{Index: i, PixelFormat: pixFmt, Width: width, Height: height}
+		if err := v.ioctl(vidiocEnumFrameIntervals, unsafe.Pointer(&fi)); err != nil {
+			if err == unix.EINVAL {
+				return intervals, nil
+			}
+			return nil, errors.Wrap(err, "Unable to enumerate frame intervals")
+		}
+
+		if fi.Type != v4l2FrmTypeDiscrete {
+			// Report the range boundaries (minimum interval = maximum fps)
+			return append(intervals,
+				newCameraFrameInterval(fi.Interval[0], fi.Interval[1]),
+				newCameraFrameInterval(fi.Interval[2], fi.Interval[3]),
+			), nil
+		}
+
+		intervals = append(intervals, newCameraFrameInterval(fi.Interval[0], fi.Interval[1]))
+	}
+}
+
+func fourCC(v uint32) string {
+	return string([]byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+}
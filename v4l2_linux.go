@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// Subset of the V4L2 API from linux/videodev2.h
+const (
+	v4l2BufTypeVideoCapture = 1
+	v4l2MemoryMMAP          = 1
+
+	v4l2CapVideoCapture = 0x00000001
+	v4l2CapDeviceCaps   = 0x80000000
+
+	vidiocQueryCap = 0x80685600 // _IOR('V', 0, struct v4l2_capability)
+	vidiocReqBufs  = 0xc0145608 // _IOWR('V', 8, struct v4l2_requestbuffers)
+)
+
+type (
+	v4l2Capability struct {
+		Driver       [16]byte
+		Card         [32]byte
+		BusInfo      [32]byte
+		Version      uint32
+		Capabilities uint32
+		DeviceCaps   uint32
+		Reserved     [3]uint32
+	}
+
+	v4l2RequestBuffers struct {
+		Count        uint32
+		Type         uint32
+		Memory       uint32
+		Capabilities uint32
+		Flags        uint8
+		Reserved     [3]uint8
+	}
+
+	// v4l2Device is an open handle to a video4linux2 device node
+	v4l2Device struct {
+		fd int
+	}
+
+	v4l2DeviceInfo struct {
+		Driver     string
+		Card       string
+		BusInfo    string
+		CanCapture bool
+	}
+)
+
+// checkDeviceAccess checks the current user may read and write the device
+func checkDeviceAccess(path string) error { return unix.Access(path, unix.R_OK|unix.W_OK) }
+
+func openV4L2Device(path string) (*v4l2Device, error) {
+	fd, err := unix.Open(path, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to open device")
+	}
+
+	return &v4l2Device{fd: fd}, nil
+}
+
+func (v *v4l2Device) Close() error { return unix.Close(v.fd) }
+
+// Info queries the capabilities of the device
+func (v *v4l2Device) Info() (v4l2DeviceInfo, error) {
+	var c v4l2Capability
+	if err := v.ioctl(vidiocQueryCap, unsafe.Pointer(&c)); err != nil {
+		return v4l2DeviceInfo{}, errors.Wrap(err, "Unable to query capabilities")
+	}
+
+	caps := c.Capabilities
+	if caps&v4l2CapDeviceCaps != 0 {
+		caps = c.DeviceCaps
+	}
+
+	return v4l2DeviceInfo{
+		Driver:     cString(c.Driver[:]),
+		Card:       cString(c.Card[:]),
+		BusInfo:    cString(c.BusInfo[:]),
+		CanCapture: caps&v4l2CapVideoCapture != 0,
+	}, nil
+}
+
+// IsBusy checks whether another process holds the streaming buffers
+// of the device: requesting zero buffers fails with EBUSY then
+func (v *v4l2Device) IsBusy() (bool, error) {
+	rb := v4l2RequestBuffers{
+		Count:  0,
+		Type:   v4l2BufTypeVideoCapture,
+		Memory: v4l2MemoryMMAP,
+	}
+
+	switch err := v.ioctl(vidiocReqBufs, unsafe.Pointer(&rb)); err {
+	case nil:
+		return false, nil
+	case unix.EBUSY:
+		return true, nil
+	default:
+		return false, errors.Wrap(err, "Unable to request buffers")
+	}
+}
+
+func (v *v4l2Device) ioctl(req uintptr, arg unsafe.Pointer) error {
+	for {
+		_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(v.fd), req, uintptr(arg))
+		switch errno {
+		case 0:
+			return nil
+		case unix.EINTR:
+			continue
+		default:
+			return errno
+		}
+	}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
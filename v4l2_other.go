@@ -0,0 +1,34 @@
+//go:build !linux
+
+package main
+
+import "github.com/pkg/errors"
+
+var errV4L2Unsupported = errors.New("video4linux2 is only supported on Linux")
+
+type (
+	v4l2Device struct{}
+
+	v4l2DeviceInfo struct {
+		Driver     string
+		Card       string
+		BusInfo    string
+		CanCapture bool
+	}
+)
+
+func checkDeviceAccess(path string) error { return nil }
+
+func openV4L2Device(path string) (*v4l2Device, error) { return nil, errV4L2Unsupported }
+
+func (v *v4l2Device) Close() error { return nil }
+
+func (v *v4l2Device) Info() (v4l2DeviceInfo, error) { return v4l2DeviceInfo{}, errV4L2Unsupported }
+
+func (v *v4l2Device) IsBusy() (bool, error) { return false, errV4L2Unsupported }
+
+func (v *v4l2Device) Controls() ([]cameraControl, error) { return nil, errV4L2Unsupported }
+
+func (v *v4l2Device) SetControl(id uint32, value int32) error { return errV4L2Unsupported }
+
+func (v *v4l2Device) Formats() ([]cameraFormat, error) { return nil, errV4L2Unsupported }
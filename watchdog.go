@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type watchdogWebhookPayload struct {
+	Camera    string    `json:"camera"`
+	Event     string    `json:"event"`
+	LastFrame time.Time `json:"last_frame"`
+	Time      time.Time `json:"time"`
+}
+
+// runWatchdog checks for frames being produced and triggers the
+// configured action once per outage when frames stop flowing
+func runWatchdog() {
+	var (
+		logger    = log.WithField("camera", cfg.Device)
+		triggered bool
+	)
+
+	for range time.Tick(time.Second) {
+		if !isCapturing() {
+			// Stopped on purpose (on-demand mode), nothing to watch
+			triggered = false
+			continue
+		}
+
+		// Give a freshly (re-)started ffmpeg the full timeout to
+		// produce its first frame
+		last, ref := lastFrameTime(), captureStartTime()
+		if last.After(ref) {
+			ref = last
+		}
+
+		if time.Since(ref) < cfg.WatchdogTimeout {
+			if triggered {
+				logger.Info("Frames are flowing again")
+			}
+			triggered = false
+			continue
+		}
+
+		if triggered {
+			continue
+		}
+		triggered = true
+
+		logger.WithField("last_frame", last).Error("No frame produced within watchdog timeout")
+
+		switch cfg.WatchdogAction {
+		case "log":
+			// Already logged above
+
+		case "webhook":
+			if err := sendWebhook(cfg.WatchdogWebhook, watchdogWebhookPayload{
+				Camera:    cfg.Device,
+				Event:     "no_frames",
+				LastFrame: last,
+				Time:      time.Now(),
+			}); err != nil {
+				logger.WithError(err).Error("Unable to send watchdog webhook")
+			}
+
+		case "restart":
+			// Restarting resets the reference time so a new outage
+			// is detected after another timeout
+			triggered = false
+			restartCapture()
+
+		case "exit":
+			logger.Error("Exiting as requested by watchdog")
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const webhookTimeout = 10 * time.Second
+
+type clientWebhookPayload struct {
+	Camera     string    `json:"camera"`
+	ClientID   string    `json:"client_id"`
+	Endpoint   string    `json:"endpoint"`
+	Event      string    `json:"event"`
+	RemoteAddr string    `json:"remote_addr"`
+	Time       time.Time `json:"time"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+func notifyClientEvent(event, uid string, r *http.Request) {
+	payload := clientWebhookPayload{
+		Camera:     cfg.Device,
+		ClientID:   uid,
+		Endpoint:   r.URL.Path,
+		Event:      event,
+		RemoteAddr: r.RemoteAddr,
+		Time:       time.Now(),
+		UserAgent:  r.UserAgent(),
+	}
+	events.Publish(eventClient, payload)
+
+	for _, u := range cfgValue(&cfg.ClientWebhook) {
+		go func(u string) {
+			if err := sendWebhook(u, payload); err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"event": event,
+					"id":    uid,
+				}).Error("Unable to send client webhook")
+			}
+		}(u)
+	}
+}
+
+// sendWebhook POSTs the JSON encoded payload to the given URL and
+// expects a 2xx status in return
+func sendWebhook(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal payload")
+	}
+
+	return postWebhook(url, "application/json", body)
+}
+
+// sendWebhookWithAttachment POSTs a multipart form containing the JSON
+// encoded payload as "event" and the JPEG as "snapshot" field
+func sendWebhookWithAttachment(url string, payload interface{}, filename string, jpg []byte) error {
+	event, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "Unable to marshal payload")
+	}
+
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+
+	if err = mw.WriteField("event", string(event)); err != nil {
+		return errors.Wrap(err, "Unable to write event field")
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="snapshot"; filename=%q`, filename))
+	h.Set("Content-Type", "image/jpeg")
+
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create snapshot part")
+	}
+
+	if _, err = part.Write(jpg); err != nil {
+		return errors.Wrap(err, "Unable to write snapshot part")
+	}
+
+	if err = mw.Close(); err != nil {
+		return errors.Wrap(err, "Unable to finish multipart body")
+	}
+
+	return postWebhook(url, mw.FormDataContentType(), body.Bytes())
+}
+
+func postWebhook(url, contentType string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "Unable to create request")
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "cam2mjpeg/"+version)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Unable to execute request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("Unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	wbSearchSteps = 8
+	wbSettle      = 700 * time.Millisecond
+)
+
+// Control keys for the white balance of UVC cameras, the auto key
+// changed in Linux 5.x
+var (
+	wbAutoKeys       = []string{"white_balance_automatic", "white_balance_temperature_auto"}
+	wbBlueKey        = "blue_balance"
+	wbRedKey         = "red_balance"
+	wbTemperatureKey = "white_balance_temperature"
+)
+
+type (
+	// wbLockRequest contains the reference region in coordinates relative
+	// to the image size (x, y, width, height), default is the center
+	wbLockRequest struct {
+		Region []float64 `json:"region,omitempty"`
+	}
+
+	wbLockResponse struct {
+		Controls map[string]int32 `json:"controls"`
+		Mean     [3]float64       `json:"mean_rgb"`
+	}
+)
+
+// handleWhiteBalanceLock disables automatic white balance and searches
+// the white balance settings neutralizing the reference region
+func handleWhiteBalanceLock(w http.ResponseWriter, r *http.Request) {
+	req := wbLockRequest{Region: []float64{0.45, 0.45, 0.1, 0.1}}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Unable to parse white balance request")
+			return
+		}
+	}
+
+	if len(req.Region) != 4 || req.Region[2] <= 0 || req.Region[3] <= 0 ||
+		req.Region[0] < 0 || req.Region[1] < 0 || req.Region[0]+req.Region[2] > 1 || req.Region[1]+req.Region[3] > 1 {
+		writeAPIError(w, http.StatusBadRequest, "Region must be x, y, width, height within 0-1")
+		return
+	}
+
+	resp, err := lockWhiteBalance(r.Context(), req.Region)
+	if err != nil {
+		log.WithError(err).Error("Unable to lock white balance")
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"controls": resp.Controls,
+		"mean_rgb": resp.Mean,
+	}).Info("White balance locked")
+
+	writeAPIResponse(w, http.StatusOK, resp)
+}
+
+func lockWhiteBalance(ctx context.Context, region []float64) (wbLockResponse, error) {
+	controls, err := listControls()
+	if err != nil {
+		return wbLockResponse{}, err
+	}
+
+	byKey := map[string]cameraControl{}
+	for _, c := range controls {
+		byKey[c.Key] = c
+	}
+
+	for _, k := range wbAutoKeys {
+		if _, ok := byKey[k]; ok {
+			if err = setControls(map[string]int32{k: 0}); err != nil {
+				return wbLockResponse{}, err
+			}
+			break
+		}
+	}
+
+	resp := wbLockResponse{Controls: map[string]int32{}}
+
+	red, hasRed := byKey[wbRedKey]
+	blue, hasBlue := byKey[wbBlueKey]
+	temp, hasTemp := byKey[wbTemperatureKey]
+
+	switch {
+	case hasRed && hasBlue:
+		// Independent gains: match red and blue to green
+		for _, c := range []struct {
+			ctrl    cameraControl
+			channel int
+		}{{red, 0}, {blue, 2}} {
+			v, err := searchControl(ctx, c.ctrl, region, func(m [3]float64) float64 { return m[c.channel] - m[1] })
+			if err != nil {
+				return resp, err
+			}
+			resp.Controls[c.ctrl.Key] = v
+		}
+
+	case hasTemp:
+		// Higher temperatures make the image warmer: find where red and
+		// blue are balanced
+		v, err := searchControl(ctx, temp, region, func(m [3]float64) float64 { return m[0] - m[2] })
+		if err != nil {
+			return resp, err
+		}
+		resp.Controls[temp.Key] = v
+
+	default:
+		return resp, errors.New("Camera does not support manual white balance")
+	}
+
+	if resp.Mean, err = sampleRegion(ctx, region); err != nil {
+		return resp, err
+	}
+
+	return resp, nil
+}
+
+// searchControl does a binary search for the value where the error
+// function crosses zero, assuming it rises with the control value
+func searchControl(ctx context.Context, c cameraControl, region []float64, errFn func([3]float64) float64) (int32, error) {
+	lo, hi := c.Min, c.Max
+	best, bestErr := c.Value, math.Inf(1)
+
+	for i := 0; i < wbSearchSteps && lo <= hi; i++ {
+		mid := lo + (hi-lo)/2
+		if c.Step > 1 {
+			mid = c.Min + (mid-c.Min)/c.Step*c.Step
+		}
+
+		if err := setControls(map[string]int32{c.Key: mid}); err != nil {
+			return 0, err
+		}
+
+		m, err := sampleRegion(ctx, region)
+		if err != nil {
+			return 0, err
+		}
+
+		e := errFn(m)
+		if math.Abs(e) < bestErr {
+			best, bestErr = mid, math.Abs(e)
+		}
+
+		if e > 0 {
+			hi = mid - 1
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return best, setControls(map[string]int32{c.Key: best})
+}
+
+// sampleRegion waits for the camera to apply changed settings and
+// returns the mean RGB values of the region in the next frame
+func sampleRegion(ctx context.Context, region []float64) ([3]float64, error) {
+	select {
+	case <-ctx.Done():
+		return [3]float64{}, ctx.Err()
+	case <-time.After(wbSettle):
+	}
+
+	fctx, cancel := context.WithTimeout(ctx, snapshotGrabTimeout)
+	defer cancel()
+
+	data, err := frameBroadcaster.NextFrame(fctx)
+	if err != nil {
+		return [3]float64{}, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data.Data))
+	data.Release()
+	if err != nil {
+		return [3]float64{}, errors.Wrap(err, "Unable to decode frame")
+	}
+
+	b := img.Bounds()
+	rect := image.Rect(
+		b.Min.X+int(region[0]*float64(b.Dx())),
+		b.Min.Y+int(region[1]*float64(b.Dy())),
+		b.Min.X+int((region[0]+region[2])*float64(b.Dx())),
+		b.Min.Y+int((region[1]+region[3])*float64(b.Dy())),
+	).Intersect(b)
+
+	var sum [3]float64
+	var n int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			sum[0] += float64(r >> 8)
+			sum[1] += float64(g >> 8)
+			sum[2] += float64(bl >> 8)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return [3]float64{}, errors.New("Region is empty")
+	}
+
+	return [3]float64{sum[0] / float64(n), sum[1] / float64(n), sum[2] / float64(n)}, nil
+}